@@ -103,9 +103,17 @@ func (a *Application) reinitFromConfig(cfg *config.Config, testOnly bool) (err e
 
 func (a *Application) initCacheZone(cfgCz *config.CacheZone, testOnly bool) (err error) {
 	cz := &types.CacheZone{
-		ID:        cfgCz.ID,
-		PartSize:  cfgCz.PartSize,
-		Scheduler: storage.NewScheduler(a.GetLogger()),
+		ID:           cfgCz.ID,
+		PartSize:     cfgCz.PartSize,
+		Scheduler:    storage.NewScheduler(a.GetLogger()),
+		KeepStaleFor: time.Duration(cfgCz.KeepStaleFor) * time.Second,
+		Tags:         types.NewTagIndex(),
+
+		RefreshAhead:      time.Duration(cfgCz.RefreshAhead.Before) * time.Second,
+		RefreshPopularity: cfgCz.RefreshAhead.MinPopularity,
+		Refresher:         storage.NewRefresher(int(cfgCz.RefreshAhead.Concurrency)),
+		RefreshJobs:       types.NewRefreshJobs(),
+		ReadAheads:        storage.NewRefresher(int(cfgCz.ReadAheadConcurrency)),
 	}
 	// Initialize the storage
 	if cz.Storage, err = storage.New(cfgCz, a.GetLogger()); err != nil {
@@ -118,6 +126,9 @@ func (a *Application) initCacheZone(cfgCz *config.CacheZone, testOnly bool) (err
 		return fmt.Errorf("Could not initialize algorithm '%s' for cache zone '%s': %s",
 			cfgCz.Algorithm, cfgCz.ID, err)
 	}
+	if aware, ok := cz.Storage.(types.PopularityAware); ok {
+		aware.SetPopularity(cz.Algorithm.Popularity)
+	}
 
 	if !testOnly {
 		a.reloadCache(cz)
@@ -164,6 +175,10 @@ func (a *Application) initVirtualHost(cfgVhost *config.VirtualHost, logs accessL
 			CacheKey:              cfgVhost.CacheKey,
 			CacheKeyIncludesQuery: cfgVhost.CacheKeyIncludesQuery,
 			CacheDefaultDuration:  cfgVhost.CacheDefaultDuration,
+			StaleWhileRevalidate:  cfgVhost.StaleWhileRevalidate,
+			StaleIfError:          cfgVhost.StaleIfError,
+			NegativeCacheTTLs:     cfgVhost.NegativeCacheTTLs,
+			CacheKeyTemplate:      newCacheKeyTemplate(&cfgVhost.Location),
 		},
 	}
 	if vhost.Upstream, err = a.getUpstream(cfgVhost.Upstream); err != nil {
@@ -210,6 +225,25 @@ func (a *Application) initVirtualHost(cfgVhost *config.VirtualHost, logs accessL
 	return nil
 }
 
+// newCacheKeyTemplate returns the cache key template of the location or nil
+// if it does not have one.
+func newCacheKeyTemplate(cfg *config.Location) *types.CacheKeyTemplate {
+	var tmpl = cfg.CacheKeyTemplate
+	if tmpl == nil {
+		return nil
+	}
+	return &types.CacheKeyTemplate{
+		Host:           tmpl.Host,
+		LowercasePath:  tmpl.LowercasePath,
+		Query:          cfg.CacheKeyIncludesQuery || len(tmpl.QueryWhitelist) > 0 || len(tmpl.QueryBlacklist) > 0,
+		QueryWhitelist: utils.CopyStringSlice(tmpl.QueryWhitelist),
+		QueryBlacklist: utils.CopyStringSlice(tmpl.QueryBlacklist),
+		SortQuery:      tmpl.SortQuery,
+		Headers:        utils.CopyStringSlice(tmpl.Headers),
+		Cookies:        utils.CopyStringSlice(tmpl.Cookies),
+	}
+}
+
 func (a *Application) initFromConfigLocationsForVHost(cfgLocations []*config.Location, accessLog io.Writer) ([]*types.Location, error) {
 	var err error
 	var locations = make([]*types.Location, len(cfgLocations))
@@ -219,6 +253,10 @@ func (a *Application) initFromConfigLocationsForVHost(cfgLocations []*config.Loc
 			CacheKey:              locCfg.CacheKey,
 			CacheKeyIncludesQuery: locCfg.CacheKeyIncludesQuery,
 			CacheDefaultDuration:  locCfg.CacheDefaultDuration,
+			StaleWhileRevalidate:  locCfg.StaleWhileRevalidate,
+			StaleIfError:          locCfg.StaleIfError,
+			NegativeCacheTTLs:     locCfg.NegativeCacheTTLs,
+			CacheKeyTemplate:      newCacheKeyTemplate(locCfg),
 		}
 		if locations[index].Upstream, err = a.getUpstream(locCfg.Upstream); err != nil {
 			return nil, err
@@ -258,19 +296,28 @@ func (a *Application) reloadCache(cz *types.CacheZone) {
 			}
 		}
 
-		if !utils.IsMetadataFresh(obj) {
+		if !utils.IsMetadataFresh(obj) && !storage.ShouldKeepStale(cz, obj) {
 			if err := cz.Storage.Discard(obj.ID); err != nil {
 				a.GetLogger().Errorf("Error for cache zone `%s` on discarding objID `%s` in reloadCache: %s", cz.ID, obj.ID, err)
 			}
 		} else {
+			// Stale objects which are kept for revalidation are handled
+			// by the expiration handler right away. The popular ones are
+			// refreshed ahead by the cache handlers of their locations.
+			var refresh func()
+			if cz.RefreshAhead > 0 {
+				refresh = cz.RefreshJobs.Job(obj)
+			}
+			//!TODO: Maybe do not use time.Now but cached time. See the todo comment
+			// in utils.IsMetadataFresh.
+			expiresIn := time.Unix(obj.ExpiresAt, 0).Sub(time.Now())
 			cz.Scheduler.AddEvent(
 				obj.ID.Hash(),
-				storage.GetExpirationHandler(cz, obj.ID),
-				//!TODO: Maybe do not use time.Now but cached time. See the todo comment
-				// in utils.IsMetadataFresh.
-				time.Unix(obj.ExpiresAt, 0).Sub(time.Now()),
+				storage.GetRefreshingExpirationHandler(cz, obj.ID, refresh),
+				storage.ExpirationEventIn(cz, expiresIn, refresh != nil),
 			)
 
+			cz.Tags.Set(obj.ID, obj.Tags...)
 			for _, idx := range parts {
 				if err := cz.Algorithm.AddObject(idx); err != nil && err != types.ErrAlreadyInCache {
 					a.GetLogger().Errorf("Error for cache zone `%s` on adding objID `%s` in reloadCache: %s", cz.ID, obj.ID, err)
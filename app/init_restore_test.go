@@ -59,8 +59,8 @@ func TestDiskReload(t *testing.T) {
 	objIDNew := types.NewObjectID("key", "new")
 	objIDOld := types.NewObjectID("key", "old")
 	testutils.ShouldntFail(t,
-		stor.SaveMetadata(&types.ObjectMetadata{ID: objIDNew, ExpiresAt: time.Now().Unix() + 600}),
-		stor.SaveMetadata(&types.ObjectMetadata{ID: objIDOld, ExpiresAt: time.Now().Unix() - 600}),
+		stor.SaveMetadata(&types.ObjectMetadata{ID: objIDNew, ExpiresAt: time.Now().Unix() + 600, Tags: []string{"tag"}}),
+		stor.SaveMetadata(&types.ObjectMetadata{ID: objIDOld, ExpiresAt: time.Now().Unix() - 600, Tags: []string{"tag"}}),
 		stor.SavePart(&types.ObjectIndex{ObjID: objIDNew, Part: 0}, strings.NewReader("test1-1")),
 		stor.SavePart(&types.ObjectIndex{ObjID: objIDNew, Part: 1}, strings.NewReader("test1-2")),
 		stor.SavePart(&types.ObjectIndex{ObjID: objIDOld, Part: 0}, strings.NewReader("test2-1")),
@@ -77,4 +77,8 @@ func TestDiskReload(t *testing.T) {
 	if cacheObjects != expectedObjects {
 		t.Errorf("Expected object count in cache to be %d but it was %d", expectedObjects, cacheObjects)
 	}
+
+	if objects := app.cacheZones["default"].Tags.Objects("tag"); len(objects) != 1 || objects[0].Path() != "new" {
+		t.Errorf("Expected only the fresh object to be in the tag index but got %v", objects)
+	}
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/ironsmile/nedomi/config"
+	"github.com/ironsmile/nedomi/utils"
 )
 
 var (
@@ -68,7 +69,8 @@ func cacheZonesAreCompatible(zones1, zones2 map[string]*config.CacheZone) error
 		if zone2.Type != zone1.Type {
 			return fmt.Errorf(errTmplDifferentType, key)
 		}
-		if zone2.Path != zone1.Path {
+		if zone2.Path != zone1.Path || !utils.EqualStringSlices(zone2.Paths, zone1.Paths) ||
+			zone2.PathsBalancing != zone1.PathsBalancing {
 			return fmt.Errorf(errTmplDifferentPath, key)
 		}
 
@@ -53,6 +53,23 @@ func TestCacheZonesAreCompatible(t *testing.T) {
 			},
 			err: "different types for same id 'pesho' between configs",
 		},
+		{ // different disks
+			cfg1: map[string]*config.CacheZone{
+				"pesho": {
+					ID:    "pesho",
+					Type:  "jbod",
+					Paths: []string{"/disk1", "/disk2"},
+				},
+			},
+			cfg2: map[string]*config.CacheZone{
+				"pesho": {
+					ID:    "pesho",
+					Type:  "jbod",
+					Paths: []string{"/disk1", "/disk2", "/disk3"},
+				},
+			},
+			err: "different paths for same id 'pesho' between configs",
+		},
 		{ // different paths
 			cfg1: map[string]*config.CacheZone{
 				"pesho": {
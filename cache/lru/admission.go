@@ -0,0 +1,170 @@
+package lru
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/ironsmile/nedomi/config"
+	"github.com/ironsmile/nedomi/types"
+)
+
+const (
+	// The number of rows of counters in the frequency sketch. Each one uses
+	// 4 bytes of the object hash as an index.
+	sketchDepth = 4
+	// The limits for the number of counters in each row of the sketch.
+	minSketchWidth = 1 << 10
+	maxSketchWidth = 1 << 24
+)
+
+// admissionPolicy decides whether an object should be stored in the cache.
+type admissionPolicy interface {
+	admit(types.ObjectIDHash) bool
+}
+
+func newAdmissionPolicy(cfg config.Admission, storageObjects uint64) admissionPolicy {
+	window := time.Duration(cfg.Window) * time.Second
+	switch cfg.Policy {
+	case config.AdmitNthRequest:
+		return newRequestCounter(cfg.MinRequests, window)
+	case config.AdmitFrequent:
+		return newFrequencySketch(cfg.MinRequests, window, storageObjects)
+	}
+	return admitAlways{}
+}
+
+// admitAlways admits every object.
+type admitAlways struct{}
+
+func (admitAlways) admit(types.ObjectIDHash) bool {
+	return true
+}
+
+// requestCounter admits objects on their Nth request within the window.
+type requestCounter struct {
+	sync.Mutex
+	minRequests uint64
+	window      time.Duration
+	now         func() time.Time
+	lastSweep   time.Time
+	requests    map[types.ObjectIDHash]*objectRequests
+}
+
+type objectRequests struct {
+	first time.Time
+	count uint64
+}
+
+func newRequestCounter(minRequests uint64, window time.Duration) *requestCounter {
+	return &requestCounter{
+		minRequests: minRequests,
+		window:      window,
+		now:         time.Now,
+		lastSweep:   time.Now(),
+		requests:    make(map[types.ObjectIDHash]*objectRequests),
+	}
+}
+
+func (rc *requestCounter) admit(hash types.ObjectIDHash) bool {
+	rc.Lock()
+	defer rc.Unlock()
+
+	now := rc.now()
+	if now.Sub(rc.lastSweep) >= rc.window {
+		rc.sweep(now)
+	}
+
+	req, ok := rc.requests[hash]
+	if !ok || now.Sub(req.first) >= rc.window {
+		req = &objectRequests{first: now}
+		rc.requests[hash] = req
+	}
+	req.count++
+	if req.count < rc.minRequests {
+		return false
+	}
+	// if the object is evicted, it has to be requested N times again
+	delete(rc.requests, hash)
+	return true
+}
+
+// sweep removes the objects whose window has passed.
+func (rc *requestCounter) sweep(now time.Time) {
+	for hash, req := range rc.requests {
+		if now.Sub(req.first) >= rc.window {
+			delete(rc.requests, hash)
+		}
+	}
+	rc.lastSweep = now
+}
+
+// frequencySketch admits objects once their estimated request frequency
+// reaches the minimum. The frequencies are estimated with a count-min sketch
+// whose counters are halved every window, so that objects which were popular
+// a long time ago are forgotten.
+type frequencySketch struct {
+	sync.Mutex
+	minRequests uint64
+	window      time.Duration
+	now         func() time.Time
+	lastAging   time.Time
+	mask        uint32
+	rows        [sketchDepth][]uint8
+}
+
+func newFrequencySketch(minRequests uint64, window time.Duration, storageObjects uint64) *frequencySketch {
+	var width uint64 = minSketchWidth
+	for width < storageObjects && width < maxSketchWidth {
+		width <<= 1
+	}
+	fs := &frequencySketch{
+		minRequests: minRequests,
+		window:      window,
+		now:         time.Now,
+		lastAging:   time.Now(),
+		mask:        uint32(width - 1),
+	}
+	for i := range fs.rows {
+		fs.rows[i] = make([]uint8, width)
+	}
+	return fs
+}
+
+func (fs *frequencySketch) admit(hash types.ObjectIDHash) bool {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if now := fs.now(); now.Sub(fs.lastAging) >= fs.window {
+		fs.age()
+		fs.lastAging = now
+	}
+
+	var counters [sketchDepth]*uint8
+	var estimate uint8 = 255
+	for i := range fs.rows {
+		counters[i] = &fs.rows[i][binary.LittleEndian.Uint32(hash[i*4:])&fs.mask]
+		if *counters[i] < estimate {
+			estimate = *counters[i]
+		}
+	}
+	if estimate < 255 {
+		estimate++
+		// conservative update - only the smallest counters are incremented
+		for _, counter := range counters {
+			if *counter < estimate {
+				*counter = estimate
+			}
+		}
+	}
+	return uint64(estimate) >= fs.minRequests
+}
+
+// age halves all the counters of the sketch.
+func (fs *frequencySketch) age() {
+	for _, row := range fs.rows {
+		for i := range row {
+			row[i] >>= 1
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package lru
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/config"
+	"github.com/ironsmile/nedomi/mock"
+	"github.com/ironsmile/nedomi/types"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestAdmitAlwaysByDefault(t *testing.T) {
+	t.Parallel()
+	lru := New(getCacheZone(), mockRemove, mock.NewLogger())
+	if !lru.ShouldAdmit(types.NewObjectID("1.1", "/path")) {
+		t.Error("expected the object to be admitted without an admission policy")
+	}
+}
+
+func TestNthRequestAdmission(t *testing.T) {
+	t.Parallel()
+	cz := getCacheZone()
+	cz.Admission = config.Admission{Policy: config.AdmitNthRequest, MinRequests: 3, Window: 60}
+	lru := New(cz, mockRemove, mock.NewLogger())
+	clock := &fakeClock{now: time.Now()}
+	policy := lru.admission.(*requestCounter)
+	policy.now = clock.Now
+
+	id := types.NewObjectID("1.1", "/path")
+	other := types.NewObjectID("1.1", "/other")
+	var expected = []bool{false, false, true, false}
+	for i, admitted := range expected {
+		if got := lru.ShouldAdmit(id); got != admitted {
+			t.Errorf("expected request %d to be admitted to be %t", i+1, admitted)
+		}
+	}
+	if lru.ShouldAdmit(other) {
+		t.Error("expected the first request for another object not to be admitted")
+	}
+
+	// the first request from above is out of the window
+	clock.now = clock.now.Add(time.Minute)
+	if lru.ShouldAdmit(id) || lru.ShouldAdmit(id) || !lru.ShouldAdmit(id) {
+		t.Error("expected the requests from the previous window to be forgotten")
+	}
+	policy.Lock()
+	defer policy.Unlock()
+	if _, ok := policy.requests[other.Hash()]; ok {
+		t.Error("expected the requests from the previous window to be swept")
+	}
+}
+
+func TestFrequencySketchAdmission(t *testing.T) {
+	t.Parallel()
+	cz := getCacheZone()
+	cz.Admission = config.Admission{Policy: config.AdmitFrequent, MinRequests: 4, Window: 60}
+	lru := New(cz, mockRemove, mock.NewLogger())
+	clock := &fakeClock{now: time.Now()}
+	policy := lru.admission.(*frequencySketch)
+	policy.now = clock.Now
+
+	id := types.NewObjectID("1.1", "/path")
+	for i := 1; i < 4; i++ {
+		if lru.ShouldAdmit(id) {
+			t.Errorf("expected request %d not to be admitted", i)
+		}
+	}
+	if !lru.ShouldAdmit(id) || !lru.ShouldAdmit(id) {
+		t.Error("expected the frequent object to be admitted")
+	}
+
+	// 5 requests are halved to 2
+	clock.now = clock.now.Add(time.Minute)
+	if lru.ShouldAdmit(id) {
+		t.Error("expected the frequency of the object to be halved")
+	}
+	if !lru.ShouldAdmit(id) {
+		t.Error("expected the object to be admitted after enough requests")
+	}
+
+	for i := 0; i < 100; i++ {
+		if lru.ShouldAdmit(types.NewObjectID("1.1", "/path/"+string(rune('a'+i)))) {
+			t.Errorf("expected the first request for object %d not to be admitted", i)
+		}
+	}
+}
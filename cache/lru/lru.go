@@ -50,6 +50,8 @@ type TieredLRUCache struct {
 
 	removeFunc func(*types.ObjectIndex) error
 
+	admission admissionPolicy
+
 	// Used to track cache hit/miss information
 	requests uint64
 	hits     uint64
@@ -79,6 +81,12 @@ func (tc *TieredLRUCache) ShouldKeep(oi *types.ObjectIndex) bool {
 	return true
 }
 
+// ShouldAdmit implements part of types.CacheAlgorithm interface. The decision
+// is made by the admission policy of the cache zone.
+func (tc *TieredLRUCache) ShouldAdmit(id *types.ObjectID) bool {
+	return tc.admission.admit(id.Hash())
+}
+
 // AddObject implements part of types.CacheAlgorithm interface
 func (tc *TieredLRUCache) AddObject(oi *types.ObjectIndex) error {
 	tc.mutex.Lock()
@@ -236,6 +244,20 @@ func (tc *TieredLRUCache) PromoteObject(oi *types.ObjectIndex) {
 	upperListLastLruEl.ListTier = lruEl.ListTier
 }
 
+// Popularity implements part of types.CacheAlgorithm interface. The objects
+// in the uppermost tier are the most popular ones and the ones in the
+// lowermost tier have been used the least since they were added.
+func (tc *TieredLRUCache) Popularity(oi *types.ObjectIndex) float64 {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	lruEl, ok := tc.lookup[oi.Hash()]
+	if !ok {
+		return 0
+	}
+	return float64(cacheTiers-lruEl.ListTier) / cacheTiers
+}
+
 func (tc *TieredLRUCache) checkTiers() {
 	for i := 0; i < cacheTiers; i++ {
 		if tc.tiers[i].Len() > tc.tierListSize {
@@ -278,6 +300,7 @@ func New(cz *config.CacheZone, removeFunc func(*types.ObjectIndex) error,
 	lru := &TieredLRUCache{
 		cfg:        cz,
 		removeFunc: removeFunc,
+		admission:  newAdmissionPolicy(cz.Admission, cz.StorageObjects),
 	}
 	lru.SetLogger(logger)
 	lru.init()
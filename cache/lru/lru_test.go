@@ -169,6 +169,37 @@ func TestPromotionsInEmptyCache(t *testing.T) {
 	}
 }
 
+func TestPopularity(t *testing.T) {
+	t.Parallel()
+	cz := getCacheZone()
+	oi := getObjectIndex()
+	lru := New(cz, nil, mock.NewLogger())
+
+	if popularity := lru.Popularity(oi); popularity != 0 {
+		t.Errorf("Expected the missing object to have no popularity but it has %g", popularity)
+	}
+
+	var previous float64
+	for i := 0; i < cacheTiers; i++ {
+		lru.PromoteObject(oi)
+		popularity := lru.Popularity(oi)
+		if popularity <= previous {
+			t.Errorf("Expected the promoted object to be more popular than %g but it is %g",
+				previous, popularity)
+		}
+		previous = popularity
+	}
+
+	if previous != 1 {
+		t.Errorf("Expected the object in the uppermost tier to have popularity 1 but it has %g", previous)
+	}
+
+	lru.Remove(oi)
+	if popularity := lru.Popularity(oi); popularity != 0 {
+		t.Errorf("Expected the removed object to have no popularity but it has %g", popularity)
+	}
+}
+
 func TestPromotionInFullCache(t *testing.T) {
 	t.Parallel()
 
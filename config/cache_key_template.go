@@ -0,0 +1,26 @@
+package config
+
+import "fmt"
+
+// CacheKeyTemplate describes which parts of the client requests, apart from
+// the path, make up the keys of the cached objects. The query is included if
+// cache_key_includes_query is set or if there is a query whitelist or
+// blacklist. Parameter names ending with `*` match all names starting with
+// them, so `utm_*` removes all the tracking parameters.
+type CacheKeyTemplate struct {
+	Host           bool        `json:"host"`
+	LowercasePath  bool        `json:"lowercase_path"`
+	QueryWhitelist StringSlice `json:"query_whitelist"`
+	QueryBlacklist StringSlice `json:"query_blacklist"`
+	SortQuery      bool        `json:"sort_query"`
+	Headers        StringSlice `json:"headers"`
+	Cookies        StringSlice `json:"cookies"`
+}
+
+// Validate checks the cache key template for errors.
+func (c *CacheKeyTemplate) Validate(name fmt.Stringer) error {
+	if len(c.QueryWhitelist) > 0 && len(c.QueryBlacklist) > 0 {
+		return fmt.Errorf("Cache key template in %s can not have both a query whitelist and blacklist", name)
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ironsmile/nedomi/types"
 	"github.com/ironsmile/nedomi/utils"
 )
 
@@ -36,6 +37,12 @@ func TestExampleConfig(t *testing.T) {
 		t.Errorf("Example config verification had error: %s", err)
 	}
 
+	for id, zone := range cfg.CacheZones {
+		if zone.MetadataCacheSize != DefaultMetadataCacheSize {
+			t.Errorf("Expected the default metadata cache size for zone %s but got %d",
+				id, zone.MetadataCacheSize)
+		}
+	}
 }
 
 func getNormalConfig() *Config {
@@ -51,6 +58,7 @@ func getNormalConfig() *Config {
 		StorageObjects: 20,
 		PartSize:       1024,
 		Algorithm:      "lru",
+		RefreshAhead:   RefreshAhead{Concurrency: 4},
 	}
 	c.CacheZones = map[string]*CacheZone{"test1": cz}
 
@@ -118,6 +126,32 @@ func TestConfigVerification(t *testing.T) {
 		"No error with wrong cache default duration in vhost": func(cfg *Config) {
 			cfg.HTTP.Servers[0].CacheDefaultDuration = -1 * time.Hour
 		},
+		"No error with unknown cache admission policy": func(cfg *Config) {
+			cfg.CacheZones["test1"].Admission.Policy = "random"
+		},
+		"No error with cache admission policy without a window": func(cfg *Config) {
+			cfg.CacheZones["test1"].Admission = Admission{Policy: AdmitNthRequest, MinRequests: 2}
+		},
+		"No error with disk cache zone without a path": func(cfg *Config) {
+			cfg.CacheZones["test1"].Path = ""
+		},
+		"No error with jbod cache zone without paths": func(cfg *Config) {
+			cfg.CacheZones["test1"].Type = "jbod"
+		},
+		"No error with memory cache zone smaller than its storage objects": func(cfg *Config) {
+			cz := cfg.CacheZones["test1"]
+			cz.Type = "memory"
+			cz.MemorySize = types.BytesSize(cz.StorageObjects*cz.PartSize.Bytes() - 1)
+		},
+		"No error with negative memory tier popularity": func(cfg *Config) {
+			cfg.CacheZones["test1"].MemoryMinPopularity = -0.5
+		},
+		"No error with refresh-ahead popularity above 1": func(cfg *Config) {
+			cfg.CacheZones["test1"].RefreshAhead = RefreshAhead{Before: 10, MinPopularity: 1.5, Concurrency: 2}
+		},
+		"No error with refresh-ahead without concurrency": func(cfg *Config) {
+			cfg.CacheZones["test1"].RefreshAhead = RefreshAhead{Before: 10, MinPopularity: 0.5}
+		},
 	}
 
 	for errorStr, fnc := range tests {
@@ -127,6 +161,21 @@ func TestConfigVerification(t *testing.T) {
 			t.Errorf(errorStr)
 		}
 	}
+
+	cfg = getNormalConfig()
+	cfg.CacheZones["test1"].Type = "memory"
+	cfg.CacheZones["test1"].Path = ""
+	if err := ValidateRecursive(cfg); err != nil {
+		t.Errorf("Got error on memory cache zone without a path: %s", err)
+	}
+
+	cfg = getNormalConfig()
+	cfg.CacheZones["test1"].Type = "jbod"
+	cfg.CacheZones["test1"].Path = ""
+	cfg.CacheZones["test1"].Paths = []string{"/disk1", "/disk2"}
+	if err := ValidateRecursive(cfg); err != nil {
+		t.Errorf("Got error on jbod cache zone without a path: %s", err)
+	}
 }
 
 func TestHandlersParsing(t *testing.T) {
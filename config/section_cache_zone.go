@@ -2,10 +2,22 @@ package config
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/ironsmile/nedomi/types"
 )
 
+// The policies which decide whether cacheable objects are stored in the cache.
+const (
+	// AdmitAlways stores every cacheable object.
+	AdmitAlways = "always"
+	// AdmitNthRequest stores objects on their Nth request within the window.
+	AdmitNthRequest = "nth_request"
+	// AdmitFrequent stores objects once their request frequency, estimated
+	// with a frequency sketch which is halved every window, reaches N.
+	AdmitFrequent = "frequency_sketch"
+)
+
 // CacheZone contains all configuration options for cache zones.
 type CacheZone struct {
 	ID                 string
@@ -17,16 +29,115 @@ type CacheZone struct {
 	BulkRemoveCount    uint64          `json:"bulk_remove_count"`
 	BulkRemoveTimeout  uint64          `json:"bulk_remove_timeout"`
 	SkipCacheKeyInPath bool            `json:"skip_cache_key_in_path"`
+	// Paths are the disks of the zones which are spread across multiple
+	// disks and PathsBalancing is how the objects are placed on them.
+	Paths          []string `json:"paths"`
+	PathsBalancing string   `json:"paths_balancing"`
+	// MemorySize is the maximum size of the parts in the zones which are
+	// stored in memory. It defaults to storage_objects times part_size.
+	// For the tiered zones it is the size of their memory tier.
+	MemorySize types.BytesSize `json:"memory_size"`
+	// MemoryMinPopularity is the popularity from 0 to 1, as reported by the
+	// cache algorithm, which the parts in the tiered zones need in order to
+	// be copied in their memory tier.
+	MemoryMinPopularity float64 `json:"memory_min_popularity"`
+	// KeepStaleFor is the number of seconds for which expired objects that
+	// can be revalidated with the upstream are kept in the storage.
+	KeepStaleFor uint64 `json:"keep_stale_for"`
+	// MetadataCacheSize is the maximum number of objects whose metadata is
+	// kept in memory by the disk storage. Zero disables the caching.
+	MetadataCacheSize uint64 `json:"metadata_cache_size"`
+	// Admission decides which cacheable objects are stored in the zone.
+	Admission Admission `json:"admission"`
+	// RefreshAhead configures the background refreshing of the popular
+	// objects before they expire.
+	RefreshAhead RefreshAhead `json:"refresh_ahead"`
+	// ReadAheadConcurrency is the maximum number of objects in the zone
+	// whose parts are read ahead by the cache handlers at the same time.
+	ReadAheadConcurrency uint64 `json:"read_ahead_concurrency"`
+}
+
+// Admission contains the configuration of the cache admission policy.
+type Admission struct {
+	Policy string `json:"policy"`
+	// MinRequests is the number of requests for an object after which it
+	// is stored in the cache.
+	MinRequests uint64 `json:"min_requests"`
+	// Window is the number of seconds for which the requests are counted.
+	Window uint64 `json:"window"`
+}
+
+// Validate checks the admission policy configuration for errors.
+func (a *Admission) Validate() error {
+	switch a.Policy {
+	case "", AdmitAlways:
+		return nil
+	case AdmitNthRequest, AdmitFrequent:
+	default:
+		return fmt.Errorf("unknown cache admission policy `%s`", a.Policy)
+	}
+
+	if a.MinRequests == 0 || a.Window == 0 {
+		return fmt.Errorf("cache admission policy `%s` needs min_requests and window", a.Policy)
+	}
+	return nil
+}
+
+// RefreshAhead contains the configuration of the background refreshing of
+// the popular objects before they expire.
+type RefreshAhead struct {
+	// Before is the number of seconds before their expiration in which the
+	// popular objects are refreshed. Zero disables the refreshing.
+	Before uint64 `json:"before"`
+	// MinPopularity is the popularity from 0 to 1, as reported by the cache
+	// algorithm, which the objects need in order to be refreshed.
+	MinPopularity float64 `json:"min_popularity"`
+	// Concurrency is the maximum number of objects in the zone which are
+	// refreshed at the same time, either ahead of their expiration or when
+	// they are served stale while revalidating.
+	Concurrency uint64 `json:"concurrency"`
+}
+
+// Validate checks the refresh-ahead configuration for errors.
+func (r *RefreshAhead) Validate() error {
+	if r.MinPopularity < 0 || r.MinPopularity > 1 {
+		return fmt.Errorf("refresh_ahead min_popularity should be between 0 and 1, not %g", r.MinPopularity)
+	}
+	if r.Concurrency == 0 {
+		return errors.New("refresh_ahead needs concurrency")
+	}
+	return nil
 }
 
 // Validate checks a CacheZone config section for errors.
 func (cz *CacheZone) Validate() error {
 	//!TODO: support flexible type and config check for different modules
-	if cz.ID == "" || cz.Type == "" || cz.Path == "" || cz.Algorithm == "" || cz.PartSize == 0 {
+	if cz.ID == "" || cz.Type == "" || cz.Algorithm == "" || cz.PartSize == 0 {
 		return errors.New("missing or invalid information in the cache zone config section")
 	}
+	// the zones which are stored in memory or on multiple disks have no path
+	if cz.Path == "" && cz.Type != "memory" && cz.Type != "jbod" {
+		return errors.New("missing path in the cache zone config section")
+	}
+	if len(cz.Paths) == 0 && cz.Type == "jbod" {
+		return errors.New("missing paths in the jbod cache zone config section")
+	}
 
-	return nil
+	// the cache algorithm keeps up to storage_objects parts in memory zones
+	if cz.Type == "memory" && cz.MemorySize != 0 &&
+		cz.MemorySize.Bytes() < cz.StorageObjects*cz.PartSize.Bytes() {
+		return fmt.Errorf("memory_size should fit storage_objects times part_size (%d bytes) in memory cache zones",
+			cz.StorageObjects*cz.PartSize.Bytes())
+	}
+
+	if cz.MemoryMinPopularity < 0 || cz.MemoryMinPopularity > 1 {
+		return fmt.Errorf("memory_min_popularity should be between 0 and 1, not %g", cz.MemoryMinPopularity)
+	}
+
+	if err := cz.Admission.Validate(); err != nil {
+		return err
+	}
+	return cz.RefreshAhead.Validate()
 }
 
 // GetSubsections returns nil (CacheZone has no subsections).
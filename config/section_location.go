@@ -3,6 +3,9 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,9 +17,17 @@ type baseLocation struct {
 	CacheZone             string    `json:"cache_zone"`
 	CacheKey              string    `json:"cache_key"`
 	CacheDefaultDuration  string    `json:"cache_default_duration"`
+	StaleWhileRevalidate  string    `json:"stale_while_revalidate"`
+	StaleIfError          string    `json:"stale_if_error"`
 	Handlers              []Handler `json:"handlers"`
 	Logger                Logger    `json:"logger"`
 	CacheKeyIncludesQuery bool      `json:"cache_key_includes_query"`
+
+	// NegativeCache maps status codes, like "404", or classes of status codes,
+	// like "5xx", to the duration for which such responses are cached.
+	NegativeCache map[string]string `json:"negative_cache"`
+
+	CacheKeyTemplate *CacheKeyTemplate `json:"cache_key_template"`
 }
 
 // Location contains all configuration options for virtual host's location.
@@ -24,6 +35,9 @@ type Location struct {
 	baseLocation
 	CacheZone            *CacheZone
 	CacheDefaultDuration time.Duration
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	NegativeCacheTTLs    map[int]time.Duration
 	parent               *VirtualHost
 }
 
@@ -51,6 +65,20 @@ func (ls *Location) UnmarshalJSON(buff []byte) error {
 		ls.CacheDefaultDuration = dur
 	}
 
+	var parent *Location
+	if ls.parent != nil {
+		parent = &ls.parent.Location
+	}
+	if err := ls.parseStaleDurations(ls, parent); err != nil {
+		return err
+	}
+	if err := ls.parseNegativeCache(ls, parent); err != nil {
+		return err
+	}
+	if ls.baseLocation.CacheKeyTemplate == nil && parent != nil {
+		ls.baseLocation.CacheKeyTemplate = parent.baseLocation.CacheKeyTemplate
+	}
+
 	// Inject the cache zone configuration from the root config
 	if cz, ok := ls.parent.parent.parent.CacheZones[ls.baseLocation.CacheZone]; ok {
 		ls.CacheZone = cz
@@ -76,6 +104,103 @@ func (ls *Location) Validate() error {
 		return fmt.Errorf("Cache default duration in %s must be positive", ls)
 	}
 
+	if ls.StaleWhileRevalidate < 0 {
+		return fmt.Errorf("Stale while revalidate duration in %s must not be negative", ls)
+	}
+
+	if ls.StaleIfError < 0 {
+		return fmt.Errorf("Stale if error duration in %s must not be negative", ls)
+	}
+
+	if ls.CacheKeyTemplate != nil {
+		if err := ls.CacheKeyTemplate.Validate(ls); err != nil {
+			return err
+		}
+	}
+
+	return validateNegativeCache(ls, ls.NegativeCacheTTLs)
+}
+
+// parseStaleDurations converts the stale_while_revalidate and stale_if_error
+// strings to time.Duration. The ones which are not set are inherited from the
+// parent location, if there is one.
+func (ls *Location) parseStaleDurations(name fmt.Stringer, parent *Location) error {
+	if ls.baseLocation.StaleWhileRevalidate == "" {
+		if parent != nil {
+			ls.StaleWhileRevalidate = parent.StaleWhileRevalidate
+		}
+	} else if dur, err := time.ParseDuration(ls.baseLocation.StaleWhileRevalidate); err != nil {
+		return fmt.Errorf("Error parsing %s's stale_while_revalidate: %s", name, err)
+	} else {
+		ls.StaleWhileRevalidate = dur
+	}
+
+	if ls.baseLocation.StaleIfError == "" {
+		if parent != nil {
+			ls.StaleIfError = parent.StaleIfError
+		}
+	} else if dur, err := time.ParseDuration(ls.baseLocation.StaleIfError); err != nil {
+		return fmt.Errorf("Error parsing %s's stale_if_error: %s", name, err)
+	} else {
+		ls.StaleIfError = dur
+	}
+
+	return nil
+}
+
+// parseNegativeCache converts the negative_cache durations to a map from the
+// status codes to durations. The classes of status codes are expanded to all
+// the codes in them which are not set explicitly. If it is not set, it is
+// inherited from the parent location, if there is one.
+func (ls *Location) parseNegativeCache(name fmt.Stringer, parent *Location) error {
+	if ls.baseLocation.NegativeCache == nil {
+		if parent != nil {
+			ls.NegativeCacheTTLs = parent.NegativeCacheTTLs
+		}
+		return nil
+	}
+
+	ls.NegativeCacheTTLs = make(map[int]time.Duration)
+	var classes = make(map[int]time.Duration)
+	for status, durStr := range ls.baseLocation.NegativeCache {
+		dur, err := time.ParseDuration(durStr)
+		if err != nil {
+			return fmt.Errorf("Error parsing %s's negative_cache for %s: %s", name, status, err)
+		}
+		if len(status) == 3 && strings.HasSuffix(strings.ToLower(status), "xx") {
+			if class, err := strconv.Atoi(status[:1]); err == nil {
+				classes[class*100] = dur
+				continue
+			}
+		}
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			return fmt.Errorf("Invalid status code `%s` in %s's negative_cache", status, name)
+		}
+		ls.NegativeCacheTTLs[code] = dur
+	}
+
+	for class, dur := range classes {
+		for code := class; code < class+100; code++ {
+			if _, ok := ls.NegativeCacheTTLs[code]; !ok {
+				ls.NegativeCacheTTLs[code] = dur
+			}
+		}
+	}
+	return nil
+}
+
+// validateNegativeCache checks that only redirect and error responses are
+// cached negatively and that their durations are positive.
+func validateNegativeCache(name fmt.Stringer, ttls map[int]time.Duration) error {
+	for code, ttl := range ttls {
+		if code < http.StatusMultipleChoices || code > 599 || code == http.StatusNotModified {
+			return fmt.Errorf("Status %d in %s can not be cached negatively", code, name)
+		}
+		if ttl <= 0 {
+			return fmt.Errorf("Negative cache duration for status %d in %s must be positive", code, name)
+		}
+	}
 	return nil
 }
 
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"reflect"
 	"testing"
 	"time"
 )
@@ -140,3 +141,160 @@ func newLocForTesting() *Location {
 
 	return loc
 }
+
+func TestLocationStaleWhileRevalidate(t *testing.T) {
+	t.Parallel()
+	var tests = map[string]time.Duration{
+		`{"cache_zone": "default", "handlers": [{"type": "cache"}]}`:                                  time.Minute,
+		`{"cache_zone": "default", "handlers": [{"type": "cache"}], "stale_while_revalidate": "30s"}`: 30 * time.Second,
+		`{"cache_zone": "default", "handlers": [{"type": "cache"}], "stale_while_revalidate": "0s"}`:  0,
+	}
+	for section, expected := range tests {
+		loc := newLocForTesting()
+		loc.parent.StaleWhileRevalidate = time.Minute
+		if err := loc.UnmarshalJSON([]byte(section)); err != nil {
+			t.Errorf("Error while unmarshalling %s: %s", section, err)
+			continue
+		}
+		if err := loc.Validate(); err != nil {
+			t.Errorf("Error while verifying %s: %s", section, err)
+		}
+		if loc.StaleWhileRevalidate != expected {
+			t.Errorf("Expected stale while revalidate of %s for %s but got %s",
+				expected, section, loc.StaleWhileRevalidate)
+		}
+	}
+
+	loc := newLocForTesting()
+	if err := loc.UnmarshalJSON([]byte(`{"cache_zone": "default", "stale_while_revalidate": "baba"}`)); err == nil {
+		t.Error("No error while unmarshalling an invalid stale_while_revalidate")
+	}
+}
+
+func TestLocationStaleIfError(t *testing.T) {
+	t.Parallel()
+	var tests = map[string]time.Duration{
+		`{"cache_zone": "default", "handlers": [{"type": "cache"}]}`:                         time.Hour,
+		`{"cache_zone": "default", "handlers": [{"type": "cache"}], "stale_if_error": "5m"}`: 5 * time.Minute,
+	}
+	for section, expected := range tests {
+		loc := newLocForTesting()
+		loc.parent.StaleIfError = time.Hour
+		if err := loc.UnmarshalJSON([]byte(section)); err != nil {
+			t.Errorf("Error while unmarshalling %s: %s", section, err)
+			continue
+		}
+		if loc.StaleIfError != expected {
+			t.Errorf("Expected stale if error of %s for %s but got %s",
+				expected, section, loc.StaleIfError)
+		}
+	}
+
+	loc := newLocForTesting()
+	if err := loc.UnmarshalJSON([]byte(`{"cache_zone": "default", "stale_if_error": "-5m"}`)); err != nil {
+		t.Errorf("Error while unmarshalling a negative stale_if_error: %s", err)
+	} else if err := loc.Validate(); err == nil {
+		t.Error("No error while verifying a negative stale_if_error")
+	}
+}
+
+func TestLocationNegativeCache(t *testing.T) {
+	t.Parallel()
+	var inherited = map[int]time.Duration{404: time.Minute}
+	loc := newLocForTesting()
+	loc.parent.NegativeCacheTTLs = inherited
+	if err := loc.UnmarshalJSON([]byte(`{"cache_zone": "default"}`)); err != nil {
+		t.Fatalf("Error while unmarshalling: %s", err)
+	}
+	if !reflect.DeepEqual(loc.NegativeCacheTTLs, inherited) {
+		t.Errorf("Expected the negative cache of the parent but got %v", loc.NegativeCacheTTLs)
+	}
+
+	loc = newLocForTesting()
+	loc.parent.NegativeCacheTTLs = inherited
+	var section = `{"cache_zone": "default", "handlers": [{"type": "cache"}],
+		"negative_cache": {"410": "1h", "301": "10m", "5xx": "5s", "503": "1s"}}`
+	if err := loc.UnmarshalJSON([]byte(section)); err != nil {
+		t.Fatalf("Error while unmarshalling %s: %s", section, err)
+	}
+	if err := loc.Validate(); err != nil {
+		t.Errorf("Error while verifying %s: %s", section, err)
+	}
+	var expected = map[int]time.Duration{
+		404: 0, 410: time.Hour, 301: 10 * time.Minute, 302: 0,
+		500: 5 * time.Second, 503: time.Second, 599: 5 * time.Second,
+	}
+	for code, ttl := range expected {
+		if got := loc.NegativeCacheTTLs[code]; got != ttl {
+			t.Errorf("Expected negative cache duration of %s for %d but got %s", ttl, code, got)
+		}
+	}
+
+	var invalid = []string{
+		`{"cache_zone": "default", "negative_cache": {"404": "baba"}}`,
+		`{"cache_zone": "default", "negative_cache": {"four-oh-four": "1m"}}`,
+	}
+	for _, section := range invalid {
+		if err := newLocForTesting().UnmarshalJSON([]byte(section)); err == nil {
+			t.Errorf("No error while unmarshalling %s", section)
+		}
+	}
+
+	var notValid = []string{
+		`{"cache_zone": "default", "handlers": [{"type": "cache"}], "negative_cache": {"200": "1m"}}`,
+		`{"cache_zone": "default", "handlers": [{"type": "cache"}], "negative_cache": {"304": "1m"}}`,
+		`{"cache_zone": "default", "handlers": [{"type": "cache"}], "negative_cache": {"404": "-1m"}}`,
+	}
+	for _, section := range notValid {
+		loc := newLocForTesting()
+		if err := loc.UnmarshalJSON([]byte(section)); err != nil {
+			t.Errorf("Error while unmarshalling %s: %s", section, err)
+		} else if err := loc.Validate(); err == nil {
+			t.Errorf("No error while verifying %s", section)
+		}
+	}
+}
+
+func TestLocationCacheKeyTemplate(t *testing.T) {
+	t.Parallel()
+	var inherited = &CacheKeyTemplate{Host: true}
+	loc := newLocForTesting()
+	loc.parent.baseLocation.CacheKeyTemplate = inherited
+	if err := loc.UnmarshalJSON([]byte(`{"cache_zone": "default"}`)); err != nil {
+		t.Fatalf("Error while unmarshalling: %s", err)
+	}
+	if loc.CacheKeyTemplate != inherited {
+		t.Errorf("Expected the cache key template of the parent but got %+v", loc.CacheKeyTemplate)
+	}
+
+	loc = newLocForTesting()
+	loc.parent.baseLocation.CacheKeyTemplate = inherited
+	var section = `{"cache_zone": "default", "handlers": [{"type": "cache"}], "cache_key_template": {
+		"lowercase_path": true, "query_blacklist": ["utm_*", "fbclid"], "sort_query": true,
+		"headers": "X-Device", "cookies": ["lang"]}}`
+	if err := loc.UnmarshalJSON([]byte(section)); err != nil {
+		t.Fatalf("Error while unmarshalling %s: %s", section, err)
+	}
+	if err := loc.Validate(); err != nil {
+		t.Errorf("Error while verifying %s: %s", section, err)
+	}
+	var expected = &CacheKeyTemplate{
+		LowercasePath:  true,
+		QueryBlacklist: StringSlice{"utm_*", "fbclid"},
+		SortQuery:      true,
+		Headers:        StringSlice{"X-Device"},
+		Cookies:        StringSlice{"lang"},
+	}
+	if !reflect.DeepEqual(loc.CacheKeyTemplate, expected) {
+		t.Errorf("Expected cache key template %+v but got %+v", expected, loc.CacheKeyTemplate)
+	}
+
+	loc = newLocForTesting()
+	section = `{"cache_zone": "default", "handlers": [{"type": "cache"}], "cache_key_template": {
+		"query_whitelist": ["id"], "query_blacklist": ["utm_*"]}}`
+	if err := loc.UnmarshalJSON([]byte(section)); err != nil {
+		t.Errorf("Error while unmarshalling %s: %s", section, err)
+	} else if err := loc.Validate(); err == nil {
+		t.Errorf("No error while verifying %s", section)
+	}
+}
@@ -7,6 +7,22 @@ import (
 
 //!TODO: investigate which config options should be pointers and which should be values
 
+// DefaultKeepStaleFor is the default number of seconds for which expired
+// objects that can be revalidated are kept in the cache zones.
+const DefaultKeepStaleFor = 24 * 60 * 60
+
+// DefaultAdmissionWindow is the default number of seconds for which the
+// requests for objects are counted by the cache admission policies.
+const DefaultAdmissionWindow = 10 * 60
+
+// DefaultMetadataCacheSize is the default maximum number of objects whose
+// metadata is kept in memory by the disk storage of a cache zone.
+const DefaultMetadataCacheSize = 10000
+
+// DefaultReadAheadConcurrency is the default maximum number of objects in a
+// cache zone whose parts are read ahead at the same time.
+const DefaultReadAheadConcurrency = 16
+
 // BaseConfig is part of the root configuration type.
 type BaseConfig struct {
 	System                System                      `json:"system"`
@@ -42,6 +58,20 @@ func (c *Config) UnmarshalJSON(buff []byte) error {
 			Algorithm:         c.DefaultCacheAlgorithm,
 			BulkRemoveCount:   100,
 			BulkRemoveTimeout: 100,
+			KeepStaleFor:      DefaultKeepStaleFor,
+			Admission: Admission{
+				Policy:      AdmitAlways,
+				MinRequests: 2,
+				Window:      DefaultAdmissionWindow,
+			},
+			RefreshAhead: RefreshAhead{
+				MinPopularity: 0.75,
+				Concurrency:   4,
+			},
+			MemoryMinPopularity: 0.75,
+			MetadataCacheSize:   DefaultMetadataCacheSize,
+
+			ReadAheadConcurrency: DefaultReadAheadConcurrency,
 		}
 
 		if err := json.Unmarshal(*cacheZoneBuff, &cacheZone); err != nil {
@@ -49,6 +49,13 @@ func (vh *VirtualHost) UnmarshalJSON(buff []byte) error {
 		vh.CacheDefaultDuration = dur
 	}
 
+	if err := vh.parseStaleDurations(vh, nil); err != nil {
+		return err
+	}
+	if err := vh.parseNegativeCache(vh, nil); err != nil {
+		return err
+	}
+
 	// Inject the cache zone configuration from the root config
 	vh.CacheZone = vh.parent.parent.CacheZones[vh.baseLocation.CacheZone]
 
@@ -89,7 +96,21 @@ func (vh *VirtualHost) Validate() error {
 		return fmt.Errorf("Cache default duration in %s must be positive", vh)
 	}
 
-	return nil
+	if vh.StaleWhileRevalidate < 0 {
+		return fmt.Errorf("Stale while revalidate duration in %s must not be negative", vh)
+	}
+
+	if vh.StaleIfError < 0 {
+		return fmt.Errorf("Stale if error duration in %s must not be negative", vh)
+	}
+
+	if vh.CacheKeyTemplate != nil {
+		if err := vh.CacheKeyTemplate.Validate(vh); err != nil {
+			return err
+		}
+	}
+
+	return validateNegativeCache(vh, vh.NegativeCacheTTLs)
 }
 
 func (vh *VirtualHost) String() string {
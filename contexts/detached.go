@@ -0,0 +1,35 @@
+package contexts
+
+import (
+	"context"
+	"time"
+)
+
+// detachedContext carries the values of its parent but is never canceled and
+// has no deadline.
+type detachedContext struct {
+	parent context.Context
+}
+
+// Detach returns a new Context carrying all the values of the supplied one
+// but which is not canceled when it is. It is useful for work which should
+// outlive the request that started it.
+func Detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+// nthRequestAlgorithm admits the objects from their Nth request on.
+type nthRequestAlgorithm struct {
+	types.CacheAlgorithm
+	requests, admitOn uint32
+}
+
+func (a *nthRequestAlgorithm) ShouldAdmit(*types.ObjectID) bool {
+	return atomic.AddUint32(&a.requests, 1) >= a.admitOn
+}
+
+func TestRejectedObjectsAreNotCached(t *testing.T) {
+	t.Parallel()
+	var file = "admission"
+	var fsmap = map[string]string{file: testutils.GenerateMeAString(18, 50)}
+	var fs = fsMapHandler(fsmap)
+	var upstreamRequests uint32
+	app := newTestAppFromMap(t, fsmap)
+	defer app.cleanup()
+	app.cacheHandler.Cache.Algorithm = &nthRequestAlgorithm{
+		CacheAlgorithm: app.cacheHandler.Cache.Algorithm,
+		admitOn:        3,
+	}
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&upstreamRequests, 1)
+		fs(w, r)
+	}))
+
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+	app.testRange(file, 12, 20)
+	app.testFullRequest(file)
+	if _, err := app.cacheHandler.Cache.Storage.GetMetadata(objID); !os.IsNotExist(err) {
+		t.Errorf("expected the rejected object not to be cached but got error %v", err)
+	}
+	if parts, err := app.cacheHandler.Cache.Storage.GetAvailableParts(objID); len(parts) != 0 {
+		t.Errorf("expected no parts of the rejected object to be cached but got %v (%v)", parts, err)
+	}
+
+	app.testFullRequest(file)
+	app.testFullRequest(file)
+	app.testRange(file, 12, 20)
+	if got := atomic.LoadUint32(&upstreamRequests); got != 3 {
+		t.Errorf("expected the admitted object to be served from the cache after 3 upstream requests but they were %d", got)
+	}
+	waitForParts(t, app, objID, 0, 5, 9)
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ironsmile/nedomi/utils/httputils"
+)
+
+// alignedRange widens a single "bytes=start-end" or "bytes=start-" range so
+// that it starts and ends at part boundaries and all of the parts it touches
+// can be cached. The end is not widened past the end of the object if its size
+// is known, i.e. objSize is not 0. Other ranges are returned unchanged.
+func alignedRange(rng string, partSize, objSize uint64) string {
+	const b = "bytes="
+	if !strings.HasPrefix(rng, b) || strings.Contains(rng, ",") {
+		return rng
+	}
+	spec := strings.TrimSpace(rng[len(b):])
+	dash := strings.Index(spec, "-")
+	if dash <= 0 {
+		// suffix ranges can not be aligned without knowing the object size
+		return rng
+	}
+	start, err := strconv.ParseUint(strings.TrimSpace(spec[:dash]), 10, 64)
+	if err != nil {
+		return rng
+	}
+	alignedStart := start - start%partSize
+	endSpec := strings.TrimSpace(spec[dash+1:])
+	if endSpec == "" {
+		return b + strconv.FormatUint(alignedStart, 10) + "-"
+	}
+	end, err := strconv.ParseUint(endSpec, 10, 64)
+	if err != nil || end < start {
+		return rng
+	}
+	alignedEnd := end - end%partSize + partSize - 1
+	if objSize > 0 && end < objSize && alignedEnd >= objSize {
+		alignedEnd = objSize - 1
+	}
+	return b + strconv.FormatUint(alignedStart, 10) + "-" + strconv.FormatUint(alignedEnd, 10)
+}
+
+// clientRange returns the range requested by the client if the upstream has
+// responded with a wider range of the object than it, as it does for aligned
+// requests. The returned range is relative to the start of the response body.
+func (h *reqHandler) clientRange(rw *httputils.FlexibleResponseWriter) (*httputils.Range, *httputils.ContentRange) {
+	rng := h.req.Header.Get("Range")
+	if rw.Code != http.StatusPartialContent || rng == "" {
+		return nil, nil
+	}
+	respRange, err := httputils.GetResponseRange(rw.Code, rw.Headers)
+	if err != nil {
+		return nil, nil
+	}
+	ranges, err := httputils.ParseRequestRange(rng, respRange.ObjSize)
+	if err != nil || len(ranges) != 1 {
+		return nil, nil
+	}
+	var reqRange = ranges[0]
+	if reqRange.Start == respRange.Start && reqRange.Length == respRange.Length {
+		return nil, nil
+	}
+	if reqRange.Start < respRange.Start ||
+		reqRange.Start+reqRange.Length > respRange.Start+respRange.Length {
+		return nil, nil
+	}
+	return &reqRange, respRange
+}
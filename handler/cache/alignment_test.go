@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestAlignedRange(t *testing.T) {
+	t.Parallel()
+	var tests = map[string]string{
+		"bytes=12-33":       "bytes=10-34",
+		"bytes=10-34":       "bytes=10-34",
+		"bytes=0-0":         "bytes=0-4",
+		"bytes=13-":         "bytes=10-",
+		"bytes=-20":         "bytes=-20",
+		"bytes=1-2,7-8":     "bytes=1-2,7-8",
+		"bytes=20-10":       "bytes=20-10",
+		"bytes=baba-10":     "bytes=baba-10",
+		"items=12-33":       "items=12-33",
+		"bytes= 12 - 33 ":   "bytes=10-34",
+		"bytes=5-999999999": "bytes=5-999999999",
+	}
+	for rng, expected := range tests {
+		if got := alignedRange(rng, 5, 0); got != expected {
+			t.Errorf("expected '%s' to be aligned to '%s' but got '%s'", rng, expected, got)
+		}
+	}
+
+	var withSize = map[string]string{
+		"bytes=50-51": "bytes=50-52",
+		"bytes=47-52": "bytes=45-52",
+		"bytes=12-33": "bytes=10-34",
+		"bytes=50-60": "bytes=50-64",
+	}
+	for rng, expected := range withSize {
+		if got := alignedRange(rng, 5, 53); got != expected {
+			t.Errorf("expected '%s' to be aligned to '%s' for a 53 bytes object but got '%s'", rng, expected, got)
+		}
+	}
+}
+
+func TestRangedMissesAreAligned(t *testing.T) {
+	t.Parallel()
+	var file = "aligned"
+	var fsmap = map[string]string{file: testutils.GenerateMeAString(16, 53)}
+	var lock sync.Mutex
+	var upstreamRanges []string
+	var fs = fsMapHandler(fsmap)
+	app := newTestAppFromMap(t, fsmap)
+	defer app.cleanup()
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		upstreamRanges = append(upstreamRanges, r.Header.Get("Range"))
+		lock.Unlock()
+		fs(w, r)
+	}))
+
+	app.testRange(file, 12, 22)
+	app.testRange(file, 10, 25)
+	app.testRange(file, 14, 2)
+	app.testRange(file, 51, 10)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(upstreamRanges) != 2 || upstreamRanges[0] != "bytes=10-34" || upstreamRanges[1] != "bytes=50-52" {
+		t.Errorf("expected two aligned upstream requests but they were %v", upstreamRanges)
+	}
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+	parts, err := app.cacheHandler.Cache.Storage.GetAvailableParts(objID)
+	if err != nil {
+		t.Fatalf("unexpected error while getting the parts: %s", err)
+	}
+	if len(parts) != 6 {
+		t.Errorf("expected all the parts touched by the requests to be cached but there are %d", len(parts))
+	}
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ironsmile/nedomi/utils/httputils"
+)
+
+// bufferingWriter passes the response body to the client and keeps a copy of
+// it in memory, so that responses with unknown size can be cached once they
+// are complete. It gives up on the copy if the body turns out to be larger
+// than max.
+type bufferingWriter struct {
+	client   io.WriteCloser
+	buf      []byte
+	max      uint64
+	overflow bool
+	failed   bool
+	done     func(buf []byte)
+}
+
+func newBufferingWriter(client io.WriteCloser, max uint64, done func(buf []byte)) *bufferingWriter {
+	return &bufferingWriter{
+		client: client,
+		max:    max,
+		done:   done,
+	}
+}
+
+func (bw *bufferingWriter) Write(p []byte) (int, error) {
+	n, err := bw.client.Write(p)
+	if err != nil {
+		bw.failed = true
+	}
+	if !bw.overflow {
+		if uint64(len(bw.buf)+n) > bw.max {
+			bw.overflow = true
+			bw.buf = nil
+		} else {
+			bw.buf = append(bw.buf, p[:n]...)
+		}
+	}
+	return n, err
+}
+
+// ReadFrom copies the body from r. Unlike Write, it knows if the body was not
+// read to the end, in which case it is not cached.
+func (bw *bufferingWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(struct{ io.Writer }{bw}, r)
+	if err != nil {
+		bw.failed = true
+	}
+	return n, err
+}
+
+// Close closes the client writer and calls the done function with the whole
+// body if it was received successfully and it was not too large.
+func (bw *bufferingWriter) Close() error {
+	err := bw.client.Close()
+	if !bw.overflow && !bw.failed {
+		bw.done(bw.buf)
+	}
+	bw.buf = nil
+	return err
+}
+
+// shouldBuffer returns whether the cacheable upstream response has unknown
+// size and should be buffered until it is complete.
+func (h *reqHandler) shouldBuffer(rw *httputils.FlexibleResponseWriter) bool {
+	return h.settings.MaxBufferedSize > 0 && h.req.Method == "GET" &&
+		(rw.Code == http.StatusOK || h.isNegativelyCached(rw.Code)) &&
+		rw.Headers.Get("Content-Length") == ""
+}
+
+// saveBuffered caches the complete body of a response with unknown size.
+func (h *reqHandler) saveBuffered(rw *httputils.FlexibleResponseWriter, buf []byte, expiresIn time.Duration) {
+	var size = uint64(len(buf))
+	var responseRange = httputils.ContentRange{Length: size, ObjSize: size}
+	h.Logger.Debugf("[%s] Buffered the whole response of %d bytes, caching it...", h.reqID, size)
+	if !h.saveMetadata(rw, &responseRange, expiresIn) {
+		return
+	}
+
+	pw := h.newPartWriter(responseRange)
+	if _, err := pw.Write(buf); err != nil {
+		h.Logger.Errorf("[%s] Error while caching the buffered response: %s", h.reqID, err)
+	}
+	if err := pw.Close(); err != nil {
+		h.Logger.Errorf("[%s] Error while caching the buffered response: %s", h.reqID, err)
+	}
+	h.scheduleExpiration(expiresIn)
+}
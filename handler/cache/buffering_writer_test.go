@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ironsmile/nedomi/utils"
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestResponsesWithUnknownSizeAreBuffered(t *testing.T) {
+	t.Parallel()
+	var files = map[string]string{
+		"small": testutils.GenerateMeAString(12, 40),
+		"large": testutils.GenerateMeAString(13, 120),
+		"empty": "",
+	}
+	var expectedRequests = map[string]uint32{"small": 1, "large": 2, "empty": 1}
+	var upstreamRequests = make(map[string]*uint32)
+	app := newTestAppFromMap(t, files)
+	defer app.cleanup()
+	app.cacheHandler.settings.MaxBufferedSize = 100
+	for file, contents := range files {
+		var file, contents, count = file, contents, new(uint32)
+		upstreamRequests[file] = count
+		app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddUint32(count, 1)
+			w.Header().Set("Cache-Control", "max-age=3600")
+			w.WriteHeader(http.StatusOK)
+			_, _ = io.Copy(w, strings.NewReader(contents))
+		}))
+	}
+
+	for i := 0; i < 2; i++ {
+		for file := range files {
+			app.testFullRequest(file)
+		}
+	}
+	for file, expected := range expectedRequests {
+		if got := atomic.LoadUint32(upstreamRequests[file]); got != expected {
+			t.Errorf("expected %d upstream requests for %s but got %d", expected, file, got)
+		}
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestBufferingWriterDoesNotSaveIncompleteBodies(t *testing.T) {
+	t.Parallel()
+	var saved bool
+	var client = new(strings.Builder)
+	bw := newBufferingWriter(utils.NopCloser(client), 100, func([]byte) { saved = true })
+	if _, err := bw.ReadFrom(io.MultiReader(strings.NewReader("partial"), failingReader{})); err == nil {
+		t.Error("expected an error from ReadFrom")
+	}
+	if err := bw.Close(); err != nil {
+		t.Errorf("unexpected error on close: %s", err)
+	}
+	if saved {
+		t.Error("the incomplete body should not have been saved")
+	}
+	if client.String() != "partial" {
+		t.Errorf("expected the client to receive 'partial' but it got '%s'", client)
+	}
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestCacheKeyTemplate(t *testing.T) {
+	t.Parallel()
+	var file = "Cache-Key"
+	var contents = testutils.GenerateMeAString(19, 50)
+	var upstreamRequests uint32
+	app := newTestAppFromMap(t, map[string]string{file: contents})
+	defer app.cleanup()
+	app.cacheHandler.CacheKeyTemplate = &types.CacheKeyTemplate{
+		LowercasePath:  true,
+		Query:          true,
+		SortQuery:      true,
+		QueryBlacklist: []string{"utm_*"},
+		Headers:        []string{"X-Device"},
+	}
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&upstreamRequests, 1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Content-Length", "50")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(contents))
+	}))
+
+	var tests = []struct {
+		url      string
+		device   string
+		requests uint32
+	}{
+		{url: "/Cache-Key?b=2&a=1", requests: 1},
+		{url: "/Cache-Key?a=1&b=2", requests: 1},
+		{url: "/Cache-Key?utm_source=newsletter&a=1&b=2&utm_medium=email", requests: 1},
+		{url: "/Cache-Key?a=1&b=3", requests: 2},
+		{url: "/Cache-Key?b=2&a=1", device: "mobile", requests: 3},
+		{url: "/Cache-Key?a=1&b=2&utm_campaign=spring", device: "mobile", requests: 3},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", "http://example.com"+test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.device != "" {
+			req.Header.Set("X-Device", test.device)
+		}
+		app.testRequest(req, contents, http.StatusOK)
+		if got := atomic.LoadUint32(&upstreamRequests); got != test.requests {
+			t.Errorf("expected %d upstream requests after %s (device '%s') but got %d",
+				test.requests, test.url, test.device, got)
+		}
+	}
+}
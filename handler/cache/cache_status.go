@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ironsmile/nedomi/types"
+)
+
+// cacheStatus describes how the request was served by the cache.
+type cacheStatus int
+
+const (
+	// the response was not determined by the cache, e.g. on an error
+	cacheStatusUnknown cacheStatus = iota
+	// the whole response was served from the storage
+	cacheStatusHit
+	// some of the parts of the response were requested from the upstream
+	cacheStatusPartial
+	// there was no cached object for the request
+	cacheStatusMiss
+	// the cache was not used because of the request method or directives
+	cacheStatusBypass
+	// the cached object was stale
+	cacheStatusStale
+)
+
+// https://tools.ietf.org/html/rfc9211#section-2
+var cacheStatusParams = map[cacheStatus]string{
+	cacheStatusHit:     "hit",
+	cacheStatusPartial: "fwd=partial",
+	cacheStatusMiss:    "fwd=uri-miss",
+	cacheStatusBypass:  "fwd=bypass",
+	cacheStatusStale:   "fwd=stale",
+}
+
+// cacheStatusHeader returns the value of the Cache-Status header for the
+// request. The age of the object and the ID of the cache zone are added as
+// extension parameters.
+func (h *reqHandler) cacheStatusHeader() string {
+	var value = []string{sfItem(h.settings.CacheStatus)}
+	if param, ok := cacheStatusParams[h.status]; ok {
+		value = append(value, param)
+	}
+	if h.obj != nil && h.status != cacheStatusMiss && h.status != cacheStatusBypass {
+		var now = time.Now().Unix()
+		value = append(value,
+			"ttl="+strconv.FormatInt(h.obj.ExpiresAt-now, 10),
+			"age="+strconv.FormatInt(now-h.obj.ResponseTimestamp, 10))
+	}
+	value = append(value, "zone="+strconv.Quote(h.Cache.ID))
+	return strings.Join(value, "; ")
+}
+
+// sfItem returns the name as a structured field token if it is a valid one or
+// as a string otherwise: https://tools.ietf.org/html/rfc8941#section-3.3
+func sfItem(name string) string {
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '*' ||
+			i > 0 && (c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'+-.^_`|~:/", c))) {
+			return strconv.Quote(name)
+		}
+	}
+	return name
+}
+
+// cacheStatusWriter adds the Cache-Status header of the request before the
+// first one from the upstream, if there is such. The response headers are
+// written with the first part of the body, so that the header tells whether
+// any of the parts of the response were requested from the upstream.
+type cacheStatusWriter struct {
+	http.ResponseWriter
+	h           *reqHandler
+	code        int
+	wroteHeader bool
+}
+
+func (w *cacheStatusWriter) WriteHeader(code int) {
+	if !w.wroteHeader && w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *cacheStatusWriter) Write(data []byte) (int, error) {
+	w.writeHeader()
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush writes the headers, if they are not written yet, and flushes the
+// underlying ResponseWriter if it supports flushing.
+func (w *cacheStatusWriter) Flush() {
+	w.writeHeader()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// CloseNotify returns the channel of the underlying ResponseWriter or one
+// which never receives if it does not support close notifications.
+func (w *cacheStatusWriter) CloseNotify() <-chan bool {
+	if notifier, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return nil
+}
+
+// finish writes the headers of the responses without a body.
+func (w *cacheStatusWriter) finish() {
+	if w.code != 0 {
+		w.writeHeader()
+	}
+}
+
+func (w *cacheStatusWriter) writeHeader() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.Header()["Cache-Status"] = append([]string{w.h.cacheStatusHeader()},
+		w.Header()["Cache-Status"]...)
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
+// servedFromUpstream marks the hits as partial when some of their parts are
+// requested from the upstream.
+func (h *reqHandler) servedFromUpstream() {
+	if h.status == cacheStatusHit {
+		h.status = cacheStatusPartial
+	}
+}
+
+// checkStoredParts marks the hit as partial if some of the parts are not in
+// the storage, since the response headers are written before they are
+// requested from the upstream.
+func (h *reqHandler) checkStoredParts(indexes []*types.ObjectIndex) {
+	if h.status != cacheStatusHit || h.settings.CacheStatus == "" {
+		return
+	}
+	parts, err := h.Cache.Storage.GetAvailableParts(h.objID)
+	if err != nil {
+		return
+	}
+	stored := make(map[uint32]bool, len(parts))
+	for _, idx := range parts {
+		stored[idx.Part] = true
+	}
+	for _, idx := range indexes {
+		if !stored[idx.Part] {
+			h.servedFromUpstream()
+			return
+		}
+	}
+}
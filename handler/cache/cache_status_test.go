@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestCacheStatusHeader(t *testing.T) {
+	t.Parallel()
+	var files = map[string]string{
+		"status":         testutils.GenerateMeAString(23, 50),
+		"status-partial": testutils.GenerateMeAString(24, 50),
+	}
+	app := newTestAppFromMap(t, files)
+	defer app.cleanup()
+	app.cacheHandler.settings.CacheStatus = "nedomi"
+	for file, contents := range files {
+		var file, contents = file, contents
+		app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=3600")
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Cache-Status", "origin; fwd=uri-miss")
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			http.ServeContent(w, r, file, time.Time{}, strings.NewReader(contents))
+		}))
+	}
+	var expectStatusWith = func(method, file, header, value, expected string) {
+		req, err := http.NewRequest(method, "http://example.com/"+file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != "" {
+			req.Header.Set(header, value)
+		}
+		var rng = req.Header.Get("Range")
+		var rec = httptest.NewRecorder()
+		app.cacheHandler.ServeHTTP(rec, req)
+		var status = rec.Header()["Cache-Status"]
+		if len(status) == 0 || !regexp.MustCompile("^"+expected+"$").MatchString(status[0]) {
+			t.Errorf("expected the Cache-Status of %s %s (%s) to match '%s' but it was %v",
+				method, file, rng, expected, status)
+		}
+		if len(status) != 2 || status[1] != "origin; fwd=uri-miss" {
+			t.Errorf("expected the Cache-Status of the upstream to be kept but got %v", status)
+		}
+	}
+	var expectStatus = func(method, file, rng, expected string) {
+		expectStatusWith(method, file, "Range", rng, expected)
+	}
+
+	expectStatus("GET", "status", "", `nedomi; fwd=uri-miss; zone="1"`)
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/status"})
+	waitForParts(t, app, objID, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	expectStatus("GET", "status", "", `nedomi; hit; ttl=(3599|3600); age=[01]; zone="1"`)
+	expectStatus("HEAD", "status", "", `nedomi; hit; ttl=(3599|3600); age=[01]; zone="1"`)
+	expectStatus("POST", "status", "", `nedomi; fwd=bypass; zone="1"`)
+
+	makeStale(t, app, objID)
+	// the stale object is served without being forwarded when the client accepts it
+	expectStatusWith("GET", "status", "Cache-Control", "max-stale", `nedomi; hit; ttl=-6[01]; age=[01]; zone="1"`)
+	expectStatus("GET", "status", "", `nedomi; fwd=stale; ttl=(3599|3600); age=[01]; zone="1"`)
+
+	expectStatus("GET", "status-partial", "bytes=10-19", `nedomi; fwd=uri-miss; zone="1"`)
+	waitForParts(t, app, app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/status-partial"}), 2, 3)
+	expectStatus("GET", "status-partial", "bytes=11-18", `nedomi; hit; ttl=(3599|3600); age=[01]; zone="1"`)
+	expectStatus("GET", "status-partial", "bytes=11-28", `nedomi; fwd=partial; ttl=(3599|3600); age=[01]; zone="1"`)
+
+	// the parts which are missing from the storage are requested from the
+	// upstream even though the cache algorithm knows about them
+	partialID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/status-partial"})
+	waitForParts(t, app, partialID, 4, 5)
+	if err := app.cacheHandler.Cache.Storage.DiscardPart(&types.ObjectIndex{ObjID: partialID, Part: 5}); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus("GET", "status-partial", "bytes=11-28", `nedomi; fwd=partial; ttl=(3599|3600); age=[01]; zone="1"`)
+}
+
+func TestCacheStatusWriterFlushes(t *testing.T) {
+	t.Parallel()
+	app := newTestAppFromMap(t, map[string]string{})
+	defer app.cleanup()
+	app.cacheHandler.settings.CacheStatus = "nedomi"
+	h := &reqHandler{CachingProxy: app.cacheHandler, status: cacheStatusBypass}
+	var rec = httptest.NewRecorder()
+	var w http.ResponseWriter = &cacheStatusWriter{ResponseWriter: rec, h: h}
+
+	w.WriteHeader(http.StatusAccepted)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatal("expected the Cache-Status writer to be a http.Flusher")
+	}
+	flusher.Flush()
+	if !rec.Flushed || rec.Code != http.StatusAccepted {
+		t.Errorf("expected the response to be flushed with status %d but got %t and %d",
+			http.StatusAccepted, rec.Flushed, rec.Code)
+	}
+	if status := rec.Header().Get("Cache-Status"); status != `nedomi; fwd=bypass; zone="1"` {
+		t.Errorf("expected the Cache-Status header to be written when flushing but got '%s'", status)
+	}
+	if _, ok := w.(http.CloseNotifier); !ok {
+		t.Error("expected the Cache-Status writer to be a http.CloseNotifier")
+	}
+}
+
+func TestCacheStatusHeaderIsOptional(t *testing.T) {
+	t.Parallel()
+	var file = "no-status"
+	app := newTestAppFromMap(t, map[string]string{file: testutils.GenerateMeAString(25, 50)})
+	defer app.cleanup()
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", "http://example.com/"+file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rec = httptest.NewRecorder()
+		app.cacheHandler.ServeHTTP(rec, req)
+		if status, ok := rec.Header()["Cache-Status"]; ok {
+			t.Errorf("expected no Cache-Status header but got %v", status)
+		}
+	}
+}
+
+func TestCacheStatusName(t *testing.T) {
+	t.Parallel()
+	var tests = map[string]string{
+		"nedomi":          "nedomi",
+		"edge-1.example":  "edge-1.example",
+		"cdn/edge:8080":   "cdn/edge:8080",
+		"1st-edge":        `"1st-edge"`,
+		"edge with space": `"edge with space"`,
+	}
+	for name, expected := range tests {
+		if got := sfItem(name); got != expected {
+			t.Errorf("expected the cache name %s to be %s but got %s", name, expected, got)
+		}
+	}
+}
@@ -1,19 +1,53 @@
 package cache
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/ironsmile/nedomi/config"
 	"github.com/ironsmile/nedomi/types"
 )
 
+// Settings contains the possible settings for the caching proxy
+type Settings struct {
+	// Whether gzip and deflate encoded upstream responses should be cached.
+	// They are stored as variants, keyed by the normalized Accept-Encoding of
+	// the request.
+	CacheEncoded bool `json:"cache_encoded"`
+
+	// The maximum size of the cacheable upstream responses without
+	// Content-Length which will be buffered in memory and cached once they
+	// are complete. Larger responses are streamed to the client uncached.
+	// Zero disables the buffering.
+	MaxBufferedSize types.BytesSize `json:"max_buffered_size"`
+
+	// The number of parts after the last requested one which are fetched
+	// from the upstream in the background when a client reads an object.
+	// Zero disables the read-ahead.
+	ReadAhead uint32 `json:"read_ahead"`
+
+	// The name of the cache in the Cache-Status response header (RFC 9211)
+	// which describes how the request was served. The header is not sent
+	// when it is empty.
+	CacheStatus string `json:"cache_status"`
+}
+
 // CachingProxy is resposible for caching the metadata and parts the requested
 // objects to `loc.Storage`, according to the `loc.Algorithm`.
 type CachingProxy struct {
 	*types.Location
-	cfg  *config.Handler
-	next http.Handler
+	cfg      *config.Handler
+	settings Settings
+	next     http.Handler
+	inflight *inflightParts
+	// serializes the changes to the primary objects of the varying responses
+	variantsLock sync.Mutex
+	// the objects whose upstream responds with the whole object to range requests
+	noRangeObjects *expiringSet
+	// keep the objects which are being read from being replaced
+	objectLocks *objectLocks
 }
 
 // New creates and returns a ready to used Handler.
@@ -26,20 +60,47 @@ func New(cfg *config.Handler, loc *types.Location, next http.Handler) (*CachingP
 		return nil, fmt.Errorf("caching proxy handler for %s needs a configured cache zone", loc.Name)
 	}
 
-	return &CachingProxy{loc, cfg, next}, nil
+	var s Settings
+	if cfg != nil && len(cfg.Settings) != 0 {
+		if err := json.Unmarshal(cfg.Settings, &s); err != nil {
+			return nil, fmt.Errorf("handler.cache got error while parsing settings: %s", err)
+		}
+	}
+
+	c := &CachingProxy{
+		Location: loc,
+		cfg:      cfg,
+		settings: s,
+		next:     next,
+		inflight: newInflightParts(),
+
+		noRangeObjects: newExpiringSet(noRangeRecheckAfter),
+		objectLocks:    newObjectLocks(),
+	}
+	if loc.Cache.RefreshJobs != nil {
+		loc.Cache.RefreshJobs.Register(loc.CacheKey, c.storedRefreshJob)
+	}
+	return c, nil
 }
 
 // ServeHTTP is the main serving function
 func (c *CachingProxy) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
-	if req.Method != "GET" && req.Method != "HEAD" {
-		c.next.ServeHTTP(resp, req)
-		return
-	}
-
 	rh := &reqHandler{
 		CachingProxy: c,
 		req:          req,
 		resp:         resp,
 	}
+	if c.settings.CacheStatus != "" {
+		w := &cacheStatusWriter{ResponseWriter: resp, h: rh}
+		defer w.finish()
+		rh.resp = w
+	}
+
+	if req.Method != "GET" && req.Method != "HEAD" {
+		rh.status = cacheStatusBypass
+		c.next.ServeHTTP(rh.resp, req)
+		return
+	}
+
 	rh.handle()
 }
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ironsmile/nedomi/utils/httputils"
+)
+
+// objectValidators are the headers which identify the version of an object.
+var objectValidators = []string{"ETag", "Last-Modified"}
+
+// objectChangedError is returned when a partial upstream response is for a
+// different version of the object than the cached one.
+type objectChangedError struct {
+	reason string
+}
+
+func (e *objectChangedError) Error() string {
+	return "object changed upstream: " + e.reason
+}
+
+// checkPartialResponse returns an error if the upstream response for the bytes
+// from start to end (inclusive) of the cached object is for a different range
+// or for another version of the object.
+func (h *reqHandler) checkPartialResponse(rw *httputils.FlexibleResponseWriter, start, end uint64) error {
+	if rw.Code != http.StatusOK && rw.Code != http.StatusPartialContent {
+		return nil
+	}
+
+	for _, header := range objectValidators {
+		cached, received := h.obj.Headers.Get(header), rw.Headers.Get(header)
+		if cached != "" && received != "" && cached != received {
+			return &objectChangedError{
+				reason: fmt.Sprintf("%s %s is now %s", header, cached, received)}
+		}
+	}
+
+	respRng, err := httputils.GetResponseRange(rw.Code, rw.Headers)
+	if err != nil {
+		// a 200 response with an unknown size
+		return nil
+	}
+	if respRng.ObjSize != h.obj.Size {
+		return &objectChangedError{
+			reason: fmt.Sprintf("size %d is now %d", h.obj.Size, respRng.ObjSize)}
+	}
+	if rw.Code == http.StatusPartialContent &&
+		(respRng.Start != start || respRng.Start+respRng.Length-1 != umin(end, h.obj.Size-1)) {
+		return fmt.Errorf("requested bytes %d-%d but received %s",
+			start, end, rw.Headers.Get("Content-Range"))
+	}
+	return nil
+}
+
+// abortPartialResponse logs why the partial upstream response can not be used.
+// If the object has changed in the upstream, the cached one is discarded, as
+// its parts can not be assembled with the new ones.
+func (h *reqHandler) abortPartialResponse(err error) {
+	if _, ok := err.(*objectChangedError); !ok {
+		h.Logger.Errorf("[%s] Unexpected partial upstream response for %s: %s",
+			h.reqID, h.objID, err)
+		return
+	}
+	h.Logger.Logf("[%s] Discarding %s which changed while it was assembled from parts: %s",
+		h.reqID, h.objID, err)
+	h.Cache.Counters.ObjectChanged()
+	h.discardObject()
+}
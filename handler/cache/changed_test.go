@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils/httputils"
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestObjectChangedWhileAssembled(t *testing.T) {
+	t.Parallel()
+	var versions = map[string][]string{
+		"changed-etag":          {testutils.GenerateMeAString(10, 50), testutils.GenerateMeAString(11, 50)},
+		"changed-size":          {testutils.GenerateMeAString(12, 50), testutils.GenerateMeAString(13, 60)},
+		"changed-last-modified": {testutils.GenerateMeAString(14, 50), testutils.GenerateMeAString(15, 50)},
+	}
+	var files = make(map[string]string)
+	for file, contents := range versions {
+		files[file] = contents[0]
+	}
+	var version uint32
+	app := newTestAppFromMap(t, files)
+	defer app.cleanup()
+	for file, contents := range versions {
+		var file, contents = file, contents
+		app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			v := atomic.LoadUint32(&version)
+			w.Header().Set("Cache-Control", "max-age=3600")
+			var modTime time.Time
+			switch file {
+			case "changed-etag":
+				w.Header().Set("ETag", []string{`"v0"`, `"v1"`}[v])
+			case "changed-last-modified":
+				modTime = time.Unix(int64(1000+v), 0)
+			}
+			http.ServeContent(w, r, file, modTime, strings.NewReader(contents[v]))
+		}))
+		app.testRange(file, 0, 5)
+	}
+	atomic.StoreUint32(&version, 1)
+
+	for file, contents := range versions {
+		req, err := http.NewRequest("GET", "http://example.com/"+file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rec = httptest.NewRecorder()
+		app.cacheHandler.ServeHTTP(rec, req)
+		if got := rec.Body.String(); got != contents[0][:5] {
+			t.Errorf("expected the response for %s to be aborted after the cached part but got '%s'",
+				file, got)
+		}
+
+		objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+		if _, err := app.cacheHandler.Cache.Storage.GetMetadata(objID); !os.IsNotExist(err) {
+			t.Errorf("expected the changed %s to be discarded but got error %v", file, err)
+		}
+
+		app.fsmap[file] = contents[1]
+		app.testFullRequest(file)
+	}
+
+	if got := app.cacheHandler.Cache.Counters.ChangedObjects(); got != uint64(len(versions)) {
+		t.Errorf("expected %d changed objects to be counted but got %d", len(versions), got)
+	}
+}
+
+func TestCheckPartialResponse(t *testing.T) {
+	t.Parallel()
+	h := &reqHandler{obj: &types.ObjectMetadata{
+		Size: 50,
+		Headers: http.Header{
+			"Etag":          {`"v1"`},
+			"Last-Modified": {"Thu, 01 Jan 1970 00:16:40 GMT"},
+		},
+	}}
+	var tests = []struct {
+		code       int
+		headers    http.Header
+		start, end uint64
+		changed    bool
+		err        bool
+	}{
+		{code: 206, headers: http.Header{"Content-Range": {"bytes 5-14/50"}, "Etag": {`"v1"`}},
+			start: 5, end: 14},
+		{code: 206, headers: http.Header{"Content-Range": {"bytes 45-49/50"}},
+			start: 45, end: 54},
+		{code: 200, headers: http.Header{"Content-Length": {"50"}},
+			start: 5, end: 14},
+		{code: 200, headers: http.Header{},
+			start: 5, end: 14},
+		{code: 404, headers: http.Header{"Etag": {`"v2"`}},
+			start: 5, end: 14},
+		{code: 206, headers: http.Header{"Content-Range": {"bytes 5-14/50"}, "Etag": {`"v2"`}},
+			start: 5, end: 14, changed: true, err: true},
+		{code: 206, headers: http.Header{"Content-Range": {"bytes 5-14/50"},
+			"Last-Modified": {"Thu, 01 Jan 1970 00:16:41 GMT"}},
+			start: 5, end: 14, changed: true, err: true},
+		{code: 206, headers: http.Header{"Content-Range": {"bytes 5-14/60"}},
+			start: 5, end: 14, changed: true, err: true},
+		{code: 200, headers: http.Header{"Content-Length": {"60"}},
+			start: 5, end: 14, changed: true, err: true},
+		{code: 206, headers: http.Header{"Content-Range": {"bytes 0-14/50"}},
+			start: 5, end: 14, err: true},
+		{code: 206, headers: http.Header{"Content-Range": {"bytes 5-9/50"}},
+			start: 5, end: 14, err: true},
+	}
+	for index, test := range tests {
+		rw := httputils.NewFlexibleResponseWriter(nil)
+		rw.Code, rw.Headers = test.code, test.headers
+		err := h.checkPartialResponse(rw, test.start, test.end)
+		if (err != nil) != test.err {
+			t.Errorf("test %d: unexpected error %v", index, err)
+		}
+		if _, changed := err.(*objectChangedError); changed != test.changed {
+			t.Errorf("test %d: expected the object to be changed to be %t but got %v",
+				index, test.changed, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils"
+)
+
+// corruptedPartError is returned when the contents of a part in the storage
+// do not match the checksum which was recorded when it was saved.
+type corruptedPartError struct {
+	expected, actual uint32
+}
+
+func (e *corruptedPartError) Error() string {
+	return fmt.Sprintf("the checksum of the part is %08x instead of %08x", e.actual, e.expected)
+}
+
+// verifyPart checks the contents of the part from the storage against their
+// checksum before any of them is sent to the client. It returns a reader for
+// the whole part which replaces r, as r has to be read in order to be
+// verified. The parts which can seek are read twice instead of being kept in
+// memory.
+func (h *reqHandler) verifyPart(idx *types.ObjectIndex, r io.ReadCloser) (io.ReadCloser, error) {
+	expected, err := h.Cache.Storage.GetPartChecksum(idx)
+	if os.IsNotExist(err) {
+		// the part was saved before the checksums were recorded
+		return r, nil
+	} else if err != nil {
+		h.closeUnusedPart(idx, r)
+		return nil, err
+	}
+
+	checksum := types.NewPartHash()
+	if seeker, ok := r.(io.Seeker); ok {
+		if _, err = io.Copy(checksum, r); err == nil {
+			_, err = seeker.Seek(0, io.SeekStart)
+		}
+	} else {
+		var contents []byte
+		if contents, err = ioutil.ReadAll(r); err == nil {
+			_, _ = checksum.Write(contents)
+			err = r.Close()
+			r = ioutil.NopCloser(bytes.NewReader(contents))
+		}
+	}
+	if err != nil {
+		return nil, utils.NewCompositeError(err, r.Close())
+	}
+
+	if actual := checksum.Sum32(); actual != expected {
+		h.closeUnusedPart(idx, r)
+		return nil, &corruptedPartError{expected: expected, actual: actual}
+	}
+	return r, nil
+}
+
+func (h *reqHandler) closeUnusedPart(idx *types.ObjectIndex, r io.ReadCloser) {
+	if err := r.Close(); err != nil {
+		h.Logger.Errorf("[%s] Error while closing part %s: %s", h.reqID, idx, err)
+	}
+}
+
+// discardCorruptedPart removes the part from the storage and the cache
+// algorithm, so that it is downloaded again from the upstream.
+func (h *reqHandler) discardCorruptedPart(idx *types.ObjectIndex, err error) {
+	h.Logger.Errorf("[%s] Discarding corrupted part %s: %s", h.reqID, idx, err)
+	h.Cache.Counters.PartCorrupted()
+	if err := h.Cache.Storage.DiscardPart(idx); err != nil && !os.IsNotExist(err) {
+		h.Logger.Errorf("[%s] Error while discarding corrupted part %s: %s", h.reqID, idx, err)
+	}
+	h.Cache.Algorithm.Remove(idx)
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+// corruptingStorage flips a bit in the parts which are marked as corrupted
+// the first time they are read.
+type corruptingStorage struct {
+	types.Storage
+	sync.Mutex
+	corrupted map[uint32]bool
+}
+
+func (s *corruptingStorage) GetPart(idx *types.ObjectIndex) (io.ReadCloser, error) {
+	r, err := s.Storage.GetPart(idx)
+	s.Lock()
+	defer s.Unlock()
+	if err != nil || !s.corrupted[idx.Part] {
+		return r, err
+	}
+	delete(s.corrupted, idx.Part)
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	contents[len(contents)/2] ^= 0x10
+	return ioutil.NopCloser(bytes.NewReader(contents)), r.Close()
+}
+
+func TestCorruptedPartsAreRefetched(t *testing.T) {
+	t.Parallel()
+	var file = "corrupted"
+	var fsmap = map[string]string{file: testutils.GenerateMeAString(20, 50)}
+	var fs = fsMapHandler(fsmap)
+	var ranges []string
+	var mu sync.Mutex
+	app := newTestAppFromMap(t, fsmap)
+	defer app.cleanup()
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		fs(w, r)
+	}))
+
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+	app.testFullRequest(file)
+	waitForParts(t, app, objID, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+
+	storage := &corruptingStorage{
+		Storage:   app.cacheHandler.Cache.Storage,
+		corrupted: map[uint32]bool{3: true},
+	}
+	app.cacheHandler.Cache.Storage = storage
+	mu.Lock()
+	ranges = nil
+	mu.Unlock()
+
+	// the corrupted part is fetched from the upstream before it is sent
+	app.testFullRequest(file)
+	mu.Lock()
+	if len(ranges) != 1 || ranges[0] != "bytes=15-19" {
+		t.Errorf("expected only the corrupted part to be requested from the upstream but got %v", ranges)
+	}
+	mu.Unlock()
+	if got := app.cacheHandler.Cache.Counters.CorruptedParts(); got != 1 {
+		t.Errorf("expected 1 corrupted part but got %d", got)
+	}
+
+	waitForParts(t, app, objID, 3)
+	app.testFullRequest(file)
+	app.testRange(file, 12, 20)
+	mu.Lock()
+	if len(ranges) != 1 {
+		t.Errorf("expected the refetched part to be served from the cache but the upstream requests were %v", ranges)
+	}
+	mu.Unlock()
+}
+
+func TestPartialReadsAreVerified(t *testing.T) {
+	t.Parallel()
+	var file = "corrupted-range"
+	var fsmap = map[string]string{file: testutils.GenerateMeAString(21, 50)}
+	app := newTestAppFromMap(t, fsmap)
+	defer app.cleanup()
+
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+	app.testFullRequest(file)
+	waitForParts(t, app, objID, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+
+	app.cacheHandler.Cache.Storage = &corruptingStorage{
+		Storage:   app.cacheHandler.Cache.Storage,
+		corrupted: map[uint32]bool{3: true},
+	}
+	// the corrupted byte in the middle of the part is not requested but the
+	// whole part is verified before the range is sent
+	req := reqForRange(file, 15, 2)
+	var rec = httptest.NewRecorder()
+	app.cacheHandler.ServeHTTP(rec, req)
+	if rec.Body.String() != fsmap[file][15:17] {
+		t.Errorf("expected the requested range but got '%s'", rec.Body.String())
+	}
+	if got := app.cacheHandler.Cache.Counters.CorruptedParts(); got != 1 {
+		t.Errorf("expected the partially read part to be verified but got %d corrupted parts", got)
+	}
+}
+
+func TestVerifyPartWithoutChecksum(t *testing.T) {
+	t.Parallel()
+	app := newTestAppFromMap(t, map[string]string{})
+	defer app.cleanup()
+	h := &reqHandler{CachingProxy: app.cacheHandler, reqID: types.RequestID("test")}
+	idx := &types.ObjectIndex{ObjID: types.NewObjectID("test", "/legacy"), Part: 0}
+
+	r, err := h.verifyPart(idx, ioutil.NopCloser(bytes.NewReader([]byte("legacy"))))
+	if err != nil {
+		t.Fatalf("expected parts without checksums to be accepted but got %s", err)
+	}
+	if contents, _ := ioutil.ReadAll(r); string(contents) != "legacy" {
+		t.Errorf("expected the contents of the part to be read but got '%s'", contents)
+	}
+}
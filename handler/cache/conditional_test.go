@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestConditionalRequestsFromCache(t *testing.T) {
+	t.Parallel()
+	var file = "conditional"
+	var contents = testutils.GenerateMeAString(7, 50)
+	var lastModified = time.Unix(1000, 0)
+	app := newTestAppFromMap(t, map[string]string{file: contents})
+	defer app.cleanup()
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		http.ServeContent(w, r, file, lastModified, strings.NewReader(contents))
+	}))
+	app.testFullRequest(file)
+
+	var request = func(headers map[string]string) *http.Request {
+		req := reqForRange(file, 10, 5)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		return req
+	}
+	var ranged = contents[10:15]
+
+	app.testRequest(request(map[string]string{"If-None-Match": `"v1"`}), "", http.StatusNotModified)
+	app.testRequest(request(map[string]string{"If-None-Match": `"v2"`}), ranged, http.StatusPartialContent)
+	app.testRequest(request(map[string]string{
+		"If-Modified-Since": lastModified.UTC().Format(http.TimeFormat),
+	}), "", http.StatusNotModified)
+	app.testRequest(request(map[string]string{"If-Match": `"v2"`}),
+		http.StatusText(http.StatusPreconditionFailed)+"\n", http.StatusPreconditionFailed)
+	app.testRequest(request(map[string]string{"If-Range": `"v1"`}), ranged, http.StatusPartialContent)
+	app.testRequest(request(map[string]string{"If-Range": `"v2"`}), contents, http.StatusOK)
+}
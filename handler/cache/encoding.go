@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// cacheableEncodings are the content encodings which are cached when the
+// CacheEncoded setting is enabled, in the order of preference.
+var cacheableEncodings = []string{"gzip", "deflate"}
+
+// preferredEncoding returns the most preferred of the cacheableEncodings which
+// is acceptable according to the Accept-Encoding headers of the request. An
+// empty string means that only the identity encoding should be used. This way
+// all the requests are normalized to a few variants of each object.
+func preferredEncoding(reqHeaders http.Header) string {
+	var acceptable = make(map[string]bool)
+	var wildcard bool
+	for _, value := range reqHeaders["Accept-Encoding"] {
+		for _, coding := range strings.Split(value, ",") {
+			name, q := parseCoding(coding)
+			if name == "*" {
+				wildcard = q > 0
+			} else {
+				acceptable[name] = q > 0
+			}
+		}
+	}
+
+	for _, encoding := range cacheableEncodings {
+		if ok, listed := acceptable[encoding]; ok || (!listed && wildcard) {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// parseCoding parses a single element of the Accept-Encoding header and
+// returns its content coding in lower case and its quality value.
+func parseCoding(coding string) (string, float64) {
+	var params = strings.Split(coding, ";")
+	var name = strings.ToLower(strings.TrimSpace(params[0]))
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(param, "q=") {
+			continue
+		}
+		q, err := strconv.ParseFloat(param[2:], 64)
+		if err != nil {
+			return name, 0
+		}
+		return name, q
+	}
+	return name, 1
+}
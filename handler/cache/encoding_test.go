@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestPreferredEncoding(t *testing.T) {
+	t.Parallel()
+	var tests = map[string]string{
+		"":                         "",
+		"identity":                 "",
+		"gzip":                     "gzip",
+		"deflate, gzip":            "gzip",
+		"GZIP;q=0.5, deflate;q=1":  "gzip",
+		"gzip;q=0, deflate":        "deflate",
+		"gzip;q=0, deflate;q=0.0":  "",
+		"br, *":                    "gzip",
+		"*;q=0":                    "",
+		"gzip;q=0, *":              "deflate",
+		"gzip;q=wrong, deflate;q=": "",
+	}
+	for acceptEncoding, expected := range tests {
+		headers := http.Header{"Accept-Encoding": []string{acceptEncoding}}
+		if got := preferredEncoding(headers); got != expected {
+			t.Errorf("expected '%s' for Accept-Encoding '%s' but got '%s'", expected, acceptEncoding, got)
+		}
+	}
+}
+
+func TestEncodedResponsesAreCachedAsVariants(t *testing.T) {
+	t.Parallel()
+	var file = "encoded"
+	var contents = testutils.GenerateMeAString(11, 60)
+	var upstreamRequests uint32
+	app := newTestAppFromMap(t, map[string]string{file: contents})
+	defer app.cleanup()
+	app.cacheHandler.settings.CacheEncoded = true
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&upstreamRequests, 1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			http.ServeContent(w, r, file, time.Time{}, strings.NewReader(contents))
+			return
+		}
+		var compressed = new(strings.Builder)
+		gw := gzip.NewWriter(compressed)
+		_, _ = gw.Write([]byte(contents))
+		_ = gw.Close()
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", strconv.Itoa(compressed.Len()))
+		_, _ = w.Write([]byte(compressed.String()))
+	}))
+
+	var request = func(acceptEncoding string) {
+		req, err := http.NewRequest("GET", "http://example.com/"+file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		var rec = httptest.NewRecorder()
+		app.cacheHandler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected code %d but got %d", http.StatusOK, rec.Code)
+		}
+
+		var body = ioutil.NopCloser(rec.Body)
+		if rec.Header().Get("Content-Encoding") == "gzip" {
+			if !strings.Contains(acceptEncoding, "gzip") {
+				t.Fatalf("got gzip encoded response for Accept-Encoding '%s'", acceptEncoding)
+			}
+			if body, err = gzip.NewReader(rec.Body); err != nil {
+				t.Fatalf("unexpected error while decoding the response: %s", err)
+			}
+		} else if strings.Contains(acceptEncoding, "gzip") {
+			t.Errorf("expected gzip encoded response for Accept-Encoding '%s'", acceptEncoding)
+		}
+		got, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Fatalf("unexpected error while reading the response: %s", err)
+		}
+		if string(got) != contents {
+			t.Errorf("expected '%s' but got '%s'", contents, got)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		request("gzip, deflate")
+		request("identity")
+		request("deflate;q=0, gzip;q=0.5")
+	}
+	if got := atomic.LoadUint32(&upstreamRequests); got != 2 {
+		t.Errorf("expected 2 upstream requests - one for each encoding, but got %d", got)
+	}
+}
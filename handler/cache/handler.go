@@ -22,19 +22,35 @@ type reqHandler struct {
 	objID *types.ObjectID
 	obj   *types.ObjectMetadata
 	reqID types.RequestID
+	// the in-flight parts this request has to download, if it is an upstream
+	// request for missing parts
+	reserved map[uint32]*inflightPart
+	// how the request was served, for the Cache-Status header
+	status cacheStatus
 }
 
 // handle tries to respond to client request by loading metadata and file parts
 // from the cache. If there are missing parts, they are retrieved from the upstream.
 func (h *reqHandler) handle() {
-	h.objID = h.NewObjectIDForURL(h.req.URL)
+	h.objID = h.NewObjectIDForRequest(h.req)
 	h.reqID, _ = contexts.GetRequestID(h.req.Context())
 	h.Logger.Debugf("[%s] Caching proxy access: %s %s", h.reqID, h.req.Method, h.req.RequestURI)
 
-	rng := h.req.Header.Get("Range")
 	obj, err := h.Cache.Storage.GetMetadata(h.objID)
-	if os.IsNotExist(err) {
+	if err == nil && len(obj.Vary) > 0 {
+		h.objID = h.variantID(obj.Vary)
+		h.Logger.Debugf("[%s] Object varies on %v, looking for variant %s...",
+			h.reqID, obj.Vary, h.objID)
+		obj, err = h.Cache.Storage.GetMetadata(h.objID)
+	}
+
+	if os.IsNotExist(err) && cacheutils.OnlyIfCached(h.req) {
+		h.Logger.Debugf("[%s] No metadata on storage for the only-if-cached request", h.reqID)
+		h.status = cacheStatusMiss
+		h.gatewayTimeout()
+	} else if os.IsNotExist(err) {
 		h.Logger.Debugf("[%s] No metadata on storage, proxying...", h.reqID)
+		h.status = cacheStatusMiss
 		h.carbonCopyProxy()
 	} else if err != nil {
 		h.Logger.Errorf("[%s] Storage error when reading metadata: %s", h.reqID, err)
@@ -45,49 +61,160 @@ func (h *reqHandler) handle() {
 				http.StatusInternalServerError)
 			return
 		}
-		if discardErr := h.Cache.Storage.Discard(h.objID); discardErr != nil {
-			h.Logger.Errorf("[%s] Storage error when discarding of object's data: %s",
-				h.reqID, discardErr)
+		h.discardObject()
+		h.status = cacheStatusMiss
+		if cacheutils.OnlyIfCached(h.req) {
+			h.gatewayTimeout()
+			return
 		}
 		h.carbonCopyProxy()
-	} else if !utils.IsMetadataFresh(obj) {
-		h.Logger.Debugf("[%s] Metadata is stale, proxying...", h.reqID)
-		//!TODO: optimize, do only a head request when the metadata is stale?
-		if discardErr := h.Cache.Storage.Discard(h.objID); discardErr != nil {
-			h.Logger.Errorf("[%s] Storage error when discarding of object's data: %s",
-				h.reqID, discardErr)
+	} else if cacheutils.CacheSatisfiesRequest(obj, h.req) {
+		h.obj = obj
+		h.status = cacheStatusHit
+		if !utils.IsMetadataFresh(obj) {
+			h.Logger.Debugf("[%s] Metadata is stale but the client accepts it, serving it...", h.reqID)
+			// https://tools.ietf.org/html/rfc7234#section-5.5.1
+			h.resp.Header().Set("Warning", `110 - "Response is Stale"`)
 		}
-		h.carbonCopyProxy()
-	} else if !cacheutils.CacheSatisfiesRequest(obj, h.req) {
+		h.respondFromCache()
+	} else if cacheutils.OnlyIfCached(h.req) {
+		h.Logger.Debugf("[%s] Cached object does not satisfy the only-if-cached request", h.reqID)
+		h.status = cacheStatusMiss
+		h.gatewayTimeout()
+	} else if !utils.IsMetadataFresh(obj) && utils.CanServeWhileRevalidating(obj) &&
+		cacheutils.CacheSatisfiesRequestWhileRevalidating(obj, h.req) {
+		h.Logger.Debugf("[%s] Metadata is stale, serving it while revalidating...", h.reqID)
+		h.obj = obj
+		h.status = cacheStatusStale
+		h.revalidateInBackground()
+		h.respondFromCache()
+	} else if !utils.IsMetadataFresh(obj) {
+		h.Logger.Debugf("[%s] Metadata is stale, revalidating...", h.reqID)
+		h.obj = obj
+		h.status = cacheStatusStale
+		h.revalidate()
+	} else {
 		h.Logger.Debugf("[%s] Client does not want cached response or the cache does not"+
 			"satisfy the request, proxying...", h.reqID)
+		h.status = cacheStatusBypass
 		h.carbonCopyProxy()
-	} else {
+	}
+}
+
+// respondFromCache serves the request with the cached object. Any parts that
+// are missing from the storage are retrieved from the upstream.
+func (h *reqHandler) respondFromCache() {
+	//!TODO: advertise that we support ranges - send "Accept-Ranges: bytes"?
+
+	release, ok := h.objectLocks.read(h.objID.Hash())
+	if !ok && cacheutils.OnlyIfCached(h.req) {
+		h.Logger.Debugf("[%s] Object for the only-if-cached request is being replaced", h.reqID)
+		h.status = cacheStatusMiss
+		h.gatewayTimeout()
+		return
+	} else if !ok {
+		h.Logger.Debugf("[%s] Object is being replaced, proxying without caching...", h.reqID)
+		h.status = cacheStatusStale
+		h.passThrough()
+		return
+	}
+	defer release()
+	// the object may have been replaced before it was registered as read
+	obj, err := h.Cache.Storage.GetMetadata(h.objID)
+	if err == nil && obj.ResponseTimestamp >= h.obj.ResponseTimestamp {
 		h.obj = obj
-		//!TODO: advertise that we support ranges - send "Accept-Ranges: bytes"?
-
-		//!TODO: evaluate conditional requests: https://tools.ietf.org/html/rfc7232
-		//!TODO: Also, handle this from RFC7233:
-		// "The Range header field is evaluated after evaluating the precondition
-		// header fields defined in [RFC7232], and only if the result in absence
-		// of the Range header field would be a 200 (OK) response.  In other
-		// words, Range is ignored when a conditional GET would result in a 304
-		// (Not Modified) response."
-
-		if rng != "" {
-			h.Logger.Debugf("[%s] Serving range '%s', preferably from cache...",
-				h.reqID, rng)
-			h.knownRanged()
-		} else {
-			h.Logger.Debugf("[%s] Serving full object, preferably from cache...",
-				h.reqID)
-			h.knownFull()
+	}
+
+	if cacheutils.OnlyIfCached(h.req) && !h.hasCachedParts() {
+		h.Logger.Debugf("[%s] Not all parts for the only-if-cached request are cached", h.reqID)
+		h.status = cacheStatusMiss
+		h.gatewayTimeout()
+		return
+	}
+
+	if h.obj.Code == http.StatusOK {
+		switch httputils.CheckPreconditions(h.req, h.obj.Headers) {
+		case http.StatusNotModified:
+			h.Logger.Debugf("[%s] Client's copy is not modified, responding with 304...", h.reqID)
+			h.notModified()
+			return
+		case http.StatusPreconditionFailed:
+			h.Logger.Debugf("[%s] Client's preconditions failed, responding with 412...", h.reqID)
+			code := http.StatusPreconditionFailed
+			http.Error(h.resp, http.StatusText(code), code)
+			return
 		}
 	}
+
+	// Range is ignored when the If-Range validator does not match the object:
+	// https://tools.ietf.org/html/rfc7233#section-3.2
+	// It is ignored for the negatively cached redirects and errors as well.
+	rng := h.req.Header.Get("Range")
+	if rng != "" && h.obj.Code == http.StatusOK && httputils.IfRangeMatches(h.req, h.obj.Headers) {
+		h.Logger.Debugf("[%s] Serving range '%s', preferably from cache...",
+			h.reqID, rng)
+		h.knownRanged()
+	} else {
+		h.Logger.Debugf("[%s] Serving full object, preferably from cache...",
+			h.reqID)
+		h.knownFull()
+	}
+}
+
+// notModified responds with 304 and the validators of the cached object.
+func (h *reqHandler) notModified() {
+	for _, header := range httputils.NotModifiedHeaders {
+		if values, ok := h.obj.Headers[header]; ok {
+			h.resp.Header()[header] = utils.CopyStringSlice(values)
+		}
+	}
+	h.rewriteTimeBasedHeaders()
+	h.resp.WriteHeader(http.StatusNotModified)
+}
+
+// discardObject removes the object from the storage and its parts from the
+// cache algorithm.
+func (h *reqHandler) discardObject() {
+	h.discardObjectID(h.objID)
+}
+
+func (h *reqHandler) discardObjectID(id *types.ObjectID) {
+	parts, err := h.Cache.Storage.GetAvailableParts(id)
+	if err != nil && !os.IsNotExist(err) {
+		h.Logger.Errorf("[%s] Storage error when getting the parts of the object: %s",
+			h.reqID, err)
+	}
+	h.Cache.Algorithm.Remove(parts...)
+	h.Cache.Tags.Remove(id)
+
+	if err := h.Cache.Storage.Discard(id); err != nil && !os.IsNotExist(err) {
+		h.Logger.Errorf("[%s] Storage error when discarding of object's data: %s",
+			h.reqID, err)
+	}
 }
 
 func (h *reqHandler) carbonCopyProxy() {
-	flexibleResp := httputils.NewFlexibleResponseWriter(h.getResponseHook())
+	h.proxy(h.getNormalizedRequest(), h.getResponseHook())
+}
+
+// passThrough proxies the request to the upstream without caching the
+// response, as the object is being replaced in the cache.
+func (h *reqHandler) passThrough() {
+	req := h.getNormalizedRequest()
+	if rng := h.req.Header.Get("Range"); rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	h.proxy(req, func(rw *httputils.FlexibleResponseWriter) {
+		httputils.CopyHeadersWithout(rw.Headers, h.resp.Header(), hopHeaders...)
+		h.resp.WriteHeader(rw.Code)
+		rw.BodyWriter = utils.AddCloser(h.resp)
+	})
+}
+
+// proxy passes the supplied request to the next handler. The hook decides
+// where the response body should be written.
+func (h *reqHandler) proxy(req *http.Request, hook func(*httputils.FlexibleResponseWriter)) {
+	flexibleResp := httputils.NewFlexibleResponseWriter(hook)
 	defer func() {
 		if flexibleResp.BodyWriter != nil {
 			if err := flexibleResp.BodyWriter.Close(); err != nil {
@@ -98,15 +225,9 @@ func (h *reqHandler) carbonCopyProxy() {
 				}
 			}
 		}
-		//!TODO: cache small upstream responses that we did not cache because
-		// there was no Content-Length header in the upstream response but it
-		// was otherwise cacheable? Examples are folder listings for apache and
-		// ngingx: `curl -i http://mirror.rackspace.com/` or `curl -i
-		// https://mirrors.uni-plovdiv.net/`
-
 	}()
 
-	h.next.ServeHTTP(flexibleResp, h.getNormalizedRequest())
+	h.next.ServeHTTP(flexibleResp, req)
 }
 
 func (h *reqHandler) knownRanged() {
@@ -118,9 +239,12 @@ func (h *reqHandler) knownRanged() {
 	}
 
 	if len(ranges) != 1 {
-		// We do not support multiple ranges but maybe the upstream does
-		//!TODO: implement support for multiple ranges
-		h.carbonCopyProxy()
+		if sumRangesSize(ranges) > h.obj.Size {
+			// The client is probably trying to waste our resources
+			h.knownFull()
+		} else {
+			h.knownMultiRanged(ranges)
+		}
 		return
 	}
 	reqRange := ranges[0]
@@ -142,15 +266,11 @@ func (h *reqHandler) knownFull() {
 	h.resp.Header().Set("Content-Length", strconv.FormatUint(h.obj.Size, 10))
 	h.rewriteTimeBasedHeaders()
 	h.resp.WriteHeader(h.obj.Code)
-	if h.req.Method == "HEAD" {
+	if h.req.Method == "HEAD" || h.obj.Size == 0 {
 		return
 	}
 
-	var responseSize = h.obj.Size
-	if responseSize != 0 {
-		responseSize--
-	}
-	h.lazilyRespond(0, responseSize)
+	h.lazilyRespond(0, h.obj.Size-1)
 }
 
 func (h *reqHandler) rewriteTimeBasedHeaders() {
@@ -40,8 +40,19 @@ func (h *reqHandler) getNormalizedRequest() *http.Request {
 	}
 
 	httputils.CopyHeadersWithout(h.req.Header, result.Header, "Accept-Encoding")
+	if h.settings.CacheEncoded {
+		if encoding := preferredEncoding(h.req.Header); encoding != "" {
+			result.Header.Set("Accept-Encoding", encoding)
+		}
+	}
 
-	//!TODO: fix requested range to be divisible by the storage partSize
+	if rng := result.Header.Get("Range"); rng != "" {
+		var objSize uint64
+		if h.obj != nil {
+			objSize = h.obj.Size
+		}
+		result.Header.Set("Range", alignedRange(rng, h.Cache.PartSize.Bytes(), objSize))
+	}
 
 	return result
 }
@@ -52,146 +63,281 @@ func (h *reqHandler) getResponseHook() func(*httputils.FlexibleResponseWriter) {
 		h.Logger.Debugf("[%s] Received headers for %s, sending them to client...",
 			h.reqID, h.req.URL)
 		httputils.CopyHeadersWithout(rw.Headers, h.resp.Header(), hopHeaders...)
+		var client = utils.AddCloser(h.resp)
+		if reqRange, respRange := h.clientRange(rw); reqRange != nil {
+			h.Logger.Debugf("[%s] Sending only the requested %s of the aligned upstream response...",
+				h.reqID, reqRange.Range())
+			h.resp.Header().Set("Content-Range", reqRange.ContentRange(respRange.ObjSize))
+			h.resp.Header().Set("Content-Length", strconv.FormatUint(reqRange.Length, 10))
+			offset := reqRange.Start - respRange.Start
+			client = newRangeWriter(client, offset, offset+reqRange.Length-1)
+		}
 		h.resp.WriteHeader(rw.Code)
 
 		isCacheable := cacheutils.IsResponseCacheable(rw.Code, rw.Headers)
-		if !isCacheable {
+		if h.settings.CacheEncoded {
+			isCacheable = cacheutils.IsEncodedResponseCacheable(rw.Code, rw.Headers, cacheableEncodings...)
+		}
+		negativeTTL, isNegative := h.negativeCacheTTL(rw)
+		if !isCacheable && !isNegative {
 			h.Logger.Debugf("[%s] Response is non-cacheable", h.reqID)
-			rw.BodyWriter = utils.AddCloser(h.resp)
+			rw.BodyWriter = client
 			return
 		}
 
 		expiresIn := cacheutils.ResponseExpiresIn(rw.Headers, h.CacheDefaultDuration)
+		if isNegative {
+			h.Logger.Debugf("[%s] Caching response with status %d for %s",
+				h.reqID, rw.Code, negativeTTL)
+			expiresIn = negativeTTL
+		}
 		if expiresIn <= 0 {
 			h.Logger.Debugf("[%s] Response expires in the past: %s", h.reqID, expiresIn)
-			rw.BodyWriter = utils.AddCloser(h.resp)
+			rw.BodyWriter = client
 			return
 		}
 
-		responseRange, err := httputils.GetResponseRange(rw.Code, rw.Headers)
+		// the objects which are already cached have been admitted
+		if h.obj == nil && !h.Cache.Algorithm.ShouldAdmit(h.objID) {
+			h.Logger.Debugf("[%s] Response is not admitted in the cache", h.reqID)
+			rw.BodyWriter = client
+			return
+		}
+
+		responseRange, err := h.getResponseRange(rw)
+		if err != nil && h.shouldBuffer(rw) {
+			h.Logger.Debugf("[%s] Response has unknown size, buffering up to %d bytes of it...",
+				h.reqID, h.settings.MaxBufferedSize.Bytes())
+			rw.BodyWriter = newBufferingWriter(client, h.settings.MaxBufferedSize.Bytes(),
+				func(buf []byte) { h.saveBuffered(rw, buf, expiresIn) })
+			return
+		}
 		if err != nil {
 			h.Logger.Debugf("[%s] Was not able to get response range (%s)",
 				h.reqID, err)
-			rw.BodyWriter = utils.AddCloser(h.resp)
+			rw.BodyWriter = client
 			return
 		}
 
 		h.Logger.Debugf("[%s] Response is cacheable! Caching metadata and parts", h.reqID)
-
-		code := rw.Code
-		if code == http.StatusPartialContent {
-			// 206 is returned only if the server would
-			// have returned 200 with a normal request
-			code = http.StatusOK
-		}
-
-		//!TODO: maybe call cached time.Now. See the comment in utils.IsMetadataFresh
-		now := time.Now()
-
-		obj := &types.ObjectMetadata{
-			ID:                h.objID,
-			ResponseTimestamp: now.Unix(),
-			Code:              code,
-			Size:              responseRange.ObjSize,
-			Headers:           make(http.Header),
-			ExpiresAt:         now.Add(expiresIn).Unix(),
-		}
-		httputils.CopyHeadersWithout(rw.Headers, obj.Headers, metadataHeadersToFilter...)
-		// maybe the server does not return date, we should set it then
-		if obj.Headers.Get("Date") == "" {
-			obj.Headers.Set("Date", now.Format(http.TimeFormat))
-		}
-
-		//!TODO: consult the cache algorithm whether to save the metadata
-		//!TODO: optimize this, save the metadata only when it's newer
-		//!TODO: also, error if we already have fresh metadata but the
-		//       received metadata is different
-		if err := h.Cache.Storage.SaveMetadata(obj); err != nil {
-			h.Logger.Errorf("[%s] Could not save metadata for %s: %s",
-				h.reqID, obj.ID, err)
-			rw.BodyWriter = utils.AddCloser(h.resp)
+		if !h.saveMetadata(rw, responseRange, expiresIn) {
+			rw.BodyWriter = client
 			return
 		}
 
+		h.scheduleExpiration(expiresIn)
 		if h.req.Method == "HEAD" {
-			rw.BodyWriter = utils.AddCloser(h.resp)
+			rw.BodyWriter = client
 			return
 		}
 
 		rw.BodyWriter = utils.MultiWriteCloser(
-			utils.AddCloser(h.resp),
-			PartWriter(h.Cache, h.objID, *responseRange),
+			client,
+			h.newPartWriter(*responseRange),
 		)
+	}
+}
 
-		h.Logger.Debugf("[%s] Setting the cached data to expire in %s", h.reqID, expiresIn)
-		h.Cache.Scheduler.AddEvent(
-			h.objID.Hash(),
-			storage.GetExpirationHandler(h.Cache, h.objID),
-			expiresIn,
-		)
+// saveMetadata saves the metadata of the cacheable upstream response in the
+// storage. It returns whether that was successful.
+func (h *reqHandler) saveMetadata(rw *httputils.FlexibleResponseWriter,
+	responseRange *httputils.ContentRange, expiresIn time.Duration) bool {
+
+	code := rw.Code
+	if code == http.StatusPartialContent {
+		// 206 is returned only if the server would
+		// have returned 200 with a normal request
+		code = http.StatusOK
+	}
+
+	//!TODO: maybe call cached time.Now. See the comment in utils.IsMetadataFresh
+	now := time.Now()
+
+	if err := h.updateVariants(rw.Headers, now.Add(expiresIn)); err != nil {
+		h.Logger.Errorf("[%s] Could not save the variants of %s: %s",
+			h.reqID, h.req.URL, err)
+		return false
+	}
+
+	obj := &types.ObjectMetadata{
+		ID:                h.objID,
+		ResponseTimestamp: now.Unix(),
+		Code:              code,
+		Size:              responseRange.ObjSize,
+		Headers:           make(http.Header),
+		ExpiresAt:         now.Add(expiresIn).Unix(),
+
+		StaleWhileRevalidate: h.staleWhileRevalidate(rw.Headers),
+		StaleIfError:         h.staleIfError(rw.Headers),
+		Tags:                 cacheutils.ResponseTags(rw.Headers),
+	}
+	obj.RequestURL, obj.RequestHeaders = h.refreshRequest(rw.Headers)
+	httputils.CopyHeadersWithout(rw.Headers, obj.Headers, metadataHeadersToFilter...)
+	// maybe the server does not return date, we should set it then
+	if obj.Headers.Get("Date") == "" {
+		obj.Headers.Set("Date", now.Format(http.TimeFormat))
 	}
+
+	//!TODO: optimize this, save the metadata only when it's newer
+	//!TODO: also, error if we already have fresh metadata but the
+	//       received metadata is different
+	if err := h.Cache.Storage.SaveMetadata(obj); err != nil {
+		h.Logger.Errorf("[%s] Could not save metadata for %s: %s",
+			h.reqID, obj.ID, err)
+		return false
+	}
+	h.Cache.Tags.Set(obj.ID, obj.Tags...)
+	return true
+}
+
+func (h *reqHandler) scheduleExpiration(expiresIn time.Duration) {
+	h.Logger.Debugf("[%s] Setting the cached data to expire in %s", h.reqID, expiresIn)
+	refresh := h.refreshAheadJob()
+	h.Cache.Scheduler.AddEvent(
+		h.objID.Hash(),
+		storage.GetRefreshingExpirationHandler(h.Cache, h.objID, refresh),
+		storage.ExpirationEventIn(h.Cache, expiresIn, refresh != nil),
+	)
 }
 
 func idSuffix(s, e uint64) []byte {
 	return strconv.AppendUint(append(strconv.AppendUint([]byte(`->b=`), s, 10), '-'), e, 10)
 }
 
-func (h *reqHandler) getUpstreamReader(start, end uint64) io.ReadCloser {
+func (h *reqHandler) getUpstreamReader(start, end uint64, reserved map[uint32]*inflightPart) io.ReadCloser {
 	subh := *h
 	// ->start-end
 	var newCtx context.Context
 	newCtx, subh.reqID = contexts.AppendToRequestID(subh.req.Context(), idSuffix(start, end))
 	subh.req = subh.getNormalizedRequest()
 	subh.req = subh.req.WithContext(newCtx)
-	subh.req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
-
-	h.Logger.Debugf("[%s] Making upstream request for %s, bytes [%d-%d]...",
-		subh.reqID, subh.req.URL, start, end)
-
-	//!TODO: optimize requests for the same pieces?
-	//       if possible, make only 1 request to the upstream for the same part
+	subh.reserved = reserved
+	if h.noRangeObjects.contains(h.objID.Hash()) {
+		subh.req.Header.Del("Range")
+		h.Logger.Debugf("[%s] The upstream does not support ranges for %s, making upstream request for the whole %s...",
+			subh.reqID, h.objID, subh.req.URL)
+	} else {
+		subh.req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+		h.Logger.Debugf("[%s] Making upstream request for %s, bytes [%d-%d]...",
+			subh.reqID, subh.req.URL, start, end)
+	}
 
 	r, w := io.Pipe()
 	subh.resp = httputils.NewFlexibleResponseWriter(func(rw *httputils.FlexibleResponseWriter) {
 		respRng, err := httputils.GetResponseRange(rw.Code, rw.Headers)
-		if err != nil {
+		h.Logger.Debugf("[%s] Received response with status %d and range %v",
+			subh.reqID, rw.Code, respRng)
+		if rw.Code == http.StatusOK {
+			if h.noRangeObjects.add(h.objID.Hash()) {
+				h.Logger.Logf("[%s] The upstream responded with the whole %s to a range request, "+
+					"it will be requested without ranges for %s", subh.reqID, h.objID, noRangeRecheckAfter)
+			}
+			if reserved != nil {
+				// the whole object is cached by the response hook
+				h.reserveMissingParts(reserved)
+			}
+			rangeW := newRangeWriter(w, start, end)
+			// the reader may be gone long before the object is cached
+			rangeW.ignoreErrors = true
+			rw.BodyWriter = rangeW
+		} else if rw.Code == h.obj.Code && h.isNegativelyCached(rw.Code) {
+			// the negatively cached responses do not support ranges
+			rangeW := newRangeWriter(w, start, end)
+			rangeW.ignoreErrors = true
+			rw.BodyWriter = rangeW
+		} else if err != nil {
 			h.Logger.Debugf("[%s] Could not parse the content-range"+
 				"for the partial upstream request: %s",
 				subh.reqID, err)
 			_ = w.CloseWithError(err)
-		}
-		h.Logger.Debugf("[%s] Received response with status %d and range %v",
-			subh.reqID, rw.Code, respRng)
-		if rw.Code == http.StatusPartialContent {
-			//!TODO: check whether the returned range corresponds to the requested range
+		} else if rw.Code == http.StatusPartialContent {
 			rw.BodyWriter = w
-		} else if rw.Code == http.StatusOK {
-			//!TODO: handle this, use skipWriter or something like that
-			_ = w.CloseWithError(fmt.Errorf("NOT IMPLEMENTED"))
 		} else {
 			_ = w.CloseWithError(
 				fmt.Errorf("Upstream responded with status %d", rw.Code))
 		}
 	})
 	go utils.SafeExecute(
-		subh.carbonCopyProxy,
+		func() {
+			defer h.inflight.abort(h.objID, reserved)
+			subh.proxy(subh.req, func(rw *httputils.FlexibleResponseWriter) {
+				if err := h.checkPartialResponse(rw, start, end); err != nil {
+					subh.abortPartialResponse(err)
+					_ = w.CloseWithError(err)
+					return
+				}
+				subh.getResponseHook()(rw)
+			})
+		},
 		func(err error) {
-			h.Logger.Errorf("[%s] Panic inside carbonCopyProxy %s", subh.reqID, err)
+			h.Logger.Errorf("[%s] Panic while proxying the partial request: %s", subh.reqID, err)
 			w.CloseWithError(err) // !TODO maybe some other error
 		},
 	)
 	return newWholeChunkReadCloser(r, h.Cache.PartSize.Bytes())
 }
 
+// newPartWriter returns a PartWriter which also serves the parts it is writing
+// to the concurrent requests for them. If the request has not reserved the
+// in-flight parts in advance, the writer reserves the ones which are not
+// already being downloaded by someone else.
+func (h *reqHandler) newPartWriter(cr httputils.ContentRange) io.WriteCloser {
+	pw := PartWriter(h.Cache, h.objID, cr).(*partWriter)
+	pw.inflight = h.inflight
+	pw.owned = h.reserved
+	if pw.owned != nil {
+		return pw
+	}
+
+	pw.owned = make(map[uint32]*inflightPart)
+	if cr.Length == 0 {
+		return pw
+	}
+	first := (cr.Start + pw.partSize - 1) / pw.partSize
+	last := (cr.Start + cr.Length) / pw.partSize
+	if cr.Start+cr.Length == cr.ObjSize && cr.ObjSize%pw.partSize != 0 {
+		last++ // the last smaller part of the object
+	}
+	for num := first; num < last; num++ {
+		idx := &types.ObjectIndex{ObjID: h.objID, Part: uint32(num)}
+		if part, ok := h.inflight.reserve(idx); ok {
+			pw.owned[idx.Part] = part
+		}
+	}
+	return pw
+}
+
+// getInflightReader returns a reader for a part that is being downloaded by
+// another request. If that download fails, the rest of the part is requested
+// from the upstream.
+func (h *reqHandler) getInflightReader(idx *types.ObjectIndex, part *inflightPart) io.ReadCloser {
+	partSize := h.Cache.Storage.PartSize()
+	return &inflightReader{
+		part: part,
+		fallback: func(offset int) (io.ReadCloser, error) {
+			h.Logger.Debugf("[%s] Download of %s by another request failed after %d bytes",
+				h.reqID, idx, offset)
+			fromByte := uint64(idx.Part) * partSize
+			toByte := umin(h.obj.Size, fromByte+partSize) - 1
+			return utils.SkipReadCloser(h.getUpstreamReader(fromByte, toByte, nil), int64(offset))
+		},
+	}
+}
+
 // if error is returned - it is 'too many open files'
 func (h *reqHandler) getPartFromStorage(idx *types.ObjectIndex) (io.ReadCloser, error) {
 	cached := h.Cache.Algorithm.Lookup(idx)
 	r, err := h.Cache.Storage.GetPart(idx)
 	if err == nil {
-		h.Cache.Algorithm.PromoteObject(idx)
-		return r, nil
+		if r, err = h.verifyPart(idx, r); err == nil {
+			h.Cache.Algorithm.PromoteObject(idx)
+			return r, nil
+		}
 	}
-	if !os.IsNotExist(err) {
+	if corrupted, ok := err.(*corruptedPartError); ok {
+		// the part is downloaded again from the upstream instead
+		h.discardCorruptedPart(idx, corrupted)
+	} else if !os.IsNotExist(err) {
 		if isTooManyFiles(err) {
 			return nil, err
 		}
@@ -214,36 +360,55 @@ func (h *reqHandler) getContents(indexes []*types.ObjectIndex, from int,
 		return nil, 0, err
 	}
 
+	h.servedFromUpstream()
+	inflight, ok := h.inflight.reserve(indexes[from])
+	if !ok {
+		h.Logger.Debugf("[%s] Part %s is already being downloaded, reading it from there",
+			h.reqID, indexes[from])
+		return h.getInflightReader(indexes[from], inflight), 1, nil
+	}
+	var reserved = map[uint32]*inflightPart{indexes[from].Part: inflight}
+
 	partSize := h.Cache.Storage.PartSize()
 	fromByte := uint64(indexes[from].Part) * partSize
 	parts, err := h.Cache.Storage.GetAvailableParts(h.objID)
 	if err != nil {
+		h.inflight.abort(h.objID, reserved)
 		return nil, 0, err
 	}
 	sort.Sort(objectIndexes(parts))
 	i := sort.Search(len(parts), func(i int) bool {
-		return (parts[i].Part > indexes[from].Part &&
-			parts[i].Part <= indexes[len(indexes)-1].Part)
+		return parts[i].Part > indexes[from].Part
 	})
-	if i < len(parts) { // there is a part
-		r, _ = h.getPartFromStorage(parts[i])
-		if r != nil {
-			toByte := umin(h.obj.Size, uint64(parts[i].Part)*partSize-1)
-			return utils.MultiReadCloser(
-					h.getUpstreamReader(fromByte, toByte), r),
-				int(parts[i].Part-indexes[from].Part) + 1, nil
+
+	// the upstream request is for all the parts up until the next one which is
+	// either in the storage or is being downloaded by someone else
+	to := len(indexes)
+	if i < len(parts) && parts[i].Part <= indexes[len(indexes)-1].Part {
+		to = from + int(parts[i].Part-indexes[from].Part)
+	}
+	for j := from + 1; j < to; j++ {
+		if inflight, ok = h.inflight.reserve(indexes[j]); !ok {
+			to = j
+			break
 		}
+		reserved[indexes[j].Part] = inflight
 	}
 
-	toByte := umin(h.obj.Size, uint64(indexes[len(indexes)-1].Part+1)*partSize-1)
-	return h.getUpstreamReader(fromByte, toByte), len(indexes) - from, nil
+	toByte := umin(h.obj.Size, uint64(indexes[to-1].Part+1)*partSize) - 1
+	return h.getUpstreamReader(fromByte, toByte, reserved), to - from, nil
 }
 
-func (h *reqHandler) lazilyRespond(start, end uint64) {
+// lazilyRespond writes the object's bytes from start to end (inclusive) to the
+// client, reading them from the storage or the upstream. It returns whether
+// all of them were sent successfully.
+func (h *reqHandler) lazilyRespond(start, end uint64) bool {
 	partSize := h.Cache.Storage.PartSize()
 	indexes := utils.BreakInIndexes(h.objID, start, end, partSize)
 	startOffset := start % partSize
 	var shouldReturn = false
+	h.checkStoredParts(indexes)
+	h.readAhead(indexes[len(indexes)-1].Part)
 
 	for i := 0; i < len(indexes); {
 		contents, partsCount, err := h.getContents(indexes, i)
@@ -251,7 +416,7 @@ func (h *reqHandler) lazilyRespond(start, end uint64) {
 			h.Logger.Errorf(
 				"[%s] Unexpected error while trying to load %s from storage: %s",
 				h.reqID, indexes[i], err)
-			return
+			return false
 		}
 		if i == 0 && startOffset > 0 {
 			contents, err = utils.SkipReadCloser(contents, int64(startOffset))
@@ -259,7 +424,7 @@ func (h *reqHandler) lazilyRespond(start, end uint64) {
 				h.Logger.Errorf(
 					"[%s] Unexpected error while trying to skip %d from %s: %s",
 					h.reqID, startOffset, indexes[i], err)
-				return
+				return false
 			}
 		}
 		if i+partsCount == len(indexes) {
@@ -288,11 +453,12 @@ func (h *reqHandler) lazilyRespond(start, end uint64) {
 		}
 
 		if shouldReturn {
-			return
+			return false
 		}
 
 		i += partsCount
 	}
+	return true
 }
 
 func isTooManyFiles(err error) bool {
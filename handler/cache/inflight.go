@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/ironsmile/nedomi/types"
+)
+
+// errInflightAborted is returned to the readers of an in-flight part when the
+// upstream request that was downloading it did not receive the whole part.
+var errInflightAborted = errors.New("the download of the part was aborted")
+
+// inflightPart is an object part which is currently being downloaded from the
+// upstream. Its buffer is filled by the partWriter which is saving the part to
+// the storage and any number of readers can stream from it at the same time.
+type inflightPart struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  []byte
+	done bool
+	err  error
+}
+
+func newInflightPart() *inflightPart {
+	p := &inflightPart{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// update makes the contents of buf available to the readers. The partWriter
+// only ever appends to buf so the readers can safely use the old contents.
+func (p *inflightPart) update(buf []byte) {
+	p.mu.Lock()
+	p.buf = buf
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// finish signals the readers that there will be no more data for the part. If
+// err is nil, the part has been completely received.
+func (p *inflightPart) finish(err error) {
+	p.mu.Lock()
+	if !p.done {
+		p.done = true
+		p.err = err
+	}
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// readAt blocks until there is data after `off` or the part is finished.
+func (p *inflightPart) readAt(b []byte, off int) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for off >= len(p.buf) && !p.done {
+		p.cond.Wait()
+	}
+	if off < len(p.buf) {
+		return copy(b, p.buf[off:]), nil
+	}
+	if p.err != nil {
+		return 0, p.err
+	}
+	return 0, io.EOF
+}
+
+// inflightParts keeps track of all the object parts that are being downloaded
+// at the moment so that concurrent requests for the same part result in only
+// one upstream request.
+type inflightParts struct {
+	mu    sync.Mutex
+	parts map[types.ObjectIndexHash]*inflightPart
+}
+
+func newInflightParts() *inflightParts {
+	return &inflightParts{
+		parts: make(map[types.ObjectIndexHash]*inflightPart),
+	}
+}
+
+// reserve returns the in-flight part for the supplied index. If there was no
+// such part, it is created and the second result is true - the caller is then
+// responsible for downloading it or aborting it.
+func (ip *inflightParts) reserve(idx *types.ObjectIndex) (*inflightPart, bool) {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	if part, ok := ip.parts[idx.Hash()]; ok {
+		return part, false
+	}
+	part := newInflightPart()
+	ip.parts[idx.Hash()] = part
+	return part, true
+}
+
+// remove stops tracking the part for the supplied index, if it is still the
+// same one. New requests for it will have to go to the storage.
+func (ip *inflightParts) remove(idx *types.ObjectIndex, part *inflightPart) {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	if ip.parts[idx.Hash()] == part {
+		delete(ip.parts, idx.Hash())
+	}
+}
+
+// abort finishes with an error and removes all of the supplied parts which
+// were not completely downloaded.
+func (ip *inflightParts) abort(objID *types.ObjectID, parts map[uint32]*inflightPart) {
+	for num, part := range parts {
+		part.finish(errInflightAborted)
+		ip.remove(&types.ObjectIndex{ObjID: objID, Part: num}, part)
+		delete(parts, num)
+	}
+}
+
+// inflightReader reads a whole part from an inflightPart. If the download of
+// the part is aborted midway, the rest of it is read from the fallback.
+type inflightReader struct {
+	part     *inflightPart
+	read     int
+	fallback func(offset int) (io.ReadCloser, error)
+	rest     io.ReadCloser
+}
+
+func (r *inflightReader) Read(b []byte) (int, error) {
+	if r.rest != nil {
+		return r.rest.Read(b)
+	}
+	n, err := r.part.readAt(b, r.read)
+	r.read += n
+	if err == errInflightAborted && r.fallback != nil {
+		if r.rest, err = r.fallback(r.read); err != nil {
+			return n, err
+		}
+		if n == 0 {
+			return r.rest.Read(b)
+		}
+		return n, nil
+	}
+	return n, err
+}
+
+func (r *inflightReader) Close() error {
+	if r.rest != nil {
+		return r.rest.Close()
+	}
+	return nil
+}
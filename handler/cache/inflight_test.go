@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestConcurrentMissesAreCollapsed(t *testing.T) {
+	t.Parallel()
+	var file = "collapsed"
+	var fsmap = map[string]string{file: testutils.GenerateMeAString(3, 100)}
+	var rangedRequests uint32
+	var release = make(chan struct{})
+	var fs = fsMapHandler(fsmap)
+	app := newTestAppFromMap(t, fsmap)
+	defer app.cleanup()
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			atomic.AddUint32(&rangedRequests, 1)
+			<-release
+		}
+		fs(w, r)
+	}))
+
+	// get only the metadata in the storage
+	req, err := http.NewRequest("HEAD", "http://example.com/"+file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.testRequest(req, "", http.StatusOK)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.testFullRequest(file)
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadUint32(&rangedRequests); got != 1 {
+		t.Errorf("expected 1 upstream request for the missing parts but there were %d", got)
+	}
+	app.testFullRequest(file)
+}
+
+func TestInflightReaderFallback(t *testing.T) {
+	t.Parallel()
+	var expected = "the whole part"
+	var part = newInflightPart()
+	var fallbackOffset = -1
+	var r = &inflightReader{
+		part: part,
+		fallback: func(offset int) (io.ReadCloser, error) {
+			fallbackOffset = offset
+			return ioutil.NopCloser(strings.NewReader(expected[offset:])), nil
+		},
+	}
+
+	part.update([]byte(expected[:4]))
+	go part.finish(errInflightAborted)
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if string(got) != expected {
+		t.Errorf("expected to read '%s' but got '%s'", expected, got)
+	}
+	if fallbackOffset != 4 {
+		t.Errorf("expected the fallback to be called with offset 4 not %d", fallbackOffset)
+	}
+}
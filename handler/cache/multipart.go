@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+
+	"github.com/ironsmile/nedomi/utils/httputils"
+)
+
+// knownMultiRanged responds to a request for multiple ranges with a
+// multipart/byteranges body: https://tools.ietf.org/html/rfc7233#appendix-A
+// Each of the ranges is assembled by lazilyRespond, so parts missing from the
+// storage are retrieved from the upstream as usual.
+func (h *reqHandler) knownMultiRanged(ranges []httputils.Range) {
+	var mw = multipart.NewWriter(h.resp)
+	var contentType = h.obj.Headers.Get("Content-Type")
+
+	httputils.CopyHeaders(h.obj.Headers, h.resp.Header())
+	h.resp.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	h.resp.Header().Set("Content-Length", strconv.FormatUint(
+		multipartByterangesSize(ranges, contentType, h.obj.Size, mw.Boundary()), 10))
+	h.rewriteTimeBasedHeaders()
+	h.resp.WriteHeader(http.StatusPartialContent)
+	if h.req.Method == "HEAD" {
+		return
+	}
+
+	for _, r := range ranges {
+		if _, err := mw.CreatePart(rangePartHeader(r, contentType, h.obj.Size)); err != nil {
+			h.Logger.Logf("[%s] Error while writing the multipart header of range %s: %s",
+				h.reqID, r.Range(), err)
+			return
+		}
+		if !h.lazilyRespond(r.Start, r.Start+r.Length-1) {
+			return
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		h.Logger.Logf("[%s] Error while closing the multipart response: %s", h.reqID, err)
+	}
+}
+
+func rangePartHeader(r httputils.Range, contentType string, size uint64) textproto.MIMEHeader {
+	var header = textproto.MIMEHeader{
+		"Content-Range": {r.ContentRange(size)},
+	}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return header
+}
+
+// multipartByterangesSize returns the length of the multipart/byteranges body
+// for the supplied ranges without generating it.
+func multipartByterangesSize(ranges []httputils.Range, contentType string, size uint64, boundary string) uint64 {
+	var w countingWriter
+	var mw = multipart.NewWriter(&w)
+	// the boundary is always valid as it was generated by another writer
+	_ = mw.SetBoundary(boundary)
+	for _, r := range ranges {
+		_, _ = mw.CreatePart(rangePartHeader(r, contentType, size))
+		w += countingWriter(r.Length)
+	}
+	_ = mw.Close()
+	return uint64(w)
+}
+
+func sumRangesSize(ranges []httputils.Range) (sum uint64) {
+	for _, r := range ranges {
+		sum += r.Length
+	}
+	return
+}
+
+// countingWriter counts how many bytes have been written to it.
+type countingWriter uint64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestMultiRangeFromCache(t *testing.T) {
+	t.Parallel()
+	var file = "multiranged"
+	var contents = testutils.GenerateMeAString(8, 100)
+	app := newTestAppFromMap(t, map[string]string{file: contents})
+	defer app.cleanup()
+
+	// get only the metadata in the storage so the parts come from upstream
+	req, err := http.NewRequest("HEAD", "http://example.com/"+file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.testRequest(req, "", http.StatusOK)
+
+	var expected = []struct {
+		contentRange, body string
+	}{
+		{"bytes 0-4/100", contents[0:5]},
+		{"bytes 12-33/100", contents[12:34]},
+		{"bytes 97-99/100", contents[97:]},
+	}
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", "http://example.com/"+file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Range", "bytes=0-4,12-33,-3")
+		var rec = httptest.NewRecorder()
+		app.cacheHandler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusPartialContent {
+			t.Fatalf("expected code %d but got %d", http.StatusPartialContent, rec.Code)
+		}
+		if cl := rec.Header().Get("Content-Length"); cl != strconv.Itoa(rec.Body.Len()) {
+			t.Errorf("expected Content-Length %d but got %s", rec.Body.Len(), cl)
+		}
+		mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("unexpected Content-Type '%s' (%v)", rec.Header().Get("Content-Type"), err)
+		}
+
+		var mr = multipart.NewReader(rec.Body, params["boundary"])
+		for _, exp := range expected {
+			part, err := mr.NextPart()
+			if err != nil {
+				t.Fatalf("unexpected error while reading the next part: %s", err)
+			}
+			if got := part.Header.Get("Content-Range"); got != exp.contentRange {
+				t.Errorf("expected Content-Range '%s' but got '%s'", exp.contentRange, got)
+			}
+			body, err := ioutil.ReadAll(part)
+			if err != nil {
+				t.Fatalf("unexpected error while reading part %s: %s", exp.contentRange, err)
+			}
+			if string(body) != exp.body {
+				t.Errorf("expected part %s to be '%s' but it was '%s'", exp.contentRange, exp.body, body)
+			}
+		}
+		if _, err := mr.NextPart(); err == nil {
+			t.Error("expected no more parts")
+		}
+	}
+}
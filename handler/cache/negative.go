@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ironsmile/nedomi/utils/cacheutils"
+	"github.com/ironsmile/nedomi/utils/httputils"
+)
+
+// isNegativelyCached returns whether the responses with this status code are
+// cached by the location even though they are redirects or errors.
+func (h *reqHandler) isNegativelyCached(code int) bool {
+	_, ok := h.NegativeCacheTTLs[code]
+	return ok
+}
+
+// negativeCacheTTL returns for how long the upstream redirect or error
+// response should be cached and whether it should be cached at all.
+func (h *reqHandler) negativeCacheTTL(rw *httputils.FlexibleResponseWriter) (time.Duration, bool) {
+	ttl, ok := h.NegativeCacheTTLs[rw.Code]
+	if !ok {
+		return 0, false
+	}
+	var encodings []string
+	if h.settings.CacheEncoded {
+		encodings = cacheableEncodings
+	}
+	return ttl, cacheutils.IsNegativeResponseCacheable(rw.Code, rw.Headers, encodings...)
+}
+
+// getResponseRange is like httputils.GetResponseRange but it also returns the
+// range of the whole body of the negatively cached responses.
+func (h *reqHandler) getResponseRange(rw *httputils.FlexibleResponseWriter) (*httputils.ContentRange, error) {
+	if h.isNegativelyCached(rw.Code) {
+		return httputils.GetResponseRange(http.StatusOK, rw.Headers)
+	}
+	return httputils.GetResponseRange(rw.Code, rw.Headers)
+}
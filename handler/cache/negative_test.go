@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNegativeCaching(t *testing.T) {
+	t.Parallel()
+	var body = "these are not the droids you are looking for"
+	var tests = []struct {
+		path     string
+		code     int
+		headers  http.Header
+		requests uint32 // the expected number of upstream requests
+	}{
+		{path: "missing", code: 404, requests: 1,
+			headers: http.Header{"Content-Length": {strconv.Itoa(len(body))}}},
+		{path: "missing-chunked", code: 404, requests: 1,
+			headers: http.Header{}},
+		{path: "moved", code: 302, requests: 1,
+			headers: http.Header{"Location": {"/elsewhere"}}},
+		{path: "failing", code: 503, requests: 1,
+			headers: http.Header{"Content-Length": {strconv.Itoa(len(body))}}},
+		{path: "missing-private", code: 404, requests: 3,
+			headers: http.Header{"Cache-Control": {"private"}}},
+		{path: "gone", code: 410, requests: 3,
+			headers: http.Header{}},
+	}
+	app := newTestAppFromMap(t, map[string]string{})
+	defer app.cleanup()
+	app.cacheHandler.settings.MaxBufferedSize = 1024
+	app.cacheHandler.NegativeCacheTTLs = map[int]time.Duration{
+		404: time.Minute,
+		302: time.Minute,
+		503: 10 * time.Second,
+	}
+	var requests = make([]uint32, len(tests))
+	for index, test := range tests {
+		var index, test = index, test
+		app.up.Handle("/"+test.path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddUint32(&requests[index], 1)
+			for key, values := range test.headers {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(test.code)
+			_, _ = w.Write([]byte(body))
+		}))
+	}
+
+	for index, test := range tests {
+		for i := 0; i < 3; i++ {
+			req, err := http.NewRequest("GET", "http://example.com/"+test.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i == 2 {
+				req.Header.Set("Range", "bytes=5-9")
+			}
+			var rec = httptest.NewRecorder()
+			app.cacheHandler.ServeHTTP(rec, req)
+			if rec.Code != test.code || rec.Body.String() != body {
+				t.Errorf("request %d for %s: expected status %d and the whole body but got %d and '%s'",
+					i, test.path, test.code, rec.Code, rec.Body.String())
+			}
+			if location := test.headers.Get("Location"); rec.Header().Get("Location") != location {
+				t.Errorf("request %d for %s: expected location '%s' but got '%s'",
+					i, test.path, location, rec.Header().Get("Location"))
+			}
+		}
+		if got := atomic.LoadUint32(&requests[index]); got != test.requests {
+			t.Errorf("expected %d upstream requests for %s but got %d", test.requests, test.path, got)
+		}
+	}
+
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/failing"})
+	obj, err := app.cacheHandler.Cache.Storage.GetMetadata(objID)
+	if err != nil {
+		t.Fatalf("unexpected error while getting the metadata: %s", err)
+	}
+	if expiresIn := time.Unix(obj.ExpiresAt, 0).Sub(time.Now()); expiresIn > 10*time.Second {
+		t.Errorf("expected the negatively cached object to expire in 10s but it expires in %s", expiresIn)
+	}
+}
+
+func TestNegativelyCachedMetadataWithoutBody(t *testing.T) {
+	t.Parallel()
+	var body = "not found"
+	var requests uint32
+	app := newTestAppFromMap(t, map[string]string{})
+	defer app.cleanup()
+	app.cacheHandler.NegativeCacheTTLs = map[int]time.Duration{404: time.Minute}
+	app.up.Handle("/head-first", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&requests, 1)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusNotFound)
+		if r.Method != "HEAD" {
+			_, _ = w.Write([]byte(body))
+		}
+	}))
+
+	req, err := http.NewRequest("HEAD", "http://example.com/head-first", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.testRequest(req, "", http.StatusNotFound)
+	for i := 0; i < 2; i++ {
+		req, err = http.NewRequest("GET", "http://example.com/head-first", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.testRequest(req, body, http.StatusNotFound)
+	}
+	if got := atomic.LoadUint32(&requests); got != 2 {
+		t.Errorf("expected the body to be requested from the upstream once but there were %d requests", got)
+	}
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ironsmile/nedomi/types"
+)
+
+// noRangeRecheckAfter is for how long the objects whose upstream responded
+// with the whole object to a range request are requested without ranges.
+// After that the upstream is given another chance, as it may have changed.
+const noRangeRecheckAfter = 10 * time.Minute
+
+// expiringSet is a concurrency-safe set of objects in which every object is
+// kept only for a limited time.
+type expiringSet struct {
+	sync.Mutex
+	ttl       time.Duration
+	objects   map[types.ObjectIDHash]time.Time
+	nextPurge time.Time
+}
+
+func newExpiringSet(ttl time.Duration) *expiringSet {
+	return &expiringSet{
+		ttl:       ttl,
+		objects:   make(map[types.ObjectIDHash]time.Time),
+		nextPurge: time.Now().Add(ttl),
+	}
+}
+
+// add adds the object to the set, or prolongs its stay there, and returns
+// whether it was not there before.
+func (s *expiringSet) add(hash types.ObjectIDHash) bool {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	if now.After(s.nextPurge) {
+		// the objects which are never checked again are removed here
+		for hash, expiresAt := range s.objects {
+			if now.After(expiresAt) {
+				delete(s.objects, hash)
+			}
+		}
+		s.nextPurge = now.Add(s.ttl)
+	}
+	expiresAt, ok := s.objects[hash]
+	s.objects[hash] = now.Add(s.ttl)
+	return !ok || now.After(expiresAt)
+}
+
+func (s *expiringSet) contains(hash types.ObjectIDHash) bool {
+	s.Lock()
+	defer s.Unlock()
+	expiresAt, ok := s.objects[hash]
+	if ok && time.Now().After(expiresAt) {
+		delete(s.objects, hash)
+		return false
+	}
+	return ok
+}
+
+// reserveMissingParts reserves all the parts of the object which are neither
+// stored nor being downloaded, so that concurrent requests for them read them
+// from the whole object that is being downloaded.
+func (h *reqHandler) reserveMissingParts(reserved map[uint32]*inflightPart) {
+	stored, err := h.Cache.Storage.GetAvailableParts(h.objID)
+	if err != nil {
+		h.Logger.Errorf("[%s] Storage error when getting the parts of the object: %s",
+			h.reqID, err)
+		return
+	}
+	var isStored = make(map[uint32]bool, len(stored))
+	for _, idx := range stored {
+		isStored[idx.Part] = true
+	}
+
+	partSize := h.Cache.Storage.PartSize()
+	partsCount := uint32((h.obj.Size + partSize - 1) / partSize)
+	for part := uint32(0); part < partsCount; part++ {
+		if _, ok := reserved[part]; ok || isStored[part] {
+			continue
+		}
+		if inflight, ok := h.inflight.reserve(&types.ObjectIndex{ObjID: h.objID, Part: part}); ok {
+			reserved[part] = inflight
+		}
+	}
+}
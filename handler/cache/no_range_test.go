@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestOriginWithoutRangeSupport(t *testing.T) {
+	t.Parallel()
+	var files = map[string]string{
+		"first":  testutils.GenerateMeAString(14, 53),
+		"second": testutils.GenerateMeAString(15, 71),
+	}
+	var getRequests, rangeRequests uint32
+	app := newTestAppFromMap(t, files)
+	defer app.cleanup()
+	for file, contents := range files {
+		var file, contents = file, contents
+		app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=3600")
+			w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+			w.WriteHeader(http.StatusOK)
+			if r.Method == "HEAD" {
+				return
+			}
+			atomic.AddUint32(&getRequests, 1)
+			if r.Header.Get("Range") != "" {
+				atomic.AddUint32(&rangeRequests, 1)
+			}
+			_, _ = w.Write([]byte(contents))
+		}))
+	}
+
+	for _, file := range []string{"first", "second"} {
+		// get only the metadata in the storage
+		req, err := http.NewRequest("HEAD", "http://example.com/"+file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.testRequest(req, "", http.StatusOK)
+
+		app.testRange(file, 12, 22)
+		app.testRange(file, 40, 10)
+		app.testFullRequest(file)
+
+		// the missing parts are requested without a range from now on
+		objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+		waitForParts(t, app, objID, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+		idx := &types.ObjectIndex{ObjID: objID, Part: 9}
+		if err := app.cacheHandler.Cache.Storage.DiscardPart(idx); err != nil {
+			t.Fatalf("unexpected error while discarding %s: %s", idx, err)
+		}
+		app.cacheHandler.Cache.Algorithm.Remove(idx)
+		app.testRange(file, 45, 3)
+	}
+
+	if got := atomic.LoadUint32(&getRequests); got != 4 {
+		t.Errorf("expected 2 upstream requests for each file but got %d", got)
+	}
+	if got := atomic.LoadUint32(&rangeRequests); got != 2 {
+		t.Errorf("expected only the first upstream request for each file to be for a range but got %d", got)
+	}
+}
+
+func TestExpiringSet(t *testing.T) {
+	t.Parallel()
+	var first, second = types.ObjectIDHash{1}, types.ObjectIDHash{2}
+	var set = newExpiringSet(50 * time.Millisecond)
+	if !set.add(first) || set.add(first) {
+		t.Error("expected only the first addition of the object to be new")
+	}
+	if !set.contains(first) || set.contains(second) {
+		t.Error("expected only the added object to be in the set")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if set.contains(first) {
+		t.Error("expected the object to be removed from the set after its ttl")
+	}
+	if !set.add(second) || !set.add(first) {
+		t.Error("expected the expired object to be added again")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	set.add(second)
+	set.Lock()
+	defer set.Unlock()
+	if _, ok := set.objects[first]; ok || len(set.objects) != 1 {
+		t.Errorf("expected the expired objects to be purged but the set has %d", len(set.objects))
+	}
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"net/http"
+
+	"github.com/ironsmile/nedomi/utils"
+	"github.com/ironsmile/nedomi/utils/httputils"
+)
+
+// gatewayTimeout responds to only-if-cached requests which cannot be served
+// from the cache: https://tools.ietf.org/html/rfc7234#section-5.2.1.7
+func (h *reqHandler) gatewayTimeout() {
+	code := http.StatusGatewayTimeout
+	http.Error(h.resp, http.StatusText(code), code)
+}
+
+// hasCachedParts returns whether all the parts of the object which are needed
+// for serving the request are in the cache, so that it can be served without
+// making upstream requests.
+func (h *reqHandler) hasCachedParts() bool {
+	if h.req.Method == "HEAD" || h.obj.Size == 0 {
+		return true
+	}
+
+	start, end := uint64(0), h.obj.Size-1
+	if rng := h.req.Header.Get("Range"); rng != "" && h.obj.Code == http.StatusOK &&
+		httputils.IfRangeMatches(h.req, h.obj.Headers) {
+		ranges, err := httputils.ParseRequestRange(rng, h.obj.Size)
+		if err != nil {
+			// the request is not satisfiable anyway
+			return true
+		}
+		if len(ranges) == 1 && ranges[0].Length > 0 {
+			start, end = ranges[0].Start, ranges[0].Start+ranges[0].Length-1
+		}
+	}
+
+	for _, idx := range utils.BreakInIndexes(h.objID, start, end, h.Cache.Storage.PartSize()) {
+		if !h.Cache.Algorithm.Lookup(idx) {
+			return false
+		}
+	}
+	return true
+}
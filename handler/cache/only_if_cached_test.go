@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestOnlyIfCachedRequests(t *testing.T) {
+	t.Parallel()
+	var file = "only-if-cached"
+	var contents = testutils.GenerateMeAString(21, 50)
+	var upstreamRequests uint32
+	app := newTestAppFromMap(t, map[string]string{file: contents})
+	defer app.cleanup()
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&upstreamRequests, 1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		http.ServeContent(w, r, file, time.Time{}, strings.NewReader(contents))
+	}))
+	var onlyIfCached = func(rng string, expected string, code int) {
+		req, err := http.NewRequest("GET", "http://example.com/"+file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Cache-Control", "only-if-cached")
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		app.testRequest(req, expected, code)
+	}
+	var gatewayTimeout = http.StatusText(http.StatusGatewayTimeout) + "\n"
+
+	onlyIfCached("", gatewayTimeout, http.StatusGatewayTimeout)
+	if got := atomic.LoadUint32(&upstreamRequests); got != 0 {
+		t.Errorf("expected no upstream requests for the missing object but got %d", got)
+	}
+
+	app.testRange(file, 10, 10)
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+	waitForParts(t, app, objID, 2, 3)
+	onlyIfCached("bytes=12-18", contents[12:19], http.StatusPartialContent)
+	onlyIfCached("bytes=12-28", gatewayTimeout, http.StatusGatewayTimeout)
+	onlyIfCached("", gatewayTimeout, http.StatusGatewayTimeout)
+	if got := atomic.LoadUint32(&upstreamRequests); got != 1 {
+		t.Errorf("expected no upstream requests for the missing parts but got %d", got-1)
+	}
+
+	app.testFullRequest(file)
+	waitForParts(t, app, objID, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	onlyIfCached("", contents, http.StatusOK)
+
+	var requests = atomic.LoadUint32(&upstreamRequests)
+	makeStale(t, app, objID)
+	onlyIfCached("", gatewayTimeout, http.StatusGatewayTimeout)
+	if got := atomic.LoadUint32(&upstreamRequests); got != requests {
+		t.Errorf("expected the stale object not to be revalidated but there were %d upstream requests", got-requests)
+	}
+}
+
+func TestRequestCacheControlDirectives(t *testing.T) {
+	t.Parallel()
+	var file = "directives"
+	var contents = testutils.GenerateMeAString(22, 50)
+	var upstreamRequests uint32
+	app := newTestAppFromMap(t, map[string]string{file: contents})
+	defer app.cleanup()
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&upstreamRequests, 1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		http.ServeContent(w, r, file, time.Time{}, strings.NewReader(contents))
+	}))
+	var request = func(cacheControl string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "http://example.com/"+file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Cache-Control", cacheControl)
+		var rec = httptest.NewRecorder()
+		app.cacheHandler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Body.String() != contents {
+			t.Errorf("expected the whole object for '%s' but got %d and '%s'",
+				cacheControl, rec.Code, rec.Body.String())
+		}
+		return rec
+	}
+	var expectUpstreamRequests = func(expected uint32, cacheControl string) {
+		if got := atomic.LoadUint32(&upstreamRequests); got != expected {
+			t.Errorf("expected %d upstream requests after '%s' but got %d", expected, cacheControl, got)
+		}
+	}
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+
+	request("")
+	waitForParts(t, app, objID, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	request("max-age=600, min-fresh=600")
+	expectUpstreamRequests(1, "max-age=600, min-fresh=600")
+	request("min-fresh=7200")
+	expectUpstreamRequests(2, "min-fresh=7200")
+
+	waitForParts(t, app, objID, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	makeStale(t, app, objID)
+	if rec := request("max-stale=120"); rec.Header().Get("Warning") == "" {
+		t.Error("expected a warning for the stale response")
+	}
+	request("max-stale")
+	expectUpstreamRequests(2, "max-stale")
+	request("max-stale=30")
+	expectUpstreamRequests(3, "max-stale=30")
+}
@@ -19,6 +19,8 @@ type partWriter struct {
 	length     uint64
 	objSize    uint64
 	buf        []byte
+	inflight   *inflightParts
+	owned      map[uint32]*inflightPart
 }
 
 // PartWriter creates a io.WriteCloser that statefully writes sequential parts of
@@ -74,6 +76,7 @@ func (pw *partWriter) Write(data []byte) (int, error) {
 				}
 				dataPos += toWrite
 				pw.currentPos += toWrite
+				pw.updateInflight()
 			}
 		}
 		remainingData = dataLen - dataPos
@@ -91,18 +94,47 @@ func (pw *partWriter) flushBuffer() error {
 
 	if !pw.cz.Algorithm.ShouldKeep(idx) {
 		pw.buf = nil
+		pw.removeInflight(idx)
 		return nil
 	} else if err := pw.cz.Storage.SavePart(idx, bytes.NewBuffer(pw.buf)); err != nil {
 		return err
 	}
 	pw.buf = nil
+	// the part is served from memory until the cache algorithm knows about
+	// it, so that concurrent requests do not download it again
+	defer pw.removeInflight(idx)
 	if err := pw.cz.Algorithm.AddObject(idx); err != nil && err != types.ErrAlreadyInCache {
 		return err
 	}
 	return nil
 }
 
+// updateInflight passes the buffered data to the readers of the in-flight
+// part, if there are any.
+func (pw *partWriter) updateInflight() {
+	part := uint32((pw.currentPos - uint64(len(pw.buf))) / pw.partSize)
+	inflight, ok := pw.owned[part]
+	if !ok {
+		return
+	}
+	inflight.update(pw.buf)
+	if uint64(len(pw.buf)) == pw.partSize || pw.currentPos == pw.objSize {
+		inflight.finish(nil)
+	}
+}
+
+// removeInflight stops serving the part from memory once it is in the storage.
+func (pw *partWriter) removeInflight(idx *types.ObjectIndex) {
+	if inflight, ok := pw.owned[idx.Part]; ok {
+		pw.inflight.remove(idx, inflight)
+		delete(pw.owned, idx.Part)
+	}
+}
+
 func (pw *partWriter) Close() error {
+	if pw.inflight != nil {
+		defer pw.inflight.abort(pw.objID, pw.owned)
+	}
 	if pw.currentPos-pw.startPos != pw.length {
 		return errors.WithStack(&partWriterShortWrite{
 			expected: pw.length,
@@ -57,6 +57,51 @@ func TestPartWriter(t *testing.T) {
 	}
 }
 
+func TestPartsAreInflightUntilAddedToTheAlgorithm(t *testing.T) {
+	t.Parallel()
+	const partSize = 5
+	var inflight = newInflightParts()
+	var added int
+	cz := &types.CacheZone{
+		ID:      "TestCZ",
+		Storage: mock.NewStorage(partSize),
+		Algorithm: mock.NewCacheAlgorithm(&mock.CacheAlgorithmRepliers{
+			ShouldKeep: func(*types.ObjectIndex) bool { return true },
+			AddObject: func(idx *types.ObjectIndex) error {
+				added++
+				if _, reserved := inflight.reserve(idx); reserved {
+					t.Errorf("part %s was not in flight while it was added to the cache algorithm", idx)
+				}
+				return nil
+			},
+		}),
+	}
+
+	if err := cz.Storage.SaveMetadata(oMeta); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	pw := PartWriter(cz, oid, httputils.ContentRange{Start: 0, Length: inputSize, ObjSize: inputSize}).(*partWriter)
+	pw.inflight = inflight
+	pw.owned = make(map[uint32]*inflightPart)
+	for part := uint32(0); part*partSize < uint32(inputSize); part++ {
+		pw.owned[part], _ = inflight.reserve(&types.ObjectIndex{ObjID: oid, Part: part})
+	}
+	if _, err := pw.Write([]byte(input)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if expected := int((inputSize + partSize - 1) / partSize); added != expected {
+		t.Errorf("expected %d parts to be added to the cache algorithm but there were %d", expected, added)
+	}
+	if len(inflight.parts) != 0 {
+		t.Errorf("expected no parts to be in flight after the writing but there are %d", len(inflight.parts))
+	}
+}
+
 func TestAlgorithmCompliance(t *testing.T) {
 	t.Parallel()
 	partSize := uint64(5)
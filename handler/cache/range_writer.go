@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"io"
+)
+
+// rangeWriter passes only the bytes from start to end (inclusive) of the data
+// written to it to the underlying writer and closes it after that. The rest of
+// the data is accepted and dropped so that it can still be cached by the
+// other writers that the response is written to.
+type rangeWriter struct {
+	w               io.WriteCloser
+	start, end, pos uint64
+	closed          bool
+	// whether the errors from the underlying writer should not be returned
+	ignoreErrors bool
+}
+
+func newRangeWriter(w io.WriteCloser, start, end uint64) *rangeWriter {
+	return &rangeWriter{w: w, start: start, end: end}
+}
+
+func (rw *rangeWriter) Write(p []byte) (int, error) {
+	var length = uint64(len(p))
+	if !rw.closed && rw.pos+length > rw.start {
+		from := umax(rw.start, rw.pos) - rw.pos
+		to := umin(rw.end+1, rw.pos+length) - rw.pos
+		if n, err := rw.w.Write(p[from:to]); err != nil {
+			rw.closed = true
+			if !rw.ignoreErrors {
+				return int(from) + n, err
+			}
+		}
+		if rw.pos+length > rw.end && !rw.closed {
+			rw.closed = true
+			_ = rw.w.Close()
+		}
+	}
+	rw.pos += length
+	return len(p), nil
+}
+
+// Close closes the underlying writer. Pipes are closed with an error if the
+// range was not written completely.
+func (rw *rangeWriter) Close() error {
+	if pw, ok := rw.w.(*io.PipeWriter); ok && rw.pos <= rw.end {
+		return pw.CloseWithError(io.ErrUnexpectedEOF)
+	}
+	return rw.w.Close()
+}
+
+func umax(l, r uint64) uint64 {
+	if l < r {
+		return r
+	}
+	return l
+}
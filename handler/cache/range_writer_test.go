@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ironsmile/nedomi/utils"
+)
+
+func TestRangeWriter(t *testing.T) {
+	t.Parallel()
+	var data = "0123456789abcdefghij"
+	for _, chunkSize := range []int{1, 3, 7, 20} {
+		r, w := io.Pipe()
+		rw := newRangeWriter(w, 5, 12)
+		go func() {
+			for i := 0; i < len(data); i += chunkSize {
+				n, err := rw.Write([]byte(data[i:min(i+chunkSize, len(data))]))
+				if err != nil || n != min(chunkSize, len(data)-i) {
+					t.Errorf("unexpected write result %d, %v", n, err)
+				}
+			}
+			_ = rw.Close()
+		}()
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("unexpected error %s with chunks of %d", err, chunkSize)
+		}
+		if string(got) != data[5:13] {
+			t.Errorf("expected '%s' but got '%s' with chunks of %d", data[5:13], got, chunkSize)
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("the client is gone")
+}
+
+func TestRangeWriterErrors(t *testing.T) {
+	t.Parallel()
+	rw := newRangeWriter(utils.NopCloser(failingWriter{}), 2, 5)
+	if n, err := rw.Write([]byte("0123456789")); err == nil || n != 2 {
+		t.Errorf("expected the error of the writer after 2 bytes but got %d, %v", n, err)
+	}
+
+	rw = newRangeWriter(utils.NopCloser(failingWriter{}), 2, 5)
+	rw.ignoreErrors = true
+	if n, err := rw.Write([]byte("0123456789")); err != nil || n != 10 {
+		t.Errorf("expected the error of the writer to be ignored but got %d, %v", n, err)
+	}
+
+	var client = new(bytes.Buffer)
+	rw = newRangeWriter(utils.NopCloser(client), 2, 5)
+	for _, chunk := range []string{"01", "2345", "6789"} {
+		if _, err := rw.Write([]byte(chunk)); err != nil {
+			t.Errorf("unexpected error %s", err)
+		}
+	}
+	if err := rw.Close(); err != nil {
+		t.Errorf("unexpected error on close %s", err)
+	}
+	if client.String() != "2345" {
+		t.Errorf("expected '2345' but got '%s'", client.String())
+	}
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/ironsmile/nedomi/contexts"
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils"
+)
+
+// readAhead fetches the parts of the object following the supplied one from
+// the upstream in the background, so that they are already cached when the
+// client requests them. The read-aheads of each cache zone are limited like
+// its refreshes and there is at most one for each object at a time.
+func (h *reqHandler) readAhead(last uint32) {
+	if h.settings.ReadAhead == 0 || h.obj.Size == 0 {
+		return
+	}
+	partSize := h.Cache.Storage.PartSize()
+	if uint64(last+1)*partSize >= h.obj.Size {
+		return
+	}
+
+	// the read-ahead should not be canceled when the client request is done
+	aheadh := *h
+	aheadh.req = h.req.WithContext(contexts.Detach(h.req.Context()))
+	started := h.Cache.ReadAheads.Refresh(h.objID.Hash(), func() {
+		utils.SafeExecute(
+			func() { aheadh.readAheadParts(last) },
+			func(err error) {
+				h.Logger.Errorf("[%s] Panic while reading ahead parts of %s: %s", h.reqID, h.objID, err)
+			},
+		)
+	})
+	if !started {
+		h.Logger.Debugf("[%s] Not reading ahead %s, it is already being read ahead or too many objects are",
+			h.reqID, h.objID)
+	}
+}
+
+// readAheadParts downloads the first consecutive parts after the supplied one
+// that are neither cached nor being downloaded by someone else.
+func (h *reqHandler) readAheadParts(last uint32) {
+	partSize := h.Cache.Storage.PartSize()
+	partsCount := uint32((h.obj.Size + partSize - 1) / partSize)
+	reserved := make(map[uint32]*inflightPart)
+	first, to := last+1, last+1
+	for part := last + 1; part <= last+h.settings.ReadAhead && part < partsCount; part++ {
+		idx := &types.ObjectIndex{ObjID: h.objID, Part: part}
+		if !h.Cache.Algorithm.Lookup(idx) {
+			if inflight, ok := h.inflight.reserve(idx); ok {
+				reserved[part] = inflight
+				to = part + 1
+				continue
+			}
+		}
+		if len(reserved) > 0 {
+			break
+		}
+		first, to = part+1, part+1
+	}
+	if len(reserved) == 0 {
+		return
+	}
+
+	fromByte := uint64(first) * partSize
+	toByte := umin(h.obj.Size, uint64(to)*partSize) - 1
+	h.Logger.Debugf("[%s] Reading ahead parts [%d-%d] of %s...", h.reqID, first, to-1, h.objID)
+
+	r := h.getUpstreamReader(fromByte, toByte, reserved)
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		h.Logger.Debugf("[%s] Error while reading ahead parts of %s: %s", h.reqID, h.objID, err)
+	}
+	if err := r.Close(); err != nil {
+		h.Logger.Debugf("[%s] Error while closing the read-ahead of %s: %s", h.reqID, h.objID, err)
+	}
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/storage"
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestReadAhead(t *testing.T) {
+	t.Parallel()
+	var file = "read-ahead"
+	var fsmap = map[string]string{file: testutils.GenerateMeAString(17, 53)}
+	var lock sync.Mutex
+	var upstreamRanges = make(map[string]bool)
+	var fs = fsMapHandler(fsmap)
+	app := newTestAppFromMap(t, fsmap)
+	defer app.cleanup()
+	app.cacheHandler.settings.ReadAhead = 3
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			lock.Lock()
+			upstreamRanges[r.Header.Get("Range")] = true
+			lock.Unlock()
+		}
+		fs(w, r)
+	}))
+
+	// get only the metadata in the storage
+	req, err := http.NewRequest("HEAD", "http://example.com/"+file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.testRequest(req, "", http.StatusOK)
+
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+	app.testRange(file, 0, 5)
+	waitForParts(t, app, objID, 0, 1, 2, 3)
+	waitForReadAheads(t, app)
+	// part 3 is cached already, so only 4 and 5 have to be read ahead
+	app.testRange(file, 5, 10)
+	waitForParts(t, app, objID, 4, 5)
+	waitForReadAheads(t, app)
+	app.testRange(file, 15, 5)
+	waitForParts(t, app, objID, 6)
+	waitForReadAheads(t, app)
+	// there is nothing to be read ahead after the last part
+	app.testRange(file, 50, 3)
+
+	lock.Lock()
+	defer lock.Unlock()
+	var expected = []string{"bytes=0-4", "bytes=5-19", "bytes=20-29", "bytes=30-34", "bytes=50-52"}
+	if len(upstreamRanges) != len(expected) {
+		t.Errorf("expected upstream requests for %v but they were %v", expected, upstreamRanges)
+	}
+	for _, rng := range expected {
+		if !upstreamRanges[rng] {
+			t.Errorf("expected an upstream request for %s but they were %v", rng, upstreamRanges)
+		}
+	}
+}
+
+func TestReadAheadIsLimited(t *testing.T) {
+	t.Parallel()
+	var file = "read-ahead-limited"
+	var fsmap = map[string]string{file: testutils.GenerateMeAString(18, 53)}
+	var fs = fsMapHandler(fsmap)
+	var requests uint32
+	app := newTestAppFromMap(t, fsmap)
+	defer app.cleanup()
+	app.cacheHandler.settings.ReadAhead = 3
+	app.cacheHandler.Cache.ReadAheads = storage.NewRefresher(0)
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddUint32(&requests, 1)
+		}
+		fs(w, r)
+	}))
+
+	app.testRange(file, 0, 5)
+	app.testRange(file, 0, 5)
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadUint32(&requests); got != 1 {
+		t.Errorf("expected no read-ahead over the limit but there were %d upstream requests", got)
+	}
+}
+
+// waitForReadAheads waits for the parts which are read ahead in the cache
+// zone of the app to be downloaded.
+func waitForReadAheads(t *testing.T, app *testApp) {
+	for i := 0; i < 100; i++ {
+		if app.cacheHandler.Cache.ReadAheads.Running() == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the read-aheads were not done in time")
+}
+
+func waitForParts(t *testing.T, app *testApp, objID *types.ObjectID, parts ...uint32) {
+	for i := 0; i < 100; i++ {
+		var cached = 0
+		for _, part := range parts {
+			if app.cacheHandler.Cache.Algorithm.Lookup(&types.ObjectIndex{ObjID: objID, Part: part}) {
+				cached++
+			}
+		}
+		if cached == len(parts) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("parts %v of %s were not cached in time", parts, objID)
+}
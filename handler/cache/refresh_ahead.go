@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ironsmile/nedomi/contexts"
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils"
+	"github.com/ironsmile/nedomi/utils/cacheutils"
+	"github.com/ironsmile/nedomi/utils/httputils"
+)
+
+var refreshIDSuffix = []byte("->refresh")
+
+// refreshAheadJob returns the job which refreshes the cached object shortly
+// before it expires, or nil if the cache zone does not refresh objects ahead.
+// Objects with validators are revalidated and the rest are downloaded again.
+// Only what is needed for the upstream request is kept until then, instead
+// of the whole client request and its context.
+func (h *reqHandler) refreshAheadJob() func() {
+	if h.Cache.RefreshAhead <= 0 {
+		return nil
+	}
+
+	req := h.getNormalizedRequest()
+	req.Method = "GET"
+	req.Header.Del("Range")
+	for _, header := range conditionalHeaders {
+		req.Header.Del(header)
+	}
+	reqID := h.reqID
+	if !bytes.HasSuffix(reqID, refreshIDSuffix) {
+		// the refreshed objects are scheduled to be refreshed again
+		_, reqID = contexts.AppendToRequestID(h.req.Context(), refreshIDSuffix)
+	}
+	return h.newRefreshJob(req, h.objID, reqID)
+}
+
+// storedRefreshJob returns the job which refreshes the object from the
+// request that is stored in its metadata. It is used for the objects which
+// are loaded from the storage on startup, before any requests for them.
+func (c *CachingProxy) storedRefreshJob(obj *types.ObjectMetadata) func() {
+	if c.Cache.RefreshAhead <= 0 {
+		return nil
+	}
+	u, err := url.Parse(obj.RequestURL)
+	if err != nil {
+		c.Logger.Errorf("Could not parse the stored request URL of %s: %s", obj.ID, err)
+		return nil
+	}
+
+	host := u.Host
+	u.Scheme, u.Host = "", ""
+	req := &http.Request{
+		Method:     "GET",
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       host,
+	}
+	httputils.CopyHeaders(obj.RequestHeaders, req.Header)
+	reqID := types.RequestID(obj.ID.StrHash() + string(refreshIDSuffix))
+	h := &reqHandler{CachingProxy: c}
+	return h.newRefreshJob(req, obj.ID, reqID)
+}
+
+// newRefreshJob returns the job which refreshes the object with the
+// normalized upstream request.
+func (h *reqHandler) newRefreshJob(req *http.Request, objID *types.ObjectID, reqID types.RequestID) func() {
+	req = req.WithContext(contexts.NewIDContext(context.Background(), reqID))
+	proxy := h.CachingProxy
+
+	return func() {
+		subreq := *req
+		subh := &reqHandler{
+			CachingProxy: proxy,
+			req:          &subreq,
+			objID:        objID,
+			reqID:        reqID,
+			resp: httputils.NewFlexibleResponseWriter(func(rw *httputils.FlexibleResponseWriter) {
+				rw.BodyWriter = utils.NopCloser(ioutil.Discard)
+			}),
+		}
+		utils.SafeExecute(subh.refreshAhead, func(err error) {
+			subh.Logger.Errorf("[%s] Panic while refreshing %s ahead: %s", reqID, objID, err)
+		})
+	}
+}
+
+// refreshRequest returns the URL and the headers of the normalized request
+// which are stored in the metadata of the object, so that it can be
+// refreshed after it is loaded from the storage. Only the headers which
+// select the object are kept. Nothing is returned for the objects which
+// vary on credentials, as they should not be stored.
+func (h *reqHandler) refreshRequest(respHeaders http.Header) (string, http.Header) {
+	vary := cacheutils.ParseVary(respHeaders)
+	for _, name := range vary {
+		if name == "Authorization" || name == "Cookie" {
+			return "", nil
+		}
+	}
+
+	req := h.getNormalizedRequest()
+	u := url.URL{Host: req.Host, Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: req.URL.RawQuery}
+	headers := make(http.Header)
+	keep := func(name string) {
+		name = http.CanonicalHeaderKey(name)
+		if values, ok := req.Header[name]; ok {
+			headers[name] = append([]string(nil), values...)
+		}
+	}
+	for _, name := range vary {
+		keep(name)
+	}
+	if t := h.CacheKeyTemplate; t != nil {
+		for _, name := range t.Headers {
+			keep(name)
+		}
+		var cookies []string
+		for _, name := range t.Cookies {
+			if cookie, err := req.Cookie(name); err == nil {
+				cookies = append(cookies, cookie.String())
+			}
+		}
+		if len(cookies) > 0 && headers.Get("Cookie") == "" {
+			headers.Set("Cookie", strings.Join(cookies, "; "))
+		}
+	}
+	if len(headers) == 0 {
+		headers = nil
+	}
+	return u.String(), headers
+}
+
+// refreshAhead refreshes the cached object before it expires. Only the
+// metadata is requested for objects which can be revalidated, while the
+// others are requested whole, so that all of their parts are replaced.
+func (h *reqHandler) refreshAhead() {
+	obj, err := h.Cache.Storage.GetMetadata(h.objID)
+	if err != nil {
+		h.Logger.Debugf("[%s] Could not get the metadata of %s for refreshing it: %s", h.reqID, h.objID, err)
+		return
+	}
+	h.obj = obj
+	if cacheutils.HasValidators(obj.Headers) {
+		h.req.Method = "HEAD"
+	}
+	h.Logger.Debugf("[%s] Refreshing %s before it expires...", h.reqID, h.objID)
+	h.revalidateMetadata()
+}
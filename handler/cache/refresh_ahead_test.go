@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+// newRefreshAheadTestApp returns a test app which refreshes the objects with
+// the supplied popularity a second before they expire. The upstream responds
+// with objects which expire in 2 seconds and counts the conditional and the
+// full requests for them.
+func newRefreshAheadTestApp(t *testing.T, file, contents string, popularity float64,
+	validators bool) (app *testApp, notModified, fullRequests *uint32) {
+	notModified, fullRequests = new(uint32), new(uint32)
+	app = newTestAppFromMap(t, map[string]string{file: contents})
+	app.cacheHandler.Cache.RefreshAhead = time.Second
+	app.cacheHandler.Cache.RefreshPopularity = popularity
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=2")
+		if validators {
+			w.Header().Set("ETag", `"v1"`)
+		}
+		if validators && r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddUint32(notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.Header.Get("Range") == "" {
+			atomic.AddUint32(fullRequests, 1)
+		}
+		http.ServeContent(w, r, file, time.Time{}, strings.NewReader(contents))
+	}))
+	return app, notModified, fullRequests
+}
+
+// waitForCount waits for the counter to reach at least the expected value.
+func waitForCount(t *testing.T, counter *uint32, expected uint32, what string) {
+	for i := 0; i < 400; i++ {
+		if atomic.LoadUint32(counter) >= expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected at least %d %s but got %d", expected, what, atomic.LoadUint32(counter))
+}
+
+// expectCachedAfter checks that the object is still fresh and cached after
+// the moment at which it expired without being refreshed.
+func expectCachedAfter(t *testing.T, app *testApp, objID *types.ObjectID, expiresAt int64, parts int) {
+	time.Sleep(time.Unix(expiresAt, 0).Add(100 * time.Millisecond).Sub(time.Now()))
+	obj, err := app.cacheHandler.Cache.Storage.GetMetadata(objID)
+	if err != nil {
+		t.Fatalf("expected the refreshed object to be cached but got %s", err)
+	}
+	if !(obj.ExpiresAt > expiresAt) {
+		t.Errorf("expected the refreshed object to expire after %d but it expires at %d", expiresAt, obj.ExpiresAt)
+	}
+	if available, err := app.cacheHandler.Cache.Storage.GetAvailableParts(objID); err != nil || len(available) != parts {
+		t.Errorf("expected %d parts of the refreshed object but got %d (%v)", parts, len(available), err)
+	}
+}
+
+func TestPopularObjectsAreRevalidatedAhead(t *testing.T) {
+	t.Parallel()
+	var file = "refreshed"
+	var contents = testutils.GenerateMeAString(23, 50)
+	app, notModified, fullRequests := newRefreshAheadTestApp(t, file, contents, 0.5, true)
+	defer app.cleanup()
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+
+	app.testFullRequest(file)
+	waitForParts(t, app, objID, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	obj, err := app.cacheHandler.Cache.Storage.GetMetadata(objID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the parts are promoted when they are served from the cache
+	app.testFullRequest(file)
+
+	waitForCount(t, notModified, 1, "conditional requests")
+	expectCachedAfter(t, app, objID, obj.ExpiresAt, 10)
+	if got := atomic.LoadUint32(fullRequests); got != 1 {
+		t.Errorf("expected only the initial full request to the upstream but got %d", got)
+	}
+	if got := app.cacheHandler.Cache.Counters.RefreshedObjects(); got < 1 {
+		t.Errorf("expected the refreshed object to be counted but got %d", got)
+	}
+	app.testFullRequest(file)
+}
+
+func TestPopularObjectsWithoutValidatorsAreRefetchedAhead(t *testing.T) {
+	t.Parallel()
+	var file = "refetched"
+	var contents = testutils.GenerateMeAString(24, 50)
+	app, _, fullRequests := newRefreshAheadTestApp(t, file, contents, 0.5, false)
+	defer app.cleanup()
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+
+	app.testFullRequest(file)
+	waitForParts(t, app, objID, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	obj, err := app.cacheHandler.Cache.Storage.GetMetadata(objID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.testFullRequest(file)
+
+	waitForCount(t, fullRequests, 2, "full requests")
+	waitForParts(t, app, objID, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	expectCachedAfter(t, app, objID, obj.ExpiresAt, 10)
+}
+
+func TestUnpopularObjectsAreNotRefreshedAhead(t *testing.T) {
+	t.Parallel()
+	var file = "unpopular"
+	var contents = testutils.GenerateMeAString(25, 50)
+	app, notModified, _ := newRefreshAheadTestApp(t, file, contents, 0.75, true)
+	defer app.cleanup()
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+
+	app.testFullRequest(file)
+	waitForParts(t, app, objID, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	obj, err := app.cacheHandler.Cache.Storage.GetMetadata(objID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.testFullRequest(file)
+
+	time.Sleep(time.Unix(obj.ExpiresAt, 0).Add(100 * time.Millisecond).Sub(time.Now()))
+	if got := atomic.LoadUint32(notModified); got != 0 {
+		t.Errorf("expected the unpopular object not to be revalidated but there were %d conditional requests", got)
+	}
+	if got := app.cacheHandler.Cache.Counters.RefreshedObjects(); got != 0 {
+		t.Errorf("expected no refreshed objects but got %d", got)
+	}
+}
+
+func TestLoadedObjectsAreRefreshedFromTheirStoredRequest(t *testing.T) {
+	t.Parallel()
+	var file = "loaded"
+	var contents = testutils.GenerateMeAString(26, 50)
+	app, notModified, fullRequests := newRefreshAheadTestApp(t, file, contents, 0.5, true)
+	defer app.cleanup()
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+
+	app.testFullRequest(file)
+	waitForParts(t, app, objID, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	obj, err := app.cacheHandler.Cache.Storage.GetMetadata(objID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.RequestURL == "" {
+		t.Fatal("expected the request of the object to be stored in its metadata")
+	}
+
+	// the job is made from the stored metadata as if it was loaded on startup
+	job := app.cacheHandler.Cache.RefreshJobs.Job(obj)
+	if job == nil {
+		t.Fatal("expected a refresh job for the stored object")
+	}
+	time.Sleep(time.Second)
+	job()
+
+	if got := atomic.LoadUint32(notModified); got != 1 {
+		t.Errorf("expected the loaded object to be revalidated once but there were %d conditional requests", got)
+	}
+	expectCachedAfter(t, app, objID, obj.ExpiresAt, 10)
+	if got := atomic.LoadUint32(fullRequests); got != 1 {
+		t.Errorf("expected only the initial full request to the upstream but got %d", got)
+	}
+}
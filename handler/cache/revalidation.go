@@ -0,0 +1,357 @@
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ironsmile/nedomi/contexts"
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils"
+	"github.com/ironsmile/nedomi/utils/cacheutils"
+	"github.com/ironsmile/nedomi/utils/httputils"
+)
+
+// conditionalHeaders are the client request headers which are replaced by the
+// validators of the cached object when it is revalidated with the upstream.
+var conditionalHeaders = []string{
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+	"If-Range",
+}
+
+// revalidate makes a conditional request to the upstream for the stale cached
+// object. If the object has not been modified, its metadata is refreshed and
+// the request is served from the cache. If the upstream fails and the object
+// can still be served on errors, it is served from the cache as well.
+// Otherwise the object is discarded and the upstream response is proxied (and
+// cached) as usual.
+func (h *reqHandler) revalidate() {
+	var conditional = cacheutils.HasValidators(h.obj.Headers)
+	var req *http.Request
+	if conditional {
+		req = h.getConditionalRequest()
+	} else {
+		h.Logger.Debugf("[%s] Stale object has no validators, proxying...", h.reqID)
+		req = h.getNormalizedRequest()
+	}
+
+	var notModified http.Header
+	var upstreamFailed bool
+	h.proxy(req, func(rw *httputils.FlexibleResponseWriter) {
+		if conditional && rw.Code == http.StatusNotModified {
+			notModified = rw.Headers
+			rw.BodyWriter = utils.NopCloser(ioutil.Discard)
+			return
+		}
+		if rw.Code >= http.StatusInternalServerError && utils.CanServeOnError(h.obj) {
+			upstreamFailed = true
+			rw.BodyWriter = utils.NopCloser(ioutil.Discard)
+			return
+		}
+		h.Logger.Debugf("[%s] Upstream responded with %d to the revalidation, discarding the stale object...",
+			h.reqID, rw.Code)
+		h.discardObject()
+		h.getResponseHook()(rw)
+	})
+
+	if notModified != nil {
+		h.Logger.Debugf("[%s] Stale object was not modified, serving it from cache...", h.reqID)
+		h.refreshMetadata(notModified)
+		h.respondFromCache()
+	} else if upstreamFailed {
+		h.Logger.Logf("[%s] Upstream failed to revalidate %s, serving the stale object...",
+			h.reqID, h.objID)
+		// https://tools.ietf.org/html/rfc7234#section-5.5.2
+		h.resp.Header().Set("Warning", `111 - "Revalidation Failed"`)
+		h.respondFromCache()
+	}
+}
+
+// getConditionalRequest returns a normalized upstream request which is
+// conditional on the validators of the cached object.
+func (h *reqHandler) getConditionalRequest() *http.Request {
+	req := h.getNormalizedRequest()
+	for _, header := range conditionalHeaders {
+		req.Header.Del(header)
+	}
+	if etag := h.obj.Headers.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := h.obj.Headers.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	return req
+}
+
+// revalidateInBackground starts a revalidation of the stale cached object with
+// the upstream by the refresher of the cache zone, unless there is already one
+// in progress for it, it is known to be modified or too many objects are being
+// refreshed. Only the metadata is requested - if the object has been modified, it is replaced and
+// its new parts are downloaded when they are requested.
+func (h *reqHandler) revalidateInBackground() {
+	if h.objectLocks.replacing(h.objID.Hash()) {
+		h.Logger.Debugf("[%s] Object %s was modified and waits to be replaced", h.reqID, h.objID)
+		return
+	}
+	subh := *h
+	obj := *h.obj
+	obj.Headers = make(http.Header)
+	httputils.CopyHeaders(h.obj.Headers, obj.Headers)
+	subh.obj = &obj
+	subh.req = h.getNormalizedRequest()
+	subh.req.Method = "HEAD"
+	subh.req.Header.Del("Range")
+	var ctx context.Context
+	ctx, subh.reqID = contexts.AppendToRequestID(contexts.Detach(h.req.Context()), []byte("->swr"))
+	subh.req = subh.req.WithContext(ctx)
+	subh.resp = httputils.NewFlexibleResponseWriter(func(rw *httputils.FlexibleResponseWriter) {
+		rw.BodyWriter = utils.NopCloser(ioutil.Discard)
+	})
+
+	started := h.Cache.Refresher.Refresh(h.objID.Hash(), func() {
+		utils.SafeExecute(subh.revalidateMetadata, func(err error) {
+			h.Logger.Errorf("[%s] Panic while revalidating %s in the background: %s",
+				subh.reqID, h.objID, err)
+		})
+	})
+	if !started {
+		h.Logger.Debugf("[%s] Object %s is already being revalidated or too many objects are",
+			h.reqID, h.objID)
+	}
+}
+
+// revalidateMetadata makes a conditional HEAD request to the upstream for the
+// stale object. If it has not been modified, its metadata is refreshed.
+// Otherwise the object is replaced by the new one right away if it is not
+// being read by the requests which are served from the cache. If it is, only
+// the metadata of the new one is kept and the object is replaced after them.
+func (h *reqHandler) revalidateMetadata() {
+	h.Logger.Debugf("[%s] Revalidating %s in the background...", h.reqID, h.objID)
+	var notModified, modified http.Header
+	var replacing bool
+	defer func() {
+		if replacing {
+			h.objectLocks.replaced(h.objID.Hash())
+		}
+	}()
+	h.proxy(h.getConditionalRequest(), func(rw *httputils.FlexibleResponseWriter) {
+		switch rw.Code {
+		case http.StatusNotModified:
+			notModified = rw.Headers
+			rw.BodyWriter = utils.NopCloser(ioutil.Discard)
+		case http.StatusOK:
+			if replacing = h.objectLocks.tryReplace(h.objID.Hash()); replacing {
+				h.Logger.Debugf("[%s] Object %s was modified, replacing it...", h.reqID, h.objID)
+				h.discardObject()
+				h.getResponseHook()(rw)
+				return
+			}
+			modified = rw.Headers
+			rw.BodyWriter = utils.NopCloser(ioutil.Discard)
+		default:
+			h.Logger.Logf("[%s] Upstream responded with %d to the background revalidation of %s",
+				h.reqID, rw.Code, h.objID)
+			rw.BodyWriter = utils.NopCloser(ioutil.Discard)
+		}
+	})
+
+	if notModified != nil {
+		h.Logger.Debugf("[%s] Object %s was not modified", h.reqID, h.objID)
+		h.refreshMetadata(notModified)
+	} else if modified != nil {
+		h.Logger.Debugf("[%s] Object %s was modified while being read, replacing it after that...",
+			h.reqID, h.objID)
+		h.objectLocks.replace(h.objID.Hash(), func() {
+			utils.SafeExecute(func() { h.replaceObject(modified) }, func(err error) {
+				h.Logger.Errorf("[%s] Panic while replacing %s: %s", h.reqID, h.objID, err)
+			})
+		})
+	}
+}
+
+// replaceObject discards the object and caches the metadata of the modified
+// one from the headers of the upstream response to the revalidation.
+func (h *reqHandler) replaceObject(headers http.Header) {
+	h.discardObject()
+	// the body of the response was not kept
+	h.req.Method = "HEAD"
+	rw := httputils.NewFlexibleResponseWriter(h.getResponseHook())
+	rw.Headers = headers
+	rw.WriteHeader(http.StatusOK)
+	if err := rw.Close(); err != nil {
+		h.Logger.Errorf("[%s] Error while saving the metadata of the modified %s: %s",
+			h.reqID, h.objID, err)
+	}
+}
+
+// refreshMetadata updates the metadata of the revalidated object with the
+// headers from the upstream's 304 response and reschedules its expiration.
+func (h *reqHandler) refreshMetadata(headers http.Header) {
+	//!TODO: maybe call cached time.Now. See the comment in utils.IsMetadataFresh
+	now := time.Now()
+	expiresIn := cacheutils.ResponseExpiresIn(headers, h.CacheDefaultDuration)
+
+	httputils.CopyHeadersWithout(headers, h.obj.Headers, metadataHeadersToFilter...)
+	h.obj.ResponseTimestamp = now.Unix()
+	h.obj.ExpiresAt = now.Add(expiresIn).Unix()
+	h.obj.StaleWhileRevalidate = h.staleWhileRevalidate(headers)
+	h.obj.StaleIfError = h.staleIfError(headers)
+	if tags := cacheutils.ResponseTags(headers); len(tags) > 0 {
+		h.obj.Tags = tags
+	}
+	if expiresIn <= 0 {
+		h.Logger.Debugf("[%s] Revalidated object expires in the past: %s", h.reqID, expiresIn)
+		return
+	}
+
+	if err := h.Cache.Storage.SaveMetadata(h.obj); err != nil {
+		h.Logger.Errorf("[%s] Could not save the refreshed metadata for %s: %s",
+			h.reqID, h.objID, err)
+		return
+	}
+	h.Cache.Tags.Set(h.objID, h.obj.Tags...)
+
+	h.scheduleExpiration(expiresIn)
+}
+
+// staleWhileRevalidate returns for how many seconds after its expiration the
+// object with the supplied upstream headers can be served while revalidating.
+func (h *reqHandler) staleWhileRevalidate(headers http.Header) int64 {
+	return int64(cacheutils.ResponseStaleWhileRevalidate(headers, h.StaleWhileRevalidate) / time.Second)
+}
+
+// staleIfError returns for how many seconds after its expiration the object
+// with the supplied upstream headers can be served when the upstream fails.
+func (h *reqHandler) staleIfError(headers http.Header) int64 {
+	return int64(cacheutils.ResponseStaleIfError(headers, h.StaleIfError) / time.Second)
+}
+
+// objectLocks track the requests which are served from the cache for each
+// object, so that it is not replaced by a background revalidation in the
+// middle of their responses. The replacement of an object which is being read
+// is postponed until its last reader is done. The new requests for the object
+// are not served from the cache in the meantime, so that they do not wait for
+// the current ones. The entries are removed when their objects are neither
+// read nor replaced.
+type objectLocks struct {
+	sync.Mutex
+	locks map[types.ObjectIDHash]*objectLock
+}
+
+type objectLock struct {
+	readers int
+	// whether the object is being replaced or waits to be
+	replacing bool
+	// the replacement which waits for the readers
+	replace func()
+}
+
+func newObjectLocks() *objectLocks {
+	return &objectLocks{locks: make(map[types.ObjectIDHash]*objectLock)}
+}
+
+// read registers a reader of the object and returns the function which
+// unregisters it. It returns false if the object is being replaced.
+func (l *objectLocks) read(hash types.ObjectIDHash) (func(), bool) {
+	l.Lock()
+	defer l.Unlock()
+	lock := l.get(hash)
+	if lock.replacing {
+		return nil, false
+	}
+	lock.readers++
+	return func() { l.done(hash, lock) }, true
+}
+
+// replace runs the replacement of the object right away if it is not being
+// read or leaves it to its last reader otherwise. It is ignored if the object
+// is already being replaced.
+func (l *objectLocks) replace(hash types.ObjectIDHash, replace func()) {
+	l.Lock()
+	lock := l.get(hash)
+	if lock.replacing {
+		l.Unlock()
+		return
+	}
+	lock.replacing = true
+	if lock.readers > 0 {
+		lock.replace = replace
+		l.Unlock()
+		return
+	}
+	l.Unlock()
+	l.run(hash, replace)
+}
+
+// tryReplace marks the object as being replaced if it is neither read nor
+// replaced and returns whether it did. replaced should be called once the
+// object is replaced then.
+func (l *objectLocks) tryReplace(hash types.ObjectIDHash) bool {
+	l.Lock()
+	defer l.Unlock()
+	lock := l.get(hash)
+	if lock.readers > 0 || lock.replacing {
+		l.removeIfUnused(hash, lock)
+		return false
+	}
+	lock.replacing = true
+	return true
+}
+
+// replaced marks the replacement of the object as finished.
+func (l *objectLocks) replaced(hash types.ObjectIDHash) {
+	l.Lock()
+	defer l.Unlock()
+	lock := l.locks[hash]
+	lock.replacing = false
+	l.removeIfUnused(hash, lock)
+}
+
+// replacing returns whether the object is being replaced or waits to be.
+func (l *objectLocks) replacing(hash types.ObjectIDHash) bool {
+	l.Lock()
+	defer l.Unlock()
+	lock, ok := l.locks[hash]
+	return ok && lock.replacing
+}
+
+func (l *objectLocks) done(hash types.ObjectIDHash, lock *objectLock) {
+	l.Lock()
+	lock.readers--
+	replace := lock.replace
+	if lock.readers > 0 || replace == nil {
+		l.removeIfUnused(hash, lock)
+		l.Unlock()
+		return
+	}
+	lock.replace = nil
+	l.Unlock()
+	l.run(hash, replace)
+}
+
+func (l *objectLocks) run(hash types.ObjectIDHash, replace func()) {
+	defer l.replaced(hash)
+	replace()
+}
+
+// get should be called with the lock held.
+func (l *objectLocks) get(hash types.ObjectIDHash) *objectLock {
+	lock, ok := l.locks[hash]
+	if !ok {
+		lock = &objectLock{}
+		l.locks[hash] = lock
+	}
+	return lock
+}
+
+// removeIfUnused should be called with the lock held.
+func (l *objectLocks) removeIfUnused(hash types.ObjectIDHash, lock *objectLock) {
+	if lock.readers == 0 && !lock.replacing && l.locks[hash] == lock {
+		delete(l.locks, hash)
+	}
+}
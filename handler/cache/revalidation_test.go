@@ -0,0 +1,284 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func makeStale(t *testing.T, app *testApp, objID *types.ObjectID) {
+	obj, err := app.cacheHandler.Cache.Storage.GetMetadata(objID)
+	if err != nil {
+		t.Fatalf("unexpected error while getting the metadata: %s", err)
+	}
+	obj.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	if err := app.cacheHandler.Cache.Storage.SaveMetadata(obj); err != nil {
+		t.Fatalf("unexpected error while saving the metadata: %s", err)
+	}
+}
+
+func TestStaleObjectIsRevalidated(t *testing.T) {
+	t.Parallel()
+	var file = "revalidated"
+	var contents = testutils.GenerateMeAString(4, 50)
+	var fullRequests, notModified uint32
+	app := newTestAppFromMap(t, map[string]string{file: contents})
+	defer app.cleanup()
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddUint32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddUint32(&fullRequests, 1)
+		http.ServeContent(w, r, file, time.Time{}, strings.NewReader(contents))
+	}))
+
+	app.testFullRequest(file)
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+	makeStale(t, app, objID)
+
+	app.testFullRequest(file)
+	if got := atomic.LoadUint32(&notModified); got != 1 {
+		t.Errorf("expected 1 conditional request to the upstream but got %d", got)
+	}
+	if got := atomic.LoadUint32(&fullRequests); got != 1 {
+		t.Errorf("expected 1 full request to the upstream but got %d", got)
+	}
+
+	obj, err := app.cacheHandler.Cache.Storage.GetMetadata(objID)
+	if err != nil {
+		t.Fatalf("unexpected error while getting the metadata: %s", err)
+	}
+	if obj.ExpiresAt <= time.Now().Unix() {
+		t.Errorf("expected the revalidated object to be fresh but it expires at %d", obj.ExpiresAt)
+	}
+	parts, err := app.cacheHandler.Cache.Storage.GetAvailableParts(objID)
+	if err != nil {
+		t.Fatalf("unexpected error while getting the parts: %s", err)
+	}
+	if len(parts) != len(contents)/int(app.cacheHandler.Cache.PartSize) {
+		t.Errorf("expected the parts of the revalidated object to be kept but there are %d", len(parts))
+	}
+}
+
+func TestStaleObjectIsReplacedWhenModified(t *testing.T) {
+	t.Parallel()
+	var file = "modified"
+	var contents = []string{testutils.GenerateMeAString(5, 50), testutils.GenerateMeAString(6, 50)}
+	var version uint32
+	app := newTestAppFromMap(t, map[string]string{file: contents[0]})
+	defer app.cleanup()
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := atomic.LoadUint32(&version)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		http.ServeContent(w, r, file, time.Unix(int64(1000+v), 0), strings.NewReader(contents[v]))
+	}))
+
+	app.testFullRequest(file)
+	makeStale(t, app, app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file}))
+	atomic.StoreUint32(&version, 1)
+	app.fsmap[file] = contents[1]
+	app.testFullRequest(file)
+	app.testFullRequest(file)
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	t.Parallel()
+	var file = "swr"
+	var contents = testutils.GenerateMeAString(7, 50)
+	var fullRequests, notModified uint32
+	var release = make(chan struct{})
+	app := newTestAppFromMap(t, map[string]string{file: contents})
+	defer app.cleanup()
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=3600, stale-while-revalidate=600")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddUint32(&notModified, 1)
+			<-release
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddUint32(&fullRequests, 1)
+		http.ServeContent(w, r, file, time.Time{}, strings.NewReader(contents))
+	}))
+
+	app.testFullRequest(file)
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+	makeStale(t, app, objID)
+
+	// the stale object is served while the upstream is still revalidating it
+	for i := 0; i < 5; i++ {
+		app.testFullRequest(file)
+		app.testRange(file, 12, 20)
+	}
+	close(release)
+	waitForFreshMetadata(t, app, objID)
+
+	if got := atomic.LoadUint32(&notModified); got != 1 {
+		t.Errorf("expected 1 conditional request to the upstream but got %d", got)
+	}
+	if got := atomic.LoadUint32(&fullRequests); got != 1 {
+		t.Errorf("expected 1 full request to the upstream but got %d", got)
+	}
+}
+
+func TestStaleWhileRevalidateLocationDefault(t *testing.T) {
+	t.Parallel()
+	var file = "swr-default"
+	var contents = testutils.GenerateMeAString(8, 50)
+	var notModified uint32
+	app := newTestAppFromMap(t, map[string]string{file: contents})
+	defer app.cleanup()
+	app.cacheHandler.StaleWhileRevalidate = 2 * time.Minute
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		if r.Header.Get("If-Modified-Since") != "" {
+			atomic.AddUint32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		http.ServeContent(w, r, file, time.Unix(1000, 0), strings.NewReader(contents))
+	}))
+
+	app.testFullRequest(file)
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+	obj, err := app.cacheHandler.Cache.Storage.GetMetadata(objID)
+	if err != nil {
+		t.Fatalf("unexpected error while getting the metadata: %s", err)
+	}
+	if obj.StaleWhileRevalidate != 120 {
+		t.Errorf("expected the location's stale-while-revalidate default but got %d",
+			obj.StaleWhileRevalidate)
+	}
+
+	makeStale(t, app, objID)
+	app.testFullRequest(file)
+	waitForFreshMetadata(t, app, objID)
+	if got := atomic.LoadUint32(&notModified); got != 1 {
+		t.Errorf("expected 1 conditional request to the upstream but got %d", got)
+	}
+}
+
+func waitForFreshMetadata(t *testing.T, app *testApp, objID *types.ObjectID) {
+	for i := 0; i < 100; i++ {
+		obj, err := app.cacheHandler.Cache.Storage.GetMetadata(objID)
+		if err == nil && obj.ExpiresAt > time.Now().Unix() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the metadata of %s was not refreshed in time", objID)
+}
+
+// blockingWriter blocks the response of a slow client until it is unblocked.
+type blockingWriter struct {
+	*httptest.ResponseRecorder
+	started, unblock chan struct{}
+	once             sync.Once
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	<-w.unblock
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestModifiedObjectIsNotReplacedWhileBeingRead(t *testing.T) {
+	t.Parallel()
+	var file = "swr-modified"
+	var contents = testutils.GenerateMeAString(9, 50)
+	var modified = testutils.GenerateMeAString(10, 50)
+	var revalidations uint32
+	var slow = &blockingWriter{
+		ResponseRecorder: httptest.NewRecorder(),
+		started:          make(chan struct{}),
+		unblock:          make(chan struct{}),
+	}
+	app := newTestAppFromMap(t, map[string]string{file: contents})
+	defer app.cleanup()
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600, stale-while-revalidate=600")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			// the object is modified while the slow client reads it
+			<-slow.started
+			atomic.AddUint32(&revalidations, 1)
+		}
+		if atomic.LoadUint32(&revalidations) > 0 {
+			w.Header().Set("ETag", `"v2"`)
+			http.ServeContent(w, r, file, time.Time{}, strings.NewReader(modified))
+		} else {
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, r, file, time.Time{}, strings.NewReader(contents))
+		}
+	}))
+	newRequest := func() *http.Request {
+		req, err := http.NewRequest("GET", "http://example.com/"+file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req.WithContext(app.ctx)
+	}
+
+	app.testFullRequest(file)
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file})
+	makeStale(t, app, objID)
+
+	slowDone := make(chan struct{})
+	go func() {
+		defer close(slowDone)
+		app.cacheHandler.ServeHTTP(slow, newRequest())
+	}()
+	<-slow.started
+	for i := 0; i < 100 && !app.cacheHandler.objectLocks.replacing(objID.Hash()); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !app.cacheHandler.objectLocks.replacing(objID.Hash()) {
+		t.Fatal("expected the modified object to wait for its reader to be replaced")
+	}
+	if parts, err := app.cacheHandler.Cache.Storage.GetAvailableParts(objID); err != nil || len(parts) == 0 {
+		t.Errorf("expected the parts of the object which is being read to be kept but got %d, %v",
+			len(parts), err)
+	}
+
+	// the new requests do not wait for the slow one
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.testRequest(newRequest(), modified, http.StatusOK)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the request to be served while the object waits to be replaced")
+	}
+
+	close(slow.unblock)
+	<-slowDone
+	if body := slow.Body.String(); body != contents {
+		t.Errorf("expected the slow client to receive the whole old object but got '%s'", body)
+	}
+	waitForFreshMetadata(t, app, objID)
+	obj, err := app.cacheHandler.Cache.Storage.GetMetadata(objID)
+	if err != nil {
+		t.Fatalf("unexpected error while getting the metadata: %s", err)
+	}
+	if etag := obj.Headers.Get("ETag"); etag != `"v2"` {
+		t.Errorf("expected the object to be replaced by the modified one but its ETag is %s", etag)
+	}
+	app.testRequest(newRequest(), modified, http.StatusOK)
+	if app.cacheHandler.objectLocks.replacing(objID.Hash()) || len(app.cacheHandler.objectLocks.locks) != 0 {
+		t.Error("expected the objects not to be tracked once they are not read")
+	}
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestStaleIfError(t *testing.T) {
+	t.Parallel()
+	var contents = testutils.GenerateMeAString(9, 50)
+	var files = map[string]string{
+		"sie":       contents,
+		"sie-short": contents,
+		"sie-plain": contents,
+	}
+	var cacheControl = map[string]string{
+		"sie":       "max-age=3600, stale-if-error=600",
+		"sie-short": "max-age=3600, stale-if-error=0",
+		"sie-plain": "max-age=3600",
+	}
+	var failing uint32
+	app := newTestAppFromMap(t, files)
+	defer app.cleanup()
+	app.cacheHandler.StaleIfError = 5 * time.Minute
+	for file := range files {
+		var file = file
+		app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.LoadUint32(&failing) == 1 {
+				http.Error(w, "origin is down", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Cache-Control", cacheControl[file])
+			if file != "sie-plain" {
+				w.Header().Set("ETag", `"v1"`)
+			}
+			http.ServeContent(w, r, file, time.Time{}, strings.NewReader(contents))
+		}))
+		app.testFullRequest(file)
+		makeStale(t, app, app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/" + file}))
+	}
+	atomic.StoreUint32(&failing, 1)
+
+	var tests = map[string]int{
+		"sie":       http.StatusOK,
+		"sie-short": http.StatusServiceUnavailable,
+		"sie-plain": http.StatusOK, // the location's default
+	}
+	for file, code := range tests {
+		req, err := http.NewRequest("GET", "http://example.com/"+file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rec = httptest.NewRecorder()
+		app.cacheHandler.ServeHTTP(rec, req)
+		if rec.Code != code {
+			t.Errorf("expected %d for %s but got %d", code, file, rec.Code)
+			continue
+		}
+		if code != http.StatusOK {
+			continue
+		}
+		if rec.Body.String() != contents {
+			t.Errorf("expected the stale contents of %s but got '%s'", file, rec.Body.String())
+		}
+		if warning := rec.Header().Get("Warning"); !strings.HasPrefix(warning, "111") {
+			t.Errorf("expected a revalidation failed warning for %s but got '%s'", file, warning)
+		}
+	}
+
+	// the stale object is still cached once the upstream recovers
+	atomic.StoreUint32(&failing, 0)
+	app.testFullRequest("sie")
+}
@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestResponseTagsAreIndexed(t *testing.T) {
+	t.Parallel()
+	var files = map[string]string{
+		"tagged/480p": testutils.GenerateMeAString(26, 20),
+		"tagged/720p": testutils.GenerateMeAString(27, 20),
+	}
+	app := newTestAppFromMap(t, files)
+	defer app.cleanup()
+	for file, contents := range files {
+		var file, contents = file, contents
+		app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=3600")
+			w.Header().Set("Surrogate-Key", "video-1 "+strings.TrimPrefix(file, "tagged/"))
+			w.Header().Set("Cache-Tag", "videos")
+			http.ServeContent(w, r, file, time.Time{}, strings.NewReader(contents))
+		}))
+		app.testFullRequest(file)
+	}
+
+	objID := app.cacheHandler.NewObjectIDForURL(&url.URL{Path: "/tagged/480p"})
+	obj, err := app.cacheHandler.Cache.Storage.GetMetadata(objID)
+	if err != nil {
+		t.Fatalf("unexpected error while getting the metadata: %s", err)
+	}
+	if expected := []string{"video-1", "480p", "videos"}; !reflect.DeepEqual(obj.Tags, expected) {
+		t.Errorf("expected the tags of the object to be %v but got %v", expected, obj.Tags)
+	}
+	if objects := app.cacheHandler.Cache.Tags.Objects("video-1"); len(objects) != 2 {
+		t.Errorf("expected both objects to be indexed by their tag but got %v", objects)
+	}
+
+	h := &reqHandler{CachingProxy: app.cacheHandler, objID: objID}
+	h.discardObject()
+	if objects := app.cacheHandler.Cache.Tags.Objects("480p"); len(objects) != 0 {
+		t.Errorf("expected the discarded object to be removed from the tag index but got %v", objects)
+	}
+	if objects := app.cacheHandler.Cache.Tags.Objects("videos"); len(objects) != 1 {
+		t.Errorf("expected only one object with the tag to be left but got %v", objects)
+	}
+}
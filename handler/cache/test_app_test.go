@@ -105,6 +105,8 @@ func newTestAppFromMap(t testing.TB, fsmap map[string]string) *testApp {
 		StorageObjects: 200,
 		Algorithm:      "lru",
 		PartSize:       5,
+
+		MetadataCacheSize: 100,
 	}
 
 	st, err := storage.New(cz, loc.Logger)
@@ -116,11 +118,15 @@ func newTestAppFromMap(t testing.TB, fsmap map[string]string) *testApp {
 		panic(err)
 	}
 	loc.Cache = &types.CacheZone{
-		ID:        cz.ID,
-		PartSize:  cz.PartSize,
-		Algorithm: ca,
-		Scheduler: storage.NewScheduler(loc.Logger),
-		Storage:   st,
+		ID:          cz.ID,
+		PartSize:    cz.PartSize,
+		Algorithm:   ca,
+		Scheduler:   storage.NewScheduler(loc.Logger),
+		Storage:     st,
+		Tags:        types.NewTagIndex(),
+		Refresher:   storage.NewRefresher(4),
+		RefreshJobs: types.NewRefreshJobs(),
+		ReadAheads:  storage.NewRefresher(4),
 	}
 
 	cacheHandler, err := New(nil, loc, up)
@@ -133,7 +139,13 @@ func newTestAppFromMap(t testing.TB, fsmap map[string]string) *testApp {
 		ctx:          context.Background(),
 		cacheHandler: cacheHandler,
 		fsmap:        fsmap,
-		cleanup:      cleanup,
+		cleanup: func() {
+			// the background jobs may schedule events until they are done
+			loc.Cache.Refresher.Stop()
+			loc.Cache.ReadAheads.Stop()
+			loc.Cache.Scheduler.(*storage.Scheduler).Destroy()
+			cleanup()
+		},
 	}
 	return app
 }
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ironsmile/nedomi/storage"
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils"
+	"github.com/ironsmile/nedomi/utils/cacheutils"
+)
+
+// variantID returns the ObjectID of the object's variant which is selected by
+// the request headers from the vary list.
+func (h *reqHandler) variantID(vary []string) *types.ObjectID {
+	key := cacheutils.VariantKey(vary, h.getNormalizedRequest().Header)
+	return h.NewObjectIDForVariant(h.req, key)
+}
+
+// updateVariants makes sure that a cacheable response will be stored as a
+// variant if it varies on any request headers and that the variant is recorded
+// in the primary object of the URL. It changes h.objID accordingly and may
+// change the Vary header in the supplied response headers.
+func (h *reqHandler) updateVariants(headers http.Header, expiresAt time.Time) error {
+	var vary = cacheutils.ParseVary(headers)
+	var encoded = h.settings.CacheEncoded && headers.Get("Content-Encoding") != ""
+	if len(vary) == 0 && !encoded && h.objID.Variant() == "" {
+		return nil
+	}
+
+	h.variantsLock.Lock()
+	defer h.variantsLock.Unlock()
+
+	primaryID := h.NewObjectIDForRequest(h.req)
+	primary, err := h.Cache.Storage.GetMetadata(primaryID)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if h.settings.CacheEncoded && !containsString(vary, "Accept-Encoding") &&
+		(encoded || (err == nil && containsString(primary.Vary, "Accept-Encoding"))) {
+		// Encoded responses are always stored as variants, even if the
+		// upstream does not say they vary. So are the identity responses
+		// for the objects which already have encoded variants.
+		vary = append(vary, "Accept-Encoding")
+		sort.Strings(vary)
+		headers.Set("Vary", strings.Join(vary, ", "))
+	}
+	if err == nil && !utils.EqualStringSlices(primary.Vary, vary) {
+		h.Logger.Debugf("[%s] Object now varies on %v instead of %v, discarding it...",
+			h.reqID, vary, primary.Vary)
+		h.discardVariants(primary)
+		primary = nil
+	}
+
+	if len(vary) == 0 {
+		h.objID = primaryID
+		return nil
+	}
+	if primary == nil {
+		primary = &types.ObjectMetadata{
+			ID:                primaryID,
+			ResponseTimestamp: time.Now().Unix(),
+			Code:              http.StatusOK,
+			Headers:           http.Header{"Vary": {strings.Join(vary, ", ")}},
+			Vary:              vary,
+		}
+	}
+
+	h.objID = h.variantID(vary)
+	if !containsString(primary.Variants, h.objID.Variant()) {
+		primary.Variants = append(primary.Variants, h.objID.Variant())
+	}
+	// the primary object is needed for as long as any of its variants
+	if expiresAt.Unix() > primary.ExpiresAt {
+		primary.ExpiresAt = expiresAt.Unix()
+	}
+	if swr := h.staleWhileRevalidate(headers); swr > primary.StaleWhileRevalidate {
+		primary.StaleWhileRevalidate = swr
+	}
+	if sie := h.staleIfError(headers); sie > primary.StaleIfError {
+		primary.StaleIfError = sie
+	}
+	if err := h.Cache.Storage.SaveMetadata(primary); err != nil {
+		return err
+	}
+
+	h.Cache.Scheduler.AddEvent(
+		primaryID.Hash(),
+		storage.GetExpirationHandler(h.Cache, primaryID),
+		time.Unix(primary.ExpiresAt, 0).Sub(time.Now()),
+	)
+	return nil
+}
+
+// discardVariants discards the object and all of its variants.
+func (h *reqHandler) discardVariants(obj *types.ObjectMetadata) {
+	for _, variant := range obj.Variants {
+		h.discardObjectID(h.NewObjectIDForVariant(h.req, variant))
+	}
+	h.discardObjectID(obj.ID)
+}
+
+func containsString(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func TestVaryingResponsesAreStoredAsVariants(t *testing.T) {
+	t.Parallel()
+	var file = "varying"
+	var contents = map[string]string{
+		"en": testutils.GenerateMeAString(9, 30),
+		"bg": testutils.GenerateMeAString(10, 40),
+	}
+	var upstreamRequests uint32
+	app := newTestAppFromMap(t, map[string]string{file: contents["en"]})
+	defer app.cleanup()
+	app.up.Handle("/"+file, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&upstreamRequests, 1)
+		w.Header().Set("Vary", "Accept-Language")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		http.ServeContent(w, r, file, time.Time{},
+			strings.NewReader(contents[r.Header.Get("Accept-Language")]))
+	}))
+
+	var request = func(lang string) *http.Request {
+		req, err := http.NewRequest("GET", "http://example.com/"+file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Language", lang)
+		return req
+	}
+
+	for i := 0; i < 2; i++ {
+		for _, lang := range []string{"en", "bg"} {
+			app.testRequest(request(lang), contents[lang], http.StatusOK)
+		}
+	}
+	if got := atomic.LoadUint32(&upstreamRequests); got != 2 {
+		t.Errorf("expected 2 upstream requests - one for each variant, but got %d", got)
+	}
+
+	var u = &url.URL{Path: "/" + file}
+	primary, err := app.cacheHandler.Cache.Storage.GetMetadata(app.cacheHandler.NewObjectIDForURL(u))
+	if err != nil {
+		t.Fatalf("unexpected error while getting the primary object: %s", err)
+	}
+	if len(primary.Vary) != 1 || primary.Vary[0] != "Accept-Language" {
+		t.Errorf("expected the primary object to vary on Accept-Language but it varies on %v", primary.Vary)
+	}
+	if len(primary.Variants) != 2 {
+		t.Errorf("expected the primary object to have 2 variants but it has %v", primary.Variants)
+	}
+	for _, variant := range primary.Variants {
+		oid := app.cacheHandler.NewObjectIDForVariant(&http.Request{URL: u}, variant)
+		if _, err := app.cacheHandler.Cache.Storage.GetMetadata(oid); err != nil {
+			t.Errorf("unexpected error while getting the variant %s: %s", oid, err)
+		}
+	}
+}
@@ -17,9 +17,60 @@ type Handler struct {
 	logger types.Logger
 }
 
-type purgeRequest config.StringSlice
+type purgeRequest []purgeEntry
 type purgeResult map[string]bool
 
+// purgeEntry is an object to be purged. It is either just the URL of the
+// object or the URL and the request headers with which it was requested, for
+// the locations whose cache keys include headers or cookies. If there is a
+// tag, all the objects with it in the cache zone of the URL's location are
+// purged instead.
+type purgeEntry struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Tag     string            `json:"tag"`
+}
+
+// UnmarshalJSON unmarshals a single purge entry or a list of them.
+func (pr *purgeRequest) UnmarshalJSON(buf []byte) error {
+	var entries []purgeEntry
+	if err := json.Unmarshal(buf, &entries); err == nil {
+		*pr = entries
+		return nil
+	}
+	var entry purgeEntry
+	if err := json.Unmarshal(buf, &entry); err != nil {
+		return err
+	}
+	*pr = purgeRequest{entry}
+	return nil
+}
+
+// UnmarshalJSON unmarshals either a URL string or an object with url and
+// headers.
+func (pe *purgeEntry) UnmarshalJSON(buf []byte) error {
+	if err := json.Unmarshal(buf, &pe.URL); err == nil {
+		return nil
+	}
+	type entry purgeEntry // without the UnmarshalJSON method
+	return json.Unmarshal(buf, (*entry)(pe))
+}
+
+// request returns a request like the one with which the object was requested
+// from the cache, so that the location can build its cache key.
+func (pe *purgeEntry) request(u *url.URL) *http.Request {
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Host:   u.Host,
+		Header: make(http.Header),
+	}
+	for name, value := range pe.Headers {
+		req.Header.Set(name, value)
+	}
+	return req
+}
+
 // ServeHTTP servers the purge page.
 func (ph *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	reqID, _ := contexts.GetRequestID(r.Context())
@@ -58,8 +109,13 @@ func (ph *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (ph *Handler) purgeAll(reqID types.RequestID, app types.App, pr purgeRequest) (purgeResult, error) {
 	var pres = purgeResult(make(map[string]bool))
 
-	for _, uString := range pr {
-		pres[uString] = false
+	for _, entry := range pr {
+		var uString = entry.URL
+		var key = uString
+		if entry.Tag != "" {
+			key = entry.Tag
+		}
+		pres[key] = false
 		var u, err = url.Parse(uString)
 		if err != nil {
 			continue
@@ -72,36 +128,111 @@ func (ph *Handler) purgeAll(reqID types.RequestID, app types.App, pr purgeReques
 			continue
 		}
 
-		var oid = location.NewObjectIDForURL(u)
-
-		parts, err := location.Cache.Storage.GetAvailableParts(oid)
-
-		if err != nil {
-			if !os.IsNotExist(err) {
-				ph.logger.Errorf(
-					"[%s] got error while gettings parts of object '%s' - %s",
-					reqID, oid, err)
+		if entry.Tag != "" {
+			purged, err := ph.purgeTag(reqID, location, entry.Tag)
+			if err != nil {
 				return nil, err
 			}
+			pres[key] = purged
+			continue
 		}
 
-		if len(parts) == 0 {
-			continue
+		var oid = location.NewObjectIDForRequest(entry.request(u))
+		purged, err := ph.purgeObject(reqID, location, oid)
+		if err != nil {
+			return nil, err
 		}
+		pres[uString] = purged
+	}
+	return pres, nil
+}
 
-		if err = location.Cache.Storage.Discard(oid); err != nil {
-			if !os.IsNotExist(err) {
-				ph.logger.Errorf(
-					"[%s] got error while purging object '%s' - %s",
-					reqID, oid, err)
-				return nil, err
-			}
+// purgeObject removes the object with all of its parts from the location's
+// cache zone. The varying objects are removed with all of their variants. It
+// returns whether there was anything to remove. Objects without any stored
+// parts, like the negatively cached ones, are removed too.
+func (ph *Handler) purgeObject(reqID types.RequestID, location *types.Location, oid *types.ObjectID) (bool, error) {
+	obj, err := location.Cache.Storage.GetMetadata(oid)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
 		}
+		ph.logger.Errorf(
+			"[%s] got error while getting the metadata of object '%s' - %s",
+			reqID, oid, err)
+		return false, err
+	}
+	if len(obj.Vary) > 0 {
+		return ph.purgeVariants(reqID, location, obj)
+	}
+
+	parts, err := location.Cache.Storage.GetAvailableParts(oid)
 
+	if err != nil {
+		if !os.IsNotExist(err) {
+			ph.logger.Errorf(
+				"[%s] got error while gettings parts of object '%s' - %s",
+				reqID, oid, err)
+			return false, err
+		}
+	}
+
+	if err = location.Cache.Storage.Discard(oid); err != nil {
+		if !os.IsNotExist(err) {
+			ph.logger.Errorf(
+				"[%s] got error while purging object '%s' - %s",
+				reqID, oid, err)
+			return false, err
+		}
+	}
+
+	if len(parts) > 0 {
 		location.Cache.Algorithm.Remove(parts...)
-		pres[uString] = err == nil // err is os.ErrNotExist
 	}
-	return pres, nil
+	location.Cache.Tags.Remove(oid)
+	return err == nil, nil // err is os.ErrNotExist
+}
+
+// purgeVariants removes the varying object and all of its variants, which
+// hold its contents, from the location's cache zone. It returns whether there
+// was anything to remove.
+func (ph *Handler) purgeVariants(reqID types.RequestID, location *types.Location, obj *types.ObjectMetadata) (bool, error) {
+	var purged bool
+	for _, variant := range obj.Variants {
+		vid := types.NewVariantObjectID(obj.ID.CacheKey(), obj.ID.Path(), variant)
+		variantPurged, err := ph.purgeObject(reqID, location, vid)
+		if err != nil {
+			return false, err
+		}
+		purged = purged || variantPurged
+	}
+
+	if err := location.Cache.Storage.Discard(obj.ID); err != nil {
+		if !os.IsNotExist(err) {
+			ph.logger.Errorf(
+				"[%s] got error while purging object '%s' - %s",
+				reqID, obj.ID, err)
+			return false, err
+		}
+	} else {
+		purged = true
+	}
+	location.Cache.Tags.Remove(obj.ID)
+	return purged, nil
+}
+
+// purgeTag removes all the objects with the tag from the location's cache
+// zone. It returns whether there was anything to remove.
+func (ph *Handler) purgeTag(reqID types.RequestID, location *types.Location, tag string) (bool, error) {
+	var purged bool
+	for _, oid := range location.Cache.Tags.Objects(tag) {
+		objPurged, err := ph.purgeObject(reqID, location, oid)
+		if err != nil {
+			return false, err
+		}
+		purged = purged || objPurged
+	}
+	return purged, nil
 }
 
 // New creates and returns a ready to used ServerPurgeHandler.
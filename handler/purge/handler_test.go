@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
 	"testing"
 
 	"github.com/ironsmile/nedomi/config"
@@ -97,6 +99,7 @@ func testSetupWithStorage(t *testing.T, st types.Storage) (context.Context, *Han
 			Remove: removeFunctionMock(t),
 		}),
 		Storage: st,
+		Tags:    types.NewTagIndex(),
 	}
 	loc1 := &types.Location{
 		Logger:   mock.NewLogger(),
@@ -277,3 +280,261 @@ func TestPurgeBadGetParts(t *testing.T) {
 	purger.ServeHTTP(rec, req)
 	testCode(t, rec.Code, http.StatusInternalServerError)
 }
+
+func TestPurgeVariants(t *testing.T) {
+	var variants = []string{"Origin:example.com", "Origin:example.net"}
+	var variantObjs = []*types.ObjectID{
+		types.NewVariantObjectID(cacheKey1, path1, variants[0]),
+		types.NewVariantObjectID(cacheKey1, path1, variants[1]),
+	}
+	var st = storageWithObjects(t, variantObjs...)
+	testutils.ShouldntFail(t, st.SaveMetadata(&types.ObjectMetadata{
+		ID:       obj1,
+		Vary:     []string{"Origin"},
+		Variants: variants,
+	}))
+	ctx, purger, _ := testSetupWithStorage(t, st)
+
+	req, err := http.NewRequest("POST", testURL,
+		bytes.NewReader([]byte(`["`+url1+`"]`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(ctx)
+	rec := httptest.NewRecorder()
+	purger.ServeHTTP(rec, req)
+	testCode(t, rec.Code, http.StatusOK)
+	var pr purgeResult
+	if err = json.Unmarshal(rec.Body.Bytes(), &pr); err != nil {
+		t.Fatal(err)
+	}
+	checkPr(t, pr, []string{url1}, true)
+
+	for _, oid := range append(variantObjs, obj1) {
+		if _, err := st.GetMetadata(oid); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be purged but got error %v", oid, err)
+		}
+	}
+}
+
+func TestPurgeWithCacheKeyTemplate(t *testing.T) {
+	var objs = []*types.ObjectID{
+		types.NewObjectID(cacheKey1, "example.org"+path1+"?a=1&b=2|x-device=mobile|cookie:lang=en"),
+		types.NewObjectID(cacheKey1, "example.org"+path1+"?a=1&b=2|x-device=desktop|cookie:lang=en"),
+	}
+	var st = storageWithObjects(t, objs...)
+	ctx, purger, _ := testSetupWithStorage(t, st)
+	var loc = &types.Location{
+		Logger:   mock.NewLogger(),
+		CacheKey: cacheKey1,
+		Cache: &types.CacheZone{
+			Algorithm: mock.NewCacheAlgorithm(nil),
+			Storage:   st,
+			Tags:      types.NewTagIndex(),
+		},
+		CacheKeyTemplate: &types.CacheKeyTemplate{
+			Host:           true,
+			Query:          true,
+			SortQuery:      true,
+			QueryBlacklist: []string{"utm_*"},
+			Headers:        []string{"X-Device"},
+			Cookies:        []string{"lang"},
+		},
+	}
+	ctx = contexts.NewAppContext(ctx, &mockApp{
+		getLocationFor: func(string, string) *types.Location { return loc },
+	})
+
+	var purgeURL = "http://example.org" + path1 + "?b=2&utm_source=x&a=1"
+	req, err := http.NewRequest("POST", testURL, bytes.NewReader([]byte(`[
+		{"url": "`+purgeURL+`", "headers": {"X-Device": "mobile", "Cookie": "lang=en"}}
+	]`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(ctx)
+	rec := httptest.NewRecorder()
+	purger.ServeHTTP(rec, req)
+	testCode(t, rec.Code, http.StatusOK)
+	var pr purgeResult
+	if err = json.Unmarshal(rec.Body.Bytes(), &pr); err != nil {
+		t.Fatal(err)
+	}
+	checkPr(t, pr, []string{purgeURL}, true)
+
+	if _, err := st.GetMetadata(objs[0]); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be purged but got error %v", objs[0], err)
+	}
+	if _, err := st.GetMetadata(objs[1]); err != nil {
+		t.Errorf("expected %s not to be purged but got error %v", objs[1], err)
+	}
+}
+
+func TestPurgeRequestParsing(t *testing.T) {
+	var tests = map[string]purgeRequest{
+		`"` + url1 + `"`:                   {{URL: url1}},
+		`["` + url1 + `", "` + url2 + `"]`: {{URL: url1}, {URL: url2}},
+		`[{"url": "` + url1 + `", "headers": {"Cookie": "a=b"}}, "` + url2 + `"]`: {
+			{URL: url1, Headers: map[string]string{"Cookie": "a=b"}}, {URL: url2}},
+		`{"url": "` + url1 + `", "tag": "video-1"}`: {{URL: url1, Tag: "video-1"}},
+	}
+	for text, expected := range tests {
+		var pr purgeRequest
+		if err := json.Unmarshal([]byte(text), &pr); err != nil {
+			t.Errorf("unexpected error while parsing %s: %s", text, err)
+		} else if !reflect.DeepEqual(pr, expected) {
+			t.Errorf("expected %s to be parsed as %+v but got %+v", text, expected, pr)
+		}
+	}
+}
+
+func TestPurgeTags(t *testing.T) {
+	var tagged = []*types.ObjectID{
+		types.NewObjectID(cacheKey1, "/video/1/480p"),
+		types.NewObjectID(cacheKey1, "/video/1/720p"),
+	}
+	var other = types.NewObjectID(cacheKey1, "/video/2/480p")
+	var st = storageWithObjects(t, append(tagged, other)...)
+	var removed = make(map[string]bool)
+	var loc = &types.Location{
+		Logger:   mock.NewLogger(),
+		CacheKey: cacheKey1,
+		Cache: &types.CacheZone{
+			Algorithm: mock.NewCacheAlgorithm(&mock.CacheAlgorithmRepliers{
+				Remove: func(parts ...*types.ObjectIndex) {
+					for _, part := range parts {
+						removed[part.ObjID.Path()] = true
+					}
+				},
+			}),
+			Storage: st,
+			Tags:    types.NewTagIndex(),
+		},
+	}
+	for _, oid := range tagged {
+		loc.Cache.Tags.Set(oid, "video-1", "videos")
+	}
+	loc.Cache.Tags.Set(other, "video-2", "videos")
+	ctx, purger, _ := testSetupWithStorage(t, st)
+	ctx = contexts.NewAppContext(ctx, &mockApp{
+		getLocationFor: func(string, string) *types.Location { return loc },
+	})
+
+	req, err := http.NewRequest("POST", testURL, bytes.NewReader([]byte(`[
+		{"url": "`+url1+`", "tag": "video-1"},
+		{"url": "`+url1+`", "tag": "video-3"}
+	]`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(ctx)
+	rec := httptest.NewRecorder()
+	purger.ServeHTTP(rec, req)
+	testCode(t, rec.Code, http.StatusOK)
+	var pr purgeResult
+	if err = json.Unmarshal(rec.Body.Bytes(), &pr); err != nil {
+		t.Fatal(err)
+	}
+	checkPr(t, pr, []string{"video-1"}, true)
+	checkPr(t, pr, []string{"video-3"}, false)
+
+	for _, oid := range tagged {
+		if _, err := st.GetMetadata(oid); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be purged but got error %v", oid, err)
+		}
+		if !removed[oid.Path()] {
+			t.Errorf("expected the parts of %s to be removed from the cache algorithm", oid)
+		}
+	}
+	if _, err := st.GetMetadata(other); err != nil {
+		t.Errorf("expected %s not to be purged but got error %v", other, err)
+	}
+	if objects := loc.Cache.Tags.Objects("videos"); len(objects) != 1 || objects[0] != other {
+		t.Errorf("expected only %s to be left in the tag index but got %v", other, objects)
+	}
+}
+
+func TestPurgeTaggedVariants(t *testing.T) {
+	var variants = []string{"Origin:example.com", "Origin:example.net"}
+	var variantObjs = []*types.ObjectID{
+		types.NewVariantObjectID(cacheKey1, path1, variants[0]),
+		types.NewVariantObjectID(cacheKey1, path1, variants[1]),
+	}
+	var st = storageWithObjects(t, variantObjs...)
+	testutils.ShouldntFail(t, st.SaveMetadata(&types.ObjectMetadata{
+		ID:       obj1,
+		Vary:     []string{"Origin"},
+		Variants: variants,
+	}))
+	var loc = &types.Location{
+		Logger:   mock.NewLogger(),
+		CacheKey: cacheKey1,
+		Cache: &types.CacheZone{
+			Algorithm: mock.NewCacheAlgorithm(nil),
+			Storage:   st,
+			Tags:      types.NewTagIndex(),
+		},
+	}
+	loc.Cache.Tags.Set(obj1, "video-1")
+	ctx, purger, _ := testSetupWithStorage(t, st)
+	ctx = contexts.NewAppContext(ctx, &mockApp{
+		getLocationFor: func(string, string) *types.Location { return loc },
+	})
+
+	req, err := http.NewRequest("POST", testURL,
+		bytes.NewReader([]byte(`[{"url": "`+url1+`", "tag": "video-1"}]`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(ctx)
+	rec := httptest.NewRecorder()
+	purger.ServeHTTP(rec, req)
+	testCode(t, rec.Code, http.StatusOK)
+	var pr purgeResult
+	if err = json.Unmarshal(rec.Body.Bytes(), &pr); err != nil {
+		t.Fatal(err)
+	}
+	checkPr(t, pr, []string{"video-1"}, true)
+
+	for _, oid := range append(variantObjs, obj1) {
+		if _, err := st.GetMetadata(oid); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be purged but got error %v", oid, err)
+		}
+	}
+	if objects := loc.Cache.Tags.Objects("video-1"); len(objects) != 0 {
+		t.Errorf("expected no objects to be left in the tag index but got %v", objects)
+	}
+}
+
+func TestPurgeObjectsWithoutParts(t *testing.T) {
+	var st = storageWithObjects(t, obj2)
+	// obj1 is negatively cached and all the parts of obj2 were evicted
+	testutils.ShouldntFail(t,
+		st.SaveMetadata(&types.ObjectMetadata{ID: obj1, Code: http.StatusNotFound}),
+		st.DiscardPart(&types.ObjectIndex{ObjID: obj2, Part: 2}),
+		st.DiscardPart(&types.ObjectIndex{ObjID: obj2, Part: 4}),
+	)
+	ctx, purger, _ := testSetupWithStorage(t, st)
+
+	req, err := http.NewRequest("POST", testURL,
+		bytes.NewReader([]byte(`["`+url1+`", "`+url2+`", "`+url3+`"]`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(ctx)
+	rec := httptest.NewRecorder()
+	purger.ServeHTTP(rec, req)
+	testCode(t, rec.Code, http.StatusOK)
+	var pr purgeResult
+	if err = json.Unmarshal(rec.Body.Bytes(), &pr); err != nil {
+		t.Fatal(err)
+	}
+	checkPr(t, pr, []string{url1, url2}, true)
+	checkPr(t, pr, []string{url3}, false)
+
+	for _, oid := range []*types.ObjectID{obj1, obj2} {
+		if _, err := st.GetMetadata(oid); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be purged but got error %v", oid, err)
+		}
+	}
+}
@@ -80,7 +80,17 @@ func newStatistics(app types.App, cacheZones map[string]*types.CacheZone) statis
 			Objects:     stats.Objects(),
 			CacheHitPrc: stats.CacheHitPrc(),
 			Size:        stats.Size().Bytes(),
+
+			ChangedObjects: cacheZone.Counters.ChangedObjects(),
+			CorruptedParts: cacheZone.Counters.CorruptedParts(),
+
+			RefreshedObjects: cacheZone.Counters.RefreshedObjects(),
 		})
+		if storage, ok := cacheZone.Storage.(types.MetadataCaching); ok {
+			var stat = &zones[len(zones)-1]
+			stat.MetadataHits, stat.MetadataRequests = storage.MetadataCacheStats()
+			stat.MetadataHitPrc = hitPercentage(stat.MetadataHits, stat.MetadataRequests)
+		}
 	}
 
 	var appStats = app.Stats()
@@ -134,6 +144,23 @@ type zoneStat struct {
 	Objects     uint64 `json:"objects"`
 	CacheHitPrc string `json:"hit_percentage"`
 	Size        uint64 `json:"size"`
+
+	ChangedObjects uint64 `json:"changed_objects"`
+	CorruptedParts uint64 `json:"corrupted_parts"`
+
+	RefreshedObjects uint64 `json:"refreshed_objects"`
+
+	MetadataHits     uint64 `json:"metadata_hits"`
+	MetadataRequests uint64 `json:"metadata_requests"`
+	MetadataHitPrc   string `json:"metadata_hit_percentage"`
+}
+
+// hitPercentage formats the hit rate like the cache algorithm statistics do.
+func hitPercentage(hits, requests uint64) string {
+	if requests == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.f%%", float32(hits)/float32(requests)*100)
 }
 
 // New creates and returns a ready to used ServerStatusHandler.
@@ -6,17 +6,21 @@ import "github.com/ironsmile/nedomi/types"
 type CacheAlgorithmRepliers struct {
 	Lookup        func(*types.ObjectIndex) bool
 	ShouldKeep    func(*types.ObjectIndex) bool
+	ShouldAdmit   func(*types.ObjectID) bool
 	AddObject     func(*types.ObjectIndex) error
 	Remove        func(...*types.ObjectIndex)
 	PromoteObject func(*types.ObjectIndex)
+	Popularity    func(*types.ObjectIndex) float64
 }
 
 // DefaultCacheAlgorithmRepliers always return false and nil
 var DefaultCacheAlgorithmRepliers = CacheAlgorithmRepliers{
 	Lookup:        func(*types.ObjectIndex) bool { return false },
 	ShouldKeep:    func(*types.ObjectIndex) bool { return false },
+	ShouldAdmit:   func(*types.ObjectID) bool { return false },
 	AddObject:     func(*types.ObjectIndex) error { return nil },
 	PromoteObject: func(*types.ObjectIndex) {},
+	Popularity:    func(*types.ObjectIndex) float64 { return 0 },
 	Remove:        func(...*types.ObjectIndex) {},
 }
 
@@ -49,6 +53,11 @@ func (c *CacheAlgorithm) ShouldKeep(o *types.ObjectIndex) bool {
 	return c.Defaults.ShouldKeep(o)
 }
 
+// ShouldAdmit returns the default value, as the replies are set per index
+func (c *CacheAlgorithm) ShouldAdmit(id *types.ObjectID) bool {
+	return c.Defaults.ShouldAdmit(id)
+}
+
 // AddObject returns the specified (if present for this index) or default error
 func (c *CacheAlgorithm) AddObject(o *types.ObjectIndex) error {
 	if found, ok := c.Mapping[*o]; ok && found.AddObject != nil {
@@ -66,6 +75,14 @@ func (c *CacheAlgorithm) PromoteObject(o *types.ObjectIndex) {
 	c.Defaults.PromoteObject(o)
 }
 
+// Popularity returns the specified (if present for this index) or default value
+func (c *CacheAlgorithm) Popularity(o *types.ObjectIndex) float64 {
+	if found, ok := c.Mapping[*o]; ok && found.Popularity != nil {
+		return found.Popularity(o)
+	}
+	return c.Defaults.Popularity(o)
+}
+
 // ConsumedSize always returns 0
 func (c *CacheAlgorithm) ConsumedSize() types.BytesSize {
 	return 0
@@ -102,12 +119,21 @@ func NewCacheAlgorithm(defaults *CacheAlgorithmRepliers) *CacheAlgorithm {
 	if defaults.ShouldKeep != nil {
 		res.Defaults.ShouldKeep = defaults.ShouldKeep
 	}
+	if defaults.ShouldAdmit != nil {
+		res.Defaults.ShouldAdmit = defaults.ShouldAdmit
+	}
 	if defaults.AddObject != nil {
 		res.Defaults.AddObject = defaults.AddObject
 	}
 	if defaults.PromoteObject != nil {
 		res.Defaults.PromoteObject = defaults.PromoteObject
 	}
+	if defaults.Popularity != nil {
+		res.Defaults.Popularity = defaults.Popularity
+	}
+	if defaults.Remove != nil {
+		res.Defaults.Remove = defaults.Remove
+	}
 
 	return res
 }
@@ -17,7 +17,8 @@ var _ types.CacheAlgorithm = new(CacheAlgorithm) // make sure the mock implement
 func TestMockCacheAlgorithm(t *testing.T) {
 	t.Parallel()
 	d := NewCacheAlgorithm(nil)
-	if d.Defaults.Lookup(idx) || d.Defaults.ShouldKeep(idx) || d.Defaults.AddObject(idx) != nil {
+	if d.Defaults.Lookup(idx) || d.Defaults.ShouldKeep(idx) || d.Defaults.AddObject(idx) != nil ||
+		d.ShouldAdmit(idx.ObjID) {
 		t.Errorf("Invalid default default replies %#v", d.Defaults)
 	}
 
@@ -32,10 +33,12 @@ func TestMockCacheAlgorithm(t *testing.T) {
 	c2 := NewCacheAlgorithm(&CacheAlgorithmRepliers{
 		Lookup:        func(*types.ObjectIndex) bool { return true },
 		ShouldKeep:    func(*types.ObjectIndex) bool { return true },
+		ShouldAdmit:   func(*types.ObjectID) bool { return true },
 		AddObject:     func(*types.ObjectIndex) error { return errors.New("ha") },
 		PromoteObject: func(*types.ObjectIndex) { promoted = true },
 	})
-	if !c2.Defaults.Lookup(idx) || !c2.Defaults.ShouldKeep(idx) || c2.Defaults.AddObject(idx) == nil {
+	if !c2.Defaults.Lookup(idx) || !c2.Defaults.ShouldKeep(idx) || c2.Defaults.AddObject(idx) == nil ||
+		!c2.ShouldAdmit(idx.ObjID) {
 		t.Error("Invalid full custom default replies")
 	}
 	if c2.PromoteObject(idx); !promoted {
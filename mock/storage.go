@@ -26,6 +26,8 @@ type Storage struct {
 	partSize uint64
 	Objects  map[types.ObjectIDHash]*types.ObjectMetadata
 	Parts    map[types.ObjectIDHash]map[uint32][]byte
+	// Checksums of the parts, calculated when they are saved
+	Checksums map[types.ObjectIDHash]map[uint32]uint32
 }
 
 // PartSize the maximum part size for the disk storage.
@@ -98,9 +100,25 @@ func (s *Storage) SavePart(idx *types.ObjectIndex, data io.Reader) error {
 	}
 
 	s.Parts[objHash][idx.Part] = contents
+	if _, ok := s.Checksums[objHash]; !ok {
+		s.Checksums[objHash] = make(map[uint32]uint32)
+	}
+	checksum := types.NewPartHash()
+	_, _ = checksum.Write(contents)
+	s.Checksums[objHash][idx.Part] = checksum.Sum32()
 	return nil
 }
 
+// GetPartChecksum returns the checksum of the specified part of the object.
+func (s *Storage) GetPartChecksum(idx *types.ObjectIndex) (uint32, error) {
+	if obj, ok := s.Checksums[idx.ObjID.Hash()]; ok {
+		if checksum, ok := obj[idx.Part]; ok {
+			return checksum, nil
+		}
+	}
+	return 0, os.ErrNotExist
+}
+
 // Discard removes the object and its metadata.
 func (s *Storage) Discard(id *types.ObjectID) error {
 	if _, ok := s.Objects[id.Hash()]; !ok {
@@ -108,6 +126,7 @@ func (s *Storage) Discard(id *types.ObjectID) error {
 	}
 	delete(s.Objects, id.Hash())
 	delete(s.Parts, id.Hash())
+	delete(s.Checksums, id.Hash())
 
 	return nil
 }
@@ -116,6 +135,7 @@ func (s *Storage) Discard(id *types.ObjectID) error {
 func (s *Storage) DiscardPart(idx *types.ObjectIndex) error {
 	if obj, ok := s.Parts[idx.ObjID.Hash()]; ok {
 		delete(obj, idx.Part)
+		delete(s.Checksums[idx.ObjID.Hash()], idx.Part)
 		return nil
 	}
 	return os.ErrNotExist
@@ -139,6 +159,8 @@ func NewStorage(partSize uint64) *Storage {
 		partSize: partSize,
 		Objects:  make(map[types.ObjectIDHash]*types.ObjectMetadata),
 		Parts:    make(map[types.ObjectIDHash]map[uint32][]byte),
+
+		Checksums: make(map[types.ObjectIDHash]map[uint32]uint32),
 	}
 }
 
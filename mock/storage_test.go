@@ -80,6 +80,12 @@ func savePart(t *testing.T, s *Storage, idx *types.ObjectIndex, contents string)
 	} else if string(readContents) != contents {
 		t.Errorf("Expected the contents to be %s but read %s", contents, readContents)
 	}
+
+	checksum := types.NewPartHash()
+	_, _ = checksum.Write([]byte(contents))
+	if readChecksum, err := s.GetPartChecksum(idx); err != nil || readChecksum != checksum.Sum32() {
+		t.Errorf("Expected the checksum to be %08x but got %08x (%v)", checksum.Sum32(), readChecksum, err)
+	}
 }
 
 func TestMockStorageOperations(t *testing.T) {
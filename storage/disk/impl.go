@@ -1,12 +1,13 @@
 package disk
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 
 	"github.com/ironsmile/nedomi/config"
 	"github.com/ironsmile/nedomi/types"
@@ -21,8 +22,15 @@ type Disk struct {
 	dirPermissions     os.FileMode
 	filePermissions    os.FileMode
 	skipCacheKeyInPath bool
+	metadata           *metadataCache
+	// metadataLocks serialize the writes of the metadata files, so that the
+	// migration of the legacy ones does not overwrite newer metadata. The
+	// objects are spread over them by their hashes.
+	metadataLocks [metadataLocksCount]sync.Mutex
 }
 
+const metadataLocksCount = 64
+
 // PartSize the maximum part size for the disk storage.
 func (s *Disk) PartSize() uint64 {
 	return s.partSize
@@ -30,9 +38,30 @@ func (s *Disk) PartSize() uint64 {
 
 // GetMetadata returns the metadata on disk for this object, if present.
 func (s *Disk) GetMetadata(id *types.ObjectID) (*types.ObjectMetadata, error) {
-	//!TODO: optimize - reading and parsing the file from disk every time is very ineffictient
 	s.GetLogger().Debugf("[DiskStorage] Getting metadata for %s...", id)
-	return s.getObjectMetadata(s.getObjectMetadataPath(id))
+	if s.metadata == nil {
+		return s.getObjectMetadata(s.getObjectMetadataPath(id))
+	}
+
+	obj, version := s.metadata.get(id)
+	if obj != nil {
+		return obj, nil
+	}
+	obj, err := s.getObjectMetadata(s.getObjectMetadataPath(id))
+	if err != nil {
+		return nil, err
+	}
+	s.metadata.add(obj, version)
+	return obj, nil
+}
+
+// MetadataCacheStats returns how many of the metadata requests were served
+// from the metadata cache in memory and how many requests there were.
+func (s *Disk) MetadataCacheStats() (hits, requests uint64) {
+	if s.metadata == nil {
+		return 0, 0
+	}
+	return s.metadata.stats()
 }
 
 // GetPart returns an io.ReadCloser that will read the specified part of the
@@ -83,22 +112,27 @@ func (s *Disk) GetAvailableParts(oid *types.ObjectID) ([]*types.ObjectIndex, err
 // SaveMetadata writes the supplied metadata to the disk.
 func (s *Disk) SaveMetadata(m *types.ObjectMetadata) error {
 	s.GetLogger().Debugf("[DiskStorage] Saving metadata for %s...", m.ID)
+	defer s.lockMetadata(m.ID)()
+	return s.saveMetadata(m)
+}
 
+// saveMetadata should be called with the metadata lock of the object held.
+func (s *Disk) saveMetadata(m *types.ObjectMetadata) error {
 	tmpPath := appendRandomSuffix(s.getObjectMetadataPath(m.ID))
 	f, err := s.createFile(tmpPath)
 	if err != nil {
 		return err
 	}
 
-	if err = json.NewEncoder(f).Encode(m); err != nil {
-		return utils.NewCompositeError(err, f.Close())
+	if _, err = f.Write(encodeMetadata(m)); err != nil {
+		return utils.NewCompositeError(err, f.Close(), os.Remove(tmpPath))
 	} else if err := f.Close(); err != nil {
 		return err
 	}
 
-	//!TODO: use a faster encoding than json (some binary marshaller? gob?)
-
-	return os.Rename(tmpPath, s.getObjectMetadataPath(m.ID))
+	err = os.Rename(tmpPath, s.getObjectMetadataPath(m.ID))
+	s.invalidateMetadata(m.ID)
+	return err
 }
 
 // SavePart writes the contents of the supplied object part to the disk.
@@ -111,7 +145,8 @@ func (s *Disk) SavePart(idx *types.ObjectIndex, data io.Reader) error {
 		return err
 	}
 
-	if savedSize, err := io.Copy(f, data); err != nil {
+	checksum := types.NewPartHash()
+	if savedSize, err := io.Copy(io.MultiWriter(f, checksum), data); err != nil {
 		return utils.NewCompositeError(err, f.Close(), os.Remove(tmpPath))
 	} else if uint64(savedSize) > s.partSize {
 		err = fmt.Errorf("Object part has invalid size %d", savedSize)
@@ -120,30 +155,92 @@ func (s *Disk) SavePart(idx *types.ObjectIndex, data io.Reader) error {
 		return err
 	}
 
+	// the checksum is saved first so that the part is never without one
+	if err := s.savePartChecksum(idx, checksum.Sum32()); err != nil {
+		return utils.NewCompositeError(err, os.Remove(tmpPath))
+	}
+
 	return os.Rename(tmpPath, s.getObjectIndexPath(idx))
 }
 
+// GetPartChecksum returns the checksum of the specified part which was
+// recorded when it was saved to the disk.
+func (s *Disk) GetPartChecksum(idx *types.ObjectIndex) (uint32, error) {
+	contents, err := ioutil.ReadFile(s.getPartChecksumPath(idx))
+	if err != nil {
+		return 0, err
+	}
+
+	checksum, err := strconv.ParseUint(string(contents), 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid checksum of %s: %s", idx, err)
+	}
+	return uint32(checksum), nil
+}
+
 // Discard removes the object and its metadata from the disk.
 func (s *Disk) Discard(id *types.ObjectID) error {
 	s.GetLogger().Debugf("[DiskStorage] Discarding %s...", id)
+	defer s.lockMetadata(id)()
 	oldPath := s.getObjectIDPath(id)
 	tmpPath := appendRandomSuffix(oldPath)
-	if err := os.Rename(oldPath, tmpPath); err != nil {
+	err := os.Rename(oldPath, tmpPath)
+	s.invalidateMetadata(id)
+	if err != nil {
 		return err
 	}
 
 	return os.RemoveAll(tmpPath)
 }
 
+// lockMetadata locks the metadata file of the object and returns a function
+// which unlocks it.
+func (s *Disk) lockMetadata(id *types.ObjectID) func() {
+	l := &s.metadataLocks[id.Hash()[0]%metadataLocksCount]
+	l.Lock()
+	return l.Unlock
+}
+
+// migrateMetadata rewrites the legacy metadata file in the binary format. It
+// is read again with the lock held, so that the metadata which was saved or
+// discarded in the meantime is not overwritten. The returned metadata is the
+// one which is on the disk and the boolean is true if it was migrated.
+func (s *Disk) migrateMetadata(objPath string) (*types.ObjectMetadata, bool, error) {
+	obj, legacy, err := s.readObjectMetadata(objPath)
+	if err != nil || !legacy {
+		return obj, false, err
+	}
+
+	defer s.lockMetadata(obj.ID)()
+	if obj, legacy, err = s.readObjectMetadata(objPath); err != nil || !legacy {
+		return obj, false, err
+	}
+	return obj, true, s.saveMetadata(obj)
+}
+
+func (s *Disk) invalidateMetadata(id *types.ObjectID) {
+	if s.metadata != nil {
+		s.metadata.remove(id)
+	}
+}
+
 // DiscardPart removes the specified part of an Object from the disk.
 func (s *Disk) DiscardPart(idx *types.ObjectIndex) error {
 	s.GetLogger().Debugf("[DiskStorage] Discarding %s...", idx)
-	return os.Remove(s.getObjectIndexPath(idx))
+	if err := os.Remove(s.getObjectIndexPath(idx)); err != nil {
+		return err
+	}
+
+	if err := os.Remove(s.getPartChecksumPath(idx)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 // Iterate is a disk-specific function that iterates over all the objects on the
 // disk and passes them to the supplied callback function. If the callback
-// function returns false, the iteration stops.
+// function returns false, the iteration stops. The metadata which is still in
+// the legacy JSON format is rewritten in the binary one along the way.
 func (s *Disk) Iterate(callback func(*types.ObjectMetadata, ...*types.ObjectIndex) bool) error {
 	// At most count(cacheKeys)*256*256 directories
 	rootDirs, err := filepath.Glob(s.path + s.iterateGlob())
@@ -151,6 +248,14 @@ func (s *Disk) Iterate(callback func(*types.ObjectMetadata, ...*types.ObjectInde
 		return err
 	}
 
+	var migrated int
+	defer func() {
+		if migrated > 0 {
+			s.GetLogger().Logf("[DiskStorage] Migrated the metadata of %d objects in %s to the binary format",
+				migrated, s.path)
+		}
+	}()
+
 	//!TODO: should we delete the offending folder if we detect an error? maybe just in some cases?
 	for _, rootDir := range rootDirs {
 		//TODO: stat dirs little by little?
@@ -162,12 +267,18 @@ func (s *Disk) Iterate(callback func(*types.ObjectMetadata, ...*types.ObjectInde
 		for _, objectDir := range objectDirs {
 			objectDirPath := filepath.Join(rootDir, objectDir.Name(), objectMetadataFileName)
 			//!TODO: continue on os.ErrNotExist, delete on other errors?
-			obj, err := s.getObjectMetadata(objectDirPath)
-			if err != nil {
+			obj, legacy, err := s.migrateMetadata(objectDirPath)
+			if err != nil && obj == nil {
 				s.GetLogger().Errorf(
 					"[DiskStorage] error on getting metadata from %s - %s",
 					objectDirPath, err)
 				continue
+			} else if err != nil {
+				s.GetLogger().Errorf(
+					"[DiskStorage] error on migrating metadata in %s - %s",
+					objectDirPath, err)
+			} else if legacy {
+				migrated++
 			}
 			parts, err := s.GetAvailableParts(obj.ID)
 			if err != nil {
@@ -208,6 +319,9 @@ func New(cfg *config.CacheZone, log types.Logger) (*Disk, error) {
 		filePermissions:    0600,              //!TODO: get from the config
 		skipCacheKeyInPath: cfg.SkipCacheKeyInPath,
 	}
+	if cfg.MetadataCacheSize > 0 {
+		s.metadata = newMetadataCache(int(cfg.MetadataCacheSize))
+	}
 	s.SetLogger(log)
 
 	return s, s.saveSettingsOnDisk(cfg)
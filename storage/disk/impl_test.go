@@ -2,11 +2,13 @@ package disk
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"strings"
@@ -102,6 +104,14 @@ func savePart(t *testing.T, d *Disk, idx *types.ObjectIndex, contents string) {
 	} else if string(readContents) != contents {
 		t.Errorf("Expected the contents to be %s but read %s", contents, readContents)
 	}
+
+	checksum := types.NewPartHash()
+	_, _ = checksum.Write([]byte(contents))
+	if readChecksum, err := d.GetPartChecksum(idx); err != nil {
+		t.Errorf("Received unexpected error while getting the part checksum: %s", err)
+	} else if readChecksum != checksum.Sum32() {
+		t.Errorf("Expected the checksum to be %08x but read %08x", checksum.Sum32(), readChecksum)
+	}
 }
 
 func TestBasicOperations(t *testing.T) {
@@ -141,6 +151,9 @@ func TestBasicOperations(t *testing.T) {
 	} else if len(parts) > 0 {
 		t.Errorf("Should not have got parts but received %#v", parts)
 	}
+	if _, err := d.GetPartChecksum(idx); !os.IsNotExist(err) {
+		t.Errorf("The checksum should have been discarded with the part, but got %#v", err)
+	}
 
 	if err := d.Discard(obj3.ID); err != nil {
 		t.Errorf("Received unexpected error while discarding object: %s", err)
@@ -148,6 +161,25 @@ func TestBasicOperations(t *testing.T) {
 	iteratorTester(t, d, iterResMap{}) // Test that there is nothing left
 }
 
+func TestPartChecksum(t *testing.T) {
+	t.Parallel()
+	d, _, cleanup := getTestDiskStorage(t, 10)
+	defer cleanup()
+
+	idx := &types.ObjectIndex{ObjID: obj1.ID, Part: 2}
+	saveMetadata(t, d, obj1)
+	savePart(t, d, idx, "123456789")
+	// the CRC32C check value
+	if checksum, err := d.GetPartChecksum(idx); err != nil || checksum != 0xe3069283 {
+		t.Errorf("Expected the checksum to be e3069283 but got %08x (%v)", checksum, err)
+	}
+	checkFile(t, d, d.getPartChecksumPath(idx), "e3069283")
+
+	if parts, err := d.GetAvailableParts(obj1.ID); err != nil || len(parts) != 1 {
+		t.Errorf("Expected the checksum not to be an available part but got %v (%v)", parts, err)
+	}
+}
+
 type iterResVal struct {
 	obj            types.ObjectMetadata
 	parts          []*types.ObjectIndex
@@ -302,6 +334,98 @@ func TestIterationErrors(t *testing.T) {
 	)
 }
 
+func TestIterationMigratesLegacyMetadata(t *testing.T) {
+	t.Parallel()
+	d, _, cleanup := getTestDiskStorage(t, 10)
+	defer cleanup()
+
+	saveMetadata(t, d, obj1)
+	saveMetadata(t, d, obj2)
+	legacyJSON, err := json.Marshal(obj2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutils.ShouldntFail(t,
+		ioutil.WriteFile(d.getObjectMetadataPath(obj2.ID), legacyJSON, d.filePermissions))
+
+	for i := 0; i < 2; i++ {
+		iteratorTester(t, d, iterResMap{
+			*obj1.ID: newIterResVal(*obj1, true),
+			*obj2.ID: newIterResVal(*obj2, true),
+		})
+		for _, obj := range []*types.ObjectMetadata{obj1, obj2} {
+			if _, legacy, err := d.readObjectMetadata(d.getObjectMetadataPath(obj.ID)); err != nil {
+				t.Errorf("Received unexpected error while reading the metadata of %s: %s", obj.ID, err)
+			} else if legacy {
+				t.Errorf("Expected the metadata of %s to be migrated to the binary format", obj.ID)
+			}
+		}
+	}
+}
+
+func TestMigrationDoesNotOverwriteNewerMetadata(t *testing.T) {
+	t.Parallel()
+	d, _, cleanup := getTestDiskStorage(t, 10)
+	defer cleanup()
+
+	writeLegacy := func(obj *types.ObjectMetadata) {
+		legacyJSON, err := json.Marshal(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := d.getObjectMetadataPath(obj.ID)
+		testutils.ShouldntFail(t,
+			os.MkdirAll(filepath.Dir(path), d.dirPermissions),
+			ioutil.WriteFile(path, legacyJSON, d.filePermissions))
+	}
+	// migrate starts a migration which waits for the metadata lock after it
+	// has read the legacy metadata, while change holds it
+	migrate := func(obj *types.ObjectMetadata, change func()) (*types.ObjectMetadata, bool, error) {
+		type result struct {
+			obj      *types.ObjectMetadata
+			migrated bool
+			err      error
+		}
+		done := make(chan result)
+		unlock := d.lockMetadata(obj.ID)
+		go func() {
+			obj, migrated, err := d.migrateMetadata(d.getObjectMetadataPath(obj.ID))
+			done <- result{obj, migrated, err}
+		}()
+		time.Sleep(50 * time.Millisecond)
+		change()
+		unlock()
+		res := <-done
+		return res.obj, res.migrated, res.err
+	}
+
+	writeLegacy(obj1)
+	newer := *obj1
+	newer.ResponseTimestamp++
+	obj, migrated, err := migrate(obj1, func() {
+		testutils.ShouldntFail(t, d.saveMetadata(&newer))
+	})
+	if err != nil || migrated {
+		t.Errorf("Expected the newer metadata not to be migrated but got %t, %v", migrated, err)
+	}
+	if obj == nil || obj.ResponseTimestamp != newer.ResponseTimestamp {
+		t.Errorf("Expected the newer metadata to be returned but got %#v", obj)
+	}
+	if stored, err := d.GetMetadata(obj1.ID); err != nil || stored.ResponseTimestamp != newer.ResponseTimestamp {
+		t.Errorf("Expected the newer metadata to be kept on the disk but got %#v, %v", stored, err)
+	}
+
+	writeLegacy(obj2)
+	if _, _, err := migrate(obj2, func() {
+		testutils.ShouldntFail(t, os.RemoveAll(d.getObjectIDPath(obj2.ID)))
+	}); !os.IsNotExist(err) {
+		t.Errorf("Expected the migration of the discarded object to fail but got %v", err)
+	}
+	if _, err := os.Stat(d.getObjectIDPath(obj2.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected the discarded object not to be written again but got %v", err)
+	}
+}
+
 func TestConcurrentSaves(t *testing.T) {
 	t.Parallel()
 	cpus := runtime.NumCPU()
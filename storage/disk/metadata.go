@@ -0,0 +1,253 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/ironsmile/nedomi/types"
+)
+
+// The binary metadata files start with a magic string and the version of their
+// format, which is followed by the encoded fields of the object and a CRC32C
+// checksum of everything before it. Integers are encoded as varints and
+// strings and slices are prefixed with their length. The second version adds
+// the upstream request of the object after its tags.
+const (
+	metadataMagic   = "NDMD"
+	metadataVersion = 2
+
+	metadataHeaderSize   = len(metadataMagic) + 1
+	metadataChecksumSize = 4
+)
+
+// encodeMetadata returns the binary representation of the object metadata.
+func encodeMetadata(m *types.ObjectMetadata) []byte {
+	e := &metadataEncoder{buf: make([]byte, 0, 256)}
+	e.buf = append(e.buf, metadataMagic...)
+	e.buf = append(e.buf, metadataVersion)
+
+	e.putString(m.ID.CacheKey())
+	e.putString(m.ID.Path())
+	e.putString(m.ID.Variant())
+	e.putVarint(m.ResponseTimestamp)
+	e.putVarint(int64(m.Code))
+	e.putUvarint(m.Size)
+	e.putHeaders(m.Headers)
+	e.putVarint(m.ExpiresAt)
+	e.putVarint(m.StaleWhileRevalidate)
+	e.putVarint(m.StaleIfError)
+	e.putStrings(m.Vary)
+	e.putStrings(m.Variants)
+	e.putStrings(m.Tags)
+	e.putString(m.RequestURL)
+	e.putHeaders(m.RequestHeaders)
+
+	checksum := types.NewPartHash()
+	_, _ = checksum.Write(e.buf)
+	return checksum.Sum(e.buf)
+}
+
+// decodeMetadata parses the object metadata from its binary representation
+// or from the JSON one which was used by the older versions of the disk
+// storage. The returned boolean is true for the latter.
+func decodeMetadata(data []byte) (*types.ObjectMetadata, bool, error) {
+	if !bytes.HasPrefix(data, []byte(metadataMagic)) {
+		obj := &types.ObjectMetadata{}
+		if err := json.Unmarshal(data, obj); err != nil {
+			return nil, true, err
+		}
+		if obj.ID == nil {
+			return nil, true, fmt.Errorf("The legacy metadata has no object ID")
+		}
+		return obj, true, nil
+	}
+
+	if len(data) < metadataHeaderSize+metadataChecksumSize {
+		return nil, false, fmt.Errorf("The metadata is truncated")
+	}
+	version := data[len(metadataMagic)]
+	if version < 1 || version > metadataVersion {
+		return nil, false, fmt.Errorf("Unsupported metadata version %d", version)
+	}
+
+	contents := data[:len(data)-metadataChecksumSize]
+	checksum := types.NewPartHash()
+	_, _ = checksum.Write(contents)
+	expected := binary.BigEndian.Uint32(data[len(contents):])
+	if actual := checksum.Sum32(); actual != expected {
+		return nil, false, fmt.Errorf("The checksum of the metadata is %08x instead of %08x", actual, expected)
+	}
+
+	d := &metadataDecoder{buf: contents[metadataHeaderSize:]}
+	cacheKey, path, variant := d.string(), d.string(), d.string()
+	obj := &types.ObjectMetadata{
+		ResponseTimestamp:    d.varint(),
+		Code:                 int(d.varint()),
+		Size:                 d.uvarint(),
+		Headers:              d.headers(),
+		ExpiresAt:            d.varint(),
+		StaleWhileRevalidate: d.varint(),
+		StaleIfError:         d.varint(),
+		Vary:                 d.strings(),
+		Variants:             d.strings(),
+		Tags:                 d.strings(),
+	}
+	if version >= 2 {
+		obj.RequestURL = d.string()
+		obj.RequestHeaders = d.headers()
+	}
+	if d.err == nil && len(d.buf) != 0 {
+		d.err = fmt.Errorf("The metadata has %d unexpected trailing bytes", len(d.buf))
+	}
+	if d.err != nil {
+		return nil, false, d.err
+	}
+
+	if cacheKey == "" || path == "" {
+		return nil, false, fmt.Errorf("Invalid object ID %q %q", cacheKey, path)
+	} else if variant != "" {
+		obj.ID = types.NewVariantObjectID(cacheKey, path, variant)
+	} else {
+		obj.ID = types.NewObjectID(cacheKey, path)
+	}
+	return obj, false, nil
+}
+
+type metadataEncoder struct {
+	buf     []byte
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (e *metadataEncoder) putUvarint(v uint64) {
+	e.buf = append(e.buf, e.scratch[:binary.PutUvarint(e.scratch[:], v)]...)
+}
+
+func (e *metadataEncoder) putVarint(v int64) {
+	e.buf = append(e.buf, e.scratch[:binary.PutVarint(e.scratch[:], v)]...)
+}
+
+func (e *metadataEncoder) putString(s string) {
+	e.putUvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// putStrings encodes the length of the slice plus one, so that the nil slices
+// are told apart from the empty ones when they are decoded.
+func (e *metadataEncoder) putStrings(s []string) {
+	if s == nil {
+		e.putUvarint(0)
+		return
+	}
+	e.putUvarint(uint64(len(s)) + 1)
+	for _, v := range s {
+		e.putString(v)
+	}
+}
+
+// putHeaders encodes the headers sorted by their names, so that the same
+// metadata is always encoded the same way.
+func (e *metadataEncoder) putHeaders(h http.Header) {
+	if h == nil {
+		e.putUvarint(0)
+		return
+	}
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	e.putUvarint(uint64(len(names)) + 1)
+	for _, name := range names {
+		e.putString(name)
+		e.putStrings(h[name])
+	}
+}
+
+// metadataDecoder reads the fields of the binary metadata. After the first
+// error it returns zero values and the error is kept in err.
+type metadataDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *metadataDecoder) fail() {
+	if d.err == nil {
+		d.err = fmt.Errorf("The metadata is truncated or malformed")
+	}
+	d.buf = nil
+}
+
+func (d *metadataDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *metadataDecoder) varint() int64 {
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *metadataDecoder) string() string {
+	length := d.uvarint()
+	if length > uint64(len(d.buf)) {
+		d.fail()
+		return ""
+	}
+	s := string(d.buf[:length])
+	d.buf = d.buf[length:]
+	return s
+}
+
+// count returns the number of the elements in a slice or a map and whether it
+// is nil. Every element takes at least one byte, which limits the allocations
+// for malformed data.
+func (d *metadataDecoder) count() (int, bool) {
+	count := d.uvarint()
+	if count == 0 {
+		return 0, true
+	} else if count-1 > uint64(len(d.buf)) {
+		d.fail()
+		return 0, true
+	}
+	return int(count - 1), false
+}
+
+func (d *metadataDecoder) strings() []string {
+	count, isNil := d.count()
+	if isNil {
+		return nil
+	}
+	s := make([]string, count)
+	for i := range s {
+		s[i] = d.string()
+	}
+	return s
+}
+
+func (d *metadataDecoder) headers() http.Header {
+	count, isNil := d.count()
+	if isNil {
+		return nil
+	}
+	h := make(http.Header, count)
+	for i := 0; i < count; i++ {
+		name := d.string()
+		h[name] = d.strings()
+	}
+	return h
+}
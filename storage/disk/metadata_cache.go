@@ -0,0 +1,128 @@
+package disk
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils"
+)
+
+// metadataCache keeps the metadata of the most recently used objects in
+// memory, so that it is not read from the disk for every request. It holds
+// at most limit objects and evicts the least recently used ones.
+type metadataCache struct {
+	// they are first in order to be aligned for the atomic operations
+	hits, requests uint64
+
+	sync.Mutex
+	limit   int
+	lru     *list.List // of *types.ObjectMetadata, the most recent at the front
+	objects map[types.ObjectIDHash]*list.Element
+
+	// version is incremented on every invalidation and the objects which
+	// were invalidated are recorded in removed with the version at the time.
+	// The metadata which was read from the disk is cached only if its object
+	// was not invalidated since the reading started, as it may be older than
+	// the one on the disk. At most limit invalidations are recorded and the
+	// metadata which was read before the forgotten ones is not cached.
+	version  uint64
+	removed  map[types.ObjectIDHash]uint64
+	removals *list.List // of removal, the oldest at the front
+	forgot   uint64
+}
+
+type removal struct {
+	hash    types.ObjectIDHash
+	version uint64
+}
+
+func newMetadataCache(limit int) *metadataCache {
+	return &metadataCache{
+		limit:    limit,
+		lru:      list.New(),
+		objects:  make(map[types.ObjectIDHash]*list.Element),
+		removed:  make(map[types.ObjectIDHash]uint64),
+		removals: list.New(),
+	}
+}
+
+// get returns a copy of the cached metadata of the object if it is there and
+// it has not expired. The expired metadata is removed from the cache.
+func (c *metadataCache) get(id *types.ObjectID) (*types.ObjectMetadata, uint64) {
+	atomic.AddUint64(&c.requests, 1)
+	c.Lock()
+	defer c.Unlock()
+	elem, ok := c.objects[id.Hash()]
+	if !ok {
+		return nil, c.version
+	}
+
+	obj := elem.Value.(*types.ObjectMetadata)
+	if !utils.IsMetadataFresh(obj) {
+		c.removeElement(elem)
+		return nil, c.version
+	}
+	c.lru.MoveToFront(elem)
+	atomic.AddUint64(&c.hits, 1)
+	return utils.CopyMetadata(obj), c.version
+}
+
+// add caches a copy of the metadata which was read from the disk when the
+// cache was at the specified version.
+func (c *metadataCache) add(obj *types.ObjectMetadata, version uint64) {
+	if !utils.IsMetadataFresh(obj) {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if version < c.forgot || version < c.removed[obj.ID.Hash()] {
+		return
+	}
+	if elem, ok := c.objects[obj.ID.Hash()]; ok {
+		elem.Value = utils.CopyMetadata(obj)
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.objects[obj.ID.Hash()] = c.lru.PushFront(utils.CopyMetadata(obj))
+	for c.lru.Len() > c.limit {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// remove invalidates the cached metadata of the object.
+func (c *metadataCache) remove(id *types.ObjectID) {
+	c.Lock()
+	defer c.Unlock()
+	c.version++
+	c.removed[id.Hash()] = c.version
+	c.removals.PushBack(removal{hash: id.Hash(), version: c.version})
+	for c.removals.Len() > c.limit {
+		oldest := c.removals.Remove(c.removals.Front()).(removal)
+		if c.removed[oldest.hash] == oldest.version {
+			delete(c.removed, oldest.hash)
+		}
+		c.forgot = oldest.version
+	}
+	if elem, ok := c.objects[id.Hash()]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *metadataCache) removeElement(elem *list.Element) {
+	delete(c.objects, elem.Value.(*types.ObjectMetadata).ID.Hash())
+	c.lru.Remove(elem)
+}
+
+func (c *metadataCache) len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.lru.Len()
+}
+
+func (c *metadataCache) stats() (hits, requests uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.requests)
+}
@@ -0,0 +1,180 @@
+package disk
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/config"
+	"github.com/ironsmile/nedomi/mock"
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func freshObject(path string) *types.ObjectMetadata {
+	return &types.ObjectMetadata{
+		ID:        types.NewObjectID("cached", path),
+		Headers:   http.Header{"Etag": {`"first"`}},
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func expectStats(t *testing.T, s types.MetadataCaching, expectedHits, expectedRequests uint64) {
+	if hits, requests := s.MetadataCacheStats(); hits != expectedHits || requests != expectedRequests {
+		t.Errorf("Expected %d hits out of %d requests but got %d out of %d",
+			expectedHits, expectedRequests, hits, requests)
+	}
+}
+
+func TestMetadataCacheIsBounded(t *testing.T) {
+	t.Parallel()
+	c := newMetadataCache(2)
+	objs := []*types.ObjectMetadata{freshObject("/1"), freshObject("/2"), freshObject("/3")}
+	for _, obj := range objs[:2] {
+		_, version := c.get(obj.ID)
+		c.add(obj, version)
+	}
+	if obj, _ := c.get(objs[0].ID); obj == nil {
+		t.Errorf("Expected %s to be cached", objs[0].ID)
+	}
+
+	_, version := c.get(objs[2].ID)
+	c.add(objs[2], version)
+	if c.len() != 2 {
+		t.Errorf("Expected the cache to have 2 objects but it has %d", c.len())
+	}
+	if obj, _ := c.get(objs[1].ID); obj != nil {
+		t.Errorf("Expected the least recently used %s to be evicted", objs[1].ID)
+	}
+	for _, obj := range []*types.ObjectMetadata{objs[0], objs[2]} {
+		if cached, _ := c.get(obj.ID); cached == nil {
+			t.Errorf("Expected %s to be cached", obj.ID)
+		}
+	}
+}
+
+func TestMetadataCacheExpiration(t *testing.T) {
+	t.Parallel()
+	c := newMetadataCache(10)
+	expired := freshObject("/expired")
+	expired.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	c.add(expired, 0)
+	if c.len() != 0 {
+		t.Error("Expected the expired metadata not to be cached")
+	}
+
+	obj := freshObject("/expiring")
+	c.add(obj, 0)
+	c.objects[obj.ID.Hash()].Value.(*types.ObjectMetadata).ExpiresAt = expired.ExpiresAt
+	if cached, _ := c.get(obj.ID); cached != nil {
+		t.Errorf("Expected the expired metadata not to be returned but got %#v", cached)
+	}
+	if c.len() != 0 {
+		t.Error("Expected the expired metadata to be removed from the cache")
+	}
+}
+
+func TestMetadataCacheInvalidation(t *testing.T) {
+	t.Parallel()
+	c := newMetadataCache(10)
+	obj, other := freshObject("/obj"), freshObject("/other")
+
+	// the metadata which was read before its invalidation may be outdated
+	_, version := c.get(obj.ID)
+	c.remove(obj.ID)
+	c.add(obj, version)
+	if cached, _ := c.get(obj.ID); cached != nil {
+		t.Error("Expected the metadata which was read before its invalidation not to be cached")
+	}
+
+	// the invalidations of the other objects do not matter
+	_, version = c.get(obj.ID)
+	c.remove(other.ID)
+	c.add(obj, version)
+	if cached, _ := c.get(obj.ID); cached == nil {
+		t.Error("Expected the metadata which was read before an invalidation of another object to be cached")
+	}
+	c.remove(obj.ID)
+	if cached, _ := c.get(obj.ID); cached != nil {
+		t.Error("Expected the removed metadata not to be cached")
+	}
+}
+
+func TestMetadataCacheInvalidationsAreBounded(t *testing.T) {
+	t.Parallel()
+	c := newMetadataCache(2)
+	obj := freshObject("/obj")
+	_, version := c.get(obj.ID)
+	c.remove(obj.ID)
+	for _, path := range []string{"/1", "/2", "/3"} {
+		c.remove(freshObject(path).ID)
+	}
+	if len(c.removed) != 2 || c.removals.Len() != 2 {
+		t.Errorf("Expected 2 invalidations to be recorded but there are %d", len(c.removed))
+	}
+
+	// the invalidation of obj is forgotten, which must not make it cacheable
+	c.add(obj, version)
+	if cached, _ := c.get(obj.ID); cached != nil {
+		t.Error("Expected the metadata which was read before a forgotten invalidation not to be cached")
+	}
+
+	_, version = c.get(obj.ID)
+	c.add(obj, version)
+	if cached, _ := c.get(obj.ID); cached == nil {
+		t.Error("Expected the metadata which was read after the invalidations to be cached")
+	}
+}
+
+func TestDiskMetadataCache(t *testing.T) {
+	t.Parallel()
+	diskPath, cleanup := testutils.GetTestFolder(t)
+	defer cleanup()
+	d, err := New(&config.CacheZone{Path: diskPath, PartSize: 10, MetadataCacheSize: 10}, mock.NewLogger())
+	if err != nil {
+		t.Fatalf("Could not create storage: %s", err)
+	}
+
+	obj := freshObject("/disk")
+	saveMetadata(t, d, obj)
+	expectStats(t, d, 0, 1)
+	read, err := d.GetMetadata(obj.ID)
+	if err != nil {
+		t.Fatalf("Received unexpected error while getting metadata: %s", err)
+	}
+	expectStats(t, d, 1, 2)
+
+	// the cached metadata is not shared with the callers
+	read.Headers.Set("Etag", `"modified"`)
+	if read, err = d.GetMetadata(obj.ID); err != nil || read.Headers.Get("Etag") != `"first"` {
+		t.Errorf("Expected the cached metadata to be unchanged but got %v and %v", read, err)
+	}
+	expectStats(t, d, 2, 3)
+
+	obj.Headers.Set("Etag", `"second"`)
+	saveMetadata(t, d, obj)
+	expectStats(t, d, 2, 4)
+
+	if err := d.Discard(obj.ID); err != nil {
+		t.Fatalf("Received unexpected error while discarding: %s", err)
+	}
+	if _, err := d.GetMetadata(obj.ID); !os.IsNotExist(err) {
+		t.Errorf("Expected a not exist error for the discarded object but got %v", err)
+	}
+	expectStats(t, d, 2, 5)
+}
+
+func TestDiskWithoutMetadataCache(t *testing.T) {
+	t.Parallel()
+	d, _, cleanup := getTestDiskStorage(t, 10)
+	defer cleanup()
+
+	obj := freshObject("/uncached")
+	saveMetadata(t, d, obj)
+	saveMetadata(t, d, obj)
+	if d.metadata != nil {
+		t.Error("Expected the metadata cache to be disabled")
+	}
+	expectStats(t, d, 0, 0)
+}
@@ -0,0 +1,157 @@
+package disk
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/ironsmile/nedomi/types"
+)
+
+var fullObj = &types.ObjectMetadata{
+	ID:                   types.NewVariantObjectID("full", "/full/object?with=query", "gzip"),
+	ResponseTimestamp:    1450000000,
+	Code:                 http.StatusPartialContent,
+	Size:                 1 << 40,
+	Headers:              http.Header{"Etag": {`"tag"`}, "Empty": {}, "Nil": nil, "Many": {"1", "2"}},
+	ExpiresAt:            -1,
+	StaleWhileRevalidate: 30,
+	StaleIfError:         600,
+	Vary:                 []string{"Accept-Encoding"},
+	Variants:             []string{},
+	Tags:                 []string{"one", "", "three"},
+	RequestURL:           "//example.com/full/object?with=query",
+	RequestHeaders:       http.Header{"Accept-Encoding": {"gzip"}},
+}
+
+func TestMetadataEncoding(t *testing.T) {
+	t.Parallel()
+	for _, obj := range []*types.ObjectMetadata{fullObj, obj1, {ID: types.NewObjectID("empty", "/")}} {
+		data := encodeMetadata(obj)
+		decoded, legacy, err := decodeMetadata(data)
+		if err != nil {
+			t.Errorf("Received unexpected error while decoding %s: %s", obj.ID, err)
+		} else if legacy {
+			t.Errorf("Expected %s not to be decoded as legacy metadata", obj.ID)
+		} else if !reflect.DeepEqual(decoded, obj) {
+			t.Errorf("Original and decoded objects differ: '%#v', '%#v'", obj, decoded)
+		}
+	}
+}
+
+func TestFirstVersionMetadataDecoding(t *testing.T) {
+	t.Parallel()
+	obj := *fullObj
+	obj.RequestURL, obj.RequestHeaders = "", nil
+	// the first version is the same without the empty request URL and the
+	// nil request headers at the end
+	data := encodeMetadata(&obj)
+	data = data[:len(data)-metadataChecksumSize-2]
+	data[len(metadataMagic)] = 1
+	checksum := types.NewPartHash()
+	_, _ = checksum.Write(data)
+
+	decoded, legacy, err := decodeMetadata(checksum.Sum(data))
+	if err != nil {
+		t.Fatalf("Received unexpected error while decoding the first version: %s", err)
+	} else if legacy {
+		t.Error("Expected the first version not to be decoded as legacy metadata")
+	} else if !reflect.DeepEqual(decoded, &obj) {
+		t.Errorf("Original and decoded objects differ: '%#v', '%#v'", &obj, decoded)
+	}
+}
+
+func TestLegacyMetadataDecoding(t *testing.T) {
+	t.Parallel()
+	data, err := json.Marshal(fullObj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, legacy, err := decodeMetadata(data)
+	if err != nil {
+		t.Fatalf("Received unexpected error while decoding the legacy metadata: %s", err)
+	} else if !legacy {
+		t.Error("Expected the JSON metadata to be decoded as legacy")
+	} else if decoded.ID.Hash() != fullObj.ID.Hash() || decoded.ExpiresAt != fullObj.ExpiresAt {
+		t.Errorf("Original and decoded objects differ: '%#v', '%#v'", fullObj, decoded)
+	}
+
+	for _, data := range []string{"", "{}", "wrong json!", `{"ID": ["key"]}`} {
+		if _, _, err := decodeMetadata([]byte(data)); err == nil {
+			t.Errorf("Expected an error for the legacy metadata '%s'", data)
+		}
+	}
+}
+
+func TestInvalidMetadataDecoding(t *testing.T) {
+	t.Parallel()
+	var valid = encodeMetadata(fullObj)
+	var withChecksum = func(data []byte) []byte {
+		checksum := types.NewPartHash()
+		_, _ = checksum.Write(data)
+		return checksum.Sum(data)
+	}
+	var modified = func(modify func([]byte) []byte) []byte {
+		return modify(append([]byte{}, valid...))
+	}
+
+	var tests = map[string][]byte{
+		"only the magic": []byte(metadataMagic),
+		"truncated":      valid[:len(valid)/2],
+		"no checksum":    valid[:len(valid)-metadataChecksumSize],
+		"flipped bit": modified(func(data []byte) []byte {
+			data[len(data)/2] ^= 0x01
+			return data
+		}),
+		"unknown version": modified(func(data []byte) []byte {
+			data[len(metadataMagic)] = metadataVersion + 1
+			return withChecksum(data[:len(data)-metadataChecksumSize])
+		}),
+		"zero version": modified(func(data []byte) []byte {
+			data[len(metadataMagic)] = 0
+			return withChecksum(data[:len(data)-metadataChecksumSize])
+		}),
+		"truncated fields": withChecksum(valid[:len(valid)/2]),
+		"trailing bytes": modified(func(data []byte) []byte {
+			return withChecksum(append(data[:len(data)-metadataChecksumSize], 0))
+		}),
+		"huge string slice": withChecksum(append([]byte(metadataMagic), metadataVersion,
+			1, 'k', 1, 'p', 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0x0f)),
+		"no object ID": withChecksum(encodeMetadata(&types.ObjectMetadata{
+			ID: types.NewObjectID("", "/")})[:len(valid)-metadataChecksumSize]),
+	}
+	tests["no object ID"] = withChecksum(append([]byte(metadataMagic), metadataVersion,
+		0, 1, '/', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0))
+
+	for name, data := range tests {
+		if obj, _, err := decodeMetadata(data); err == nil {
+			t.Errorf("Expected an error for %s metadata but got '%#v'", name, obj)
+		}
+	}
+}
+
+func BenchmarkMetadataDecoding(b *testing.B) {
+	data := encodeMetadata(fullObj)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := decodeMetadata(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLegacyMetadataDecoding(b *testing.B) {
+	data, err := json.Marshal(fullObj)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := decodeMetadata(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -17,6 +18,7 @@ import (
 const (
 	objectMetadataFileName = "objID"
 	diskSettingsFileName   = ".nedomi-cache-storage"
+	partChecksumFileSuffix = ".crc32c"
 )
 
 func getPartFilename(part uint32) string {
@@ -49,6 +51,27 @@ func (s *Disk) getObjectIndexPath(idx *types.ObjectIndex) string {
 	return filepath.Join(s.getObjectIDPath(idx.ObjID), getPartFilename(idx.Part))
 }
 
+func (s *Disk) getPartChecksumPath(idx *types.ObjectIndex) string {
+	return s.getObjectIndexPath(idx) + partChecksumFileSuffix
+}
+
+func (s *Disk) savePartChecksum(idx *types.ObjectIndex, checksum uint32) error {
+	filePath := s.getPartChecksumPath(idx)
+	tmpPath := appendRandomSuffix(filePath)
+	f, err := s.createFile(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(f, "%08x", checksum); err != nil {
+		return utils.NewCompositeError(err, f.Close(), os.Remove(tmpPath))
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, filePath)
+}
+
 func (s *Disk) getObjectMetadataPath(id *types.ObjectID) string {
 	return filepath.Join(s.getObjectIDPath(id), objectMetadataFileName)
 }
@@ -87,24 +110,29 @@ func (s *Disk) getPartNumberFromFile(name string) (uint32, error) {
 }
 
 func (s *Disk) getObjectMetadata(objPath string) (*types.ObjectMetadata, error) {
-	f, err := os.Open(objPath)
+	obj, _, err := s.readObjectMetadata(objPath)
+	return obj, err
+}
+
+// readObjectMetadata reads the metadata file in either of the formats. The
+// returned boolean is true if it is in the legacy JSON one.
+func (s *Disk) readObjectMetadata(objPath string) (*types.ObjectMetadata, bool, error) {
+	data, err := ioutil.ReadFile(objPath)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	obj := &types.ObjectMetadata{}
-	if err := json.NewDecoder(f).Decode(&obj); err != nil {
-		return nil, utils.NewCompositeError(err, f.Close())
+	obj, legacy, err := decodeMetadata(data)
+	if err != nil {
+		return nil, legacy, err
 	}
 
 	if filepath.Base(filepath.Dir(objPath)) != obj.ID.StrHash() {
-		err := fmt.Errorf("The object %s was in the wrong directory: %s", obj.ID, objPath)
-		return nil, utils.NewCompositeError(err, f.Close())
+		return nil, legacy, fmt.Errorf("The object %s was in the wrong directory: %s", obj.ID, objPath)
 	}
-	//!TODO: add more validation? ex. compare the cache key as well? also the
-	// data itself may be corrupted or from an old app version
+	//!TODO: add more validation? ex. compare the cache key as well?
 
-	return obj, f.Close()
+	return obj, legacy, nil
 }
 
 func (s *Disk) checkPreviousDiskSettings(newSettings *config.CacheZone) error {
@@ -1,8 +1,11 @@
 package disk
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -197,7 +200,38 @@ func TestPartNumberValidation(t *testing.T) {
 
 func TestObjectMetadataLoading(t *testing.T) {
 	t.Parallel()
-	t.Skip("TODO: write tests for getObjectMetadata()")
+	d, _, cleanup := getTestDiskStorage(t, 10)
+	defer cleanup()
+
+	legacyJSON, err := json.Marshal(obj2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string][]byte{"binary": encodeMetadata(obj2), "legacy": legacyJSON} {
+		objPath := d.getObjectMetadataPath(obj2.ID)
+		testutils.ShouldntFail(t,
+			os.MkdirAll(filepath.Dir(objPath), d.dirPermissions),
+			ioutil.WriteFile(objPath, contents, d.filePermissions))
+		if obj, legacy, err := d.readObjectMetadata(objPath); err != nil {
+			t.Errorf("Received unexpected error while reading the %s metadata: %s", name, err)
+		} else if legacy != (name == "legacy") {
+			t.Errorf("Expected the %s metadata to be legacy: %t", name, legacy)
+		} else if !reflect.DeepEqual(*obj, *obj2) {
+			t.Errorf("Original and read %s objects differ: '%#v', '%#v'", name, obj2, obj)
+		}
+
+		wrongPath := d.getObjectMetadataPath(obj1.ID)
+		testutils.ShouldntFail(t,
+			os.MkdirAll(filepath.Dir(wrongPath), d.dirPermissions),
+			os.Rename(objPath, wrongPath))
+		if _, err := d.getObjectMetadata(wrongPath); err == nil {
+			t.Errorf("Expected an error for the %s metadata in the wrong directory", name)
+		}
+	}
+
+	if _, err := d.getObjectMetadata(d.getObjectMetadataPath(obj3.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected a not exist error for missing metadata but got %v", err)
+	}
 }
 
 func TestDiskSettingsLoadAndSave(t *testing.T) {
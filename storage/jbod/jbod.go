@@ -0,0 +1,355 @@
+// Package jbod contains a storage implementation which spreads the objects of
+// a cache zone across multiple disks, without the need of RAID.
+package jbod
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/ironsmile/nedomi/config"
+	"github.com/ironsmile/nedomi/storage/disk"
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/upstream/balancing"
+)
+
+// DefaultBalancing is the algorithm which places the objects on the disks
+// when the cache zone does not set one. Removing a disk from rendezvous
+// hashing moves only the objects which were on it.
+const DefaultBalancing = "rendezvous"
+
+// The balancing algorithms which always place an object on the same disk.
+var hashingAlgorithms = map[string]bool{
+	"ketama":       true,
+	"legacyketama": true,
+	"rendezvous":   true,
+}
+
+var errNoDisks = errors.New("all disks of the storage have failed")
+
+// JBOD implements the Storage interface with a disk storage for each of the
+// paths of the cache zone. Each object is placed on one of the disks by
+// hashing its ID with an upstream balancing algorithm. A disk which fails is
+// taken out of rotation until the storage is created again and the objects
+// on it are treated as missing.
+type JBOD struct {
+	types.SyncLogger
+	partSize uint64
+
+	sync.RWMutex
+	// the disks in rotation by their paths
+	disks    map[string]*disk.Disk
+	balancer types.UpstreamBalancingAlgorithm
+}
+
+// PartSize the maximum part size for the storage.
+func (s *JBOD) PartSize() uint64 {
+	return s.partSize
+}
+
+// GetMetadata returns the metadata for this object from its disk, if present.
+func (s *JBOD) GetMetadata(id *types.ObjectID) (*types.ObjectMetadata, error) {
+	path, d, err := s.getDisk(id)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	obj, err := d.GetMetadata(id)
+	return obj, s.checkError(path, err)
+}
+
+// GetPart returns an io.ReadCloser that will read the specified part of the
+// object from its disk.
+func (s *JBOD) GetPart(idx *types.ObjectIndex) (io.ReadCloser, error) {
+	path, d, err := s.getDisk(idx.ObjID)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	r, err := d.GetPart(idx)
+	return r, s.checkError(path, err)
+}
+
+// GetAvailableParts returns types.ObjectIndexMap including all the available
+// parts of for the object specified by the provided objectMetadata
+func (s *JBOD) GetAvailableParts(id *types.ObjectID) ([]*types.ObjectIndex, error) {
+	path, d, err := s.getDisk(id)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	parts, err := d.GetAvailableParts(id)
+	return parts, s.checkError(path, err)
+}
+
+// SaveMetadata writes the supplied metadata to the disk of the object. If
+// the disk fails, the metadata is written to the next disk for the object.
+func (s *JBOD) SaveMetadata(m *types.ObjectMetadata) error {
+	for {
+		path, d, err := s.getDisk(m.ID)
+		if err != nil {
+			return err
+		}
+		// each retry is on a disk less, so this ends
+		if err = d.SaveMetadata(m); err == nil || !s.takeOutIfFailed(path, err) {
+			return err
+		}
+	}
+}
+
+// SavePart writes the contents of the supplied object part to its disk.
+func (s *JBOD) SavePart(idx *types.ObjectIndex, data io.Reader) error {
+	path, d, err := s.getDisk(idx.ObjID)
+	if err != nil {
+		return err
+	}
+	return s.checkError(path, d.SavePart(idx, data))
+}
+
+// GetPartChecksum returns the checksum of the specified part which was
+// recorded when it was saved to its disk.
+func (s *JBOD) GetPartChecksum(idx *types.ObjectIndex) (uint32, error) {
+	path, d, err := s.getDisk(idx.ObjID)
+	if err != nil {
+		return 0, os.ErrNotExist
+	}
+	checksum, err := d.GetPartChecksum(idx)
+	return checksum, s.checkError(path, err)
+}
+
+// Discard removes the object and its metadata from its disk.
+func (s *JBOD) Discard(id *types.ObjectID) error {
+	path, d, err := s.getDisk(id)
+	if err != nil {
+		return os.ErrNotExist
+	}
+	return s.checkError(path, d.Discard(id))
+}
+
+// DiscardPart removes the specified part of an Object from its disk.
+func (s *JBOD) DiscardPart(idx *types.ObjectIndex) error {
+	path, d, err := s.getDisk(idx.ObjID)
+	if err != nil {
+		return os.ErrNotExist
+	}
+	return s.checkError(path, d.DiscardPart(idx))
+}
+
+// Iterate iterates over the objects on all the disks in rotation. The objects
+// which are not on their disk, e.g. because they were saved while it was out
+// of rotation, are discarded instead of being passed to the callback.
+func (s *JBOD) Iterate(callback func(*types.ObjectMetadata, ...*types.ObjectIndex) bool) error {
+	var stopped bool
+	for _, path := range s.Disks() {
+		s.RLock()
+		d, ok := s.disks[path]
+		s.RUnlock()
+		if !ok {
+			continue
+		}
+
+		err := d.Iterate(func(obj *types.ObjectMetadata, parts ...*types.ObjectIndex) bool {
+			if objPath, _, err := s.getDisk(obj.ID); err != nil || objPath != path {
+				s.GetLogger().Logf("[JBODStorage] Discarding %s which is not on its disk %s", obj.ID, objPath)
+				if err := d.Discard(obj.ID); err != nil {
+					s.GetLogger().Errorf("[JBODStorage] Error while discarding %s from %s: %s", obj.ID, path, err)
+				}
+				return true
+			}
+			stopped = !callback(obj, parts...)
+			return !stopped
+		})
+		if err = s.checkError(path, err); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if stopped {
+			return nil
+		}
+	}
+	return nil
+}
+
+// SetLogger changes the logger of the storage and all of its disks.
+func (s *JBOD) SetLogger(logger types.Logger) {
+	s.SyncLogger.SetLogger(logger)
+	s.RLock()
+	defer s.RUnlock()
+	for _, d := range s.disks {
+		d.SetLogger(logger)
+	}
+}
+
+// MetadataCacheStats returns the sums of the metadata cache statistics of
+// the disks which are in rotation.
+func (s *JBOD) MetadataCacheStats() (hits, requests uint64) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, d := range s.disks {
+		diskHits, diskRequests := d.MetadataCacheStats()
+		hits += diskHits
+		requests += diskRequests
+	}
+	return hits, requests
+}
+
+// Disks returns the paths of the disks which are in rotation.
+func (s *JBOD) Disks() []string {
+	s.RLock()
+	defer s.RUnlock()
+	paths := make([]string, 0, len(s.disks))
+	for path := range s.disks {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// getDisk returns the disk on which the object is placed and its path.
+func (s *JBOD) getDisk(id *types.ObjectID) (string, *disk.Disk, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if len(s.disks) == 0 {
+		return "", nil, errNoDisks
+	}
+	address, err := s.balancer.Get(id.StrHash())
+	if err != nil {
+		return "", nil, err
+	}
+	return address.Hostname, s.disks[address.Hostname], nil
+}
+
+// checkError takes the disk out of rotation if the error from it shows that
+// it has failed. The objects on failed disks are treated as missing, so
+// os.ErrNotExist is returned in that case.
+func (s *JBOD) checkError(path string, err error) error {
+	if err != nil && s.takeOutIfFailed(path, err) {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+// takeOutIfFailed takes the disk out of rotation if the error from it shows
+// that it has failed and returns whether it is out of rotation.
+func (s *JBOD) takeOutIfFailed(path string, err error) bool {
+	if os.IsNotExist(err) || !hasFailed(path, err) {
+		return false
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.disks[path]; !ok {
+		// it was already taken out by another operation
+		return true
+	}
+	delete(s.disks, path)
+	s.GetLogger().Errorf("[JBODStorage] Taking disk %s out of rotation, %d disks are left: %s",
+		path, len(s.disks), err)
+	s.balancer.Set(diskAddresses(s.disks))
+	return true
+}
+
+// hasFailed returns whether the error from the disk with the supplied path
+// shows that the disk has failed. The errors which are not specific to the
+// object are checked by looking at the path of the disk.
+func hasFailed(path string, err error) bool {
+	switch errno(err) {
+	case syscall.EIO, syscall.EROFS, syscall.ENODEV, syscall.ENXIO, syscall.ESTALE:
+		return true
+	case syscall.EMFILE, syscall.ENFILE:
+		return false
+	}
+	stat, statErr := os.Stat(path)
+	return statErr != nil || !stat.IsDir()
+}
+
+// errno returns the system error number of the error or 0 if it has none.
+func errno(err error) syscall.Errno {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	if errno, ok := err.(syscall.Errno); ok {
+		return errno
+	}
+	return 0
+}
+
+// diskAddresses returns the disks as addresses for the balancing algorithm,
+// which hashes their paths.
+func diskAddresses(disks map[string]*disk.Disk) []*types.UpstreamAddress {
+	paths := make([]string, 0, len(disks))
+	for path := range disks {
+		paths = append(paths, path)
+	}
+	// the ties between the disks are broken by their order
+	sort.Strings(paths)
+
+	addresses := make([]*types.UpstreamAddress, len(paths))
+	for i, path := range paths {
+		addresses[i] = &types.UpstreamAddress{
+			URL:      url.URL{Host: path},
+			Hostname: path,
+			Weight:   1,
+		}
+	}
+	return addresses
+}
+
+// New returns a new JBOD storage that ready for use. The disks which cannot
+// be used are not put in rotation, but there has to be at least one which
+// can.
+func New(cfg *config.CacheZone, log types.Logger) (*JBOD, error) {
+	if cfg == nil || log == nil {
+		return nil, fmt.Errorf("nil constructor parameters")
+	}
+
+	if cfg.PartSize == 0 {
+		return nil, fmt.Errorf("invalid partSize value")
+	}
+
+	if len(cfg.Paths) == 0 {
+		return nil, fmt.Errorf("jbod storage needs paths")
+	}
+
+	algorithm := cfg.PathsBalancing
+	if algorithm == "" {
+		algorithm = DefaultBalancing
+	}
+	if !hashingAlgorithms[algorithm] {
+		return nil, fmt.Errorf("jbod storage cannot place objects with `%s`", algorithm)
+	}
+	balancer, err := balancing.New(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &JBOD{
+		partSize: cfg.PartSize.Bytes(),
+		disks:    make(map[string]*disk.Disk),
+		balancer: balancer,
+	}
+	s.SyncLogger.SetLogger(log)
+
+	for _, path := range cfg.Paths {
+		diskCfg := *cfg
+		diskCfg.Path = path
+		d, err := disk.New(&diskCfg, log)
+		if err != nil {
+			log.Errorf("[JBODStorage] Not using disk %s: %s", path, err)
+			continue
+		}
+		s.disks[path] = d
+	}
+	if len(s.disks) == 0 {
+		return nil, errNoDisks
+	}
+
+	balancer.Set(diskAddresses(s.disks))
+	return s, nil
+}
@@ -0,0 +1,229 @@
+package jbod
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ironsmile/nedomi/config"
+	"github.com/ironsmile/nedomi/mock"
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+func getTestPaths(t *testing.T, count int) ([]string, func()) {
+	root, cleanup := testutils.GetTestFolder(t)
+	paths := make([]string, count)
+	for i := range paths {
+		paths[i] = filepath.Join(root, fmt.Sprintf("disk%d", i))
+		if err := os.Mkdir(paths[i], 0700); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+	return paths, cleanup
+}
+
+func getTestJBOD(t *testing.T, algorithm string, paths ...string) *JBOD {
+	s, err := New(&config.CacheZone{
+		ID:             "default",
+		Paths:          paths,
+		PathsBalancing: algorithm,
+		PartSize:       10,
+	}, mock.NewLogger())
+	if err != nil {
+		t.Fatalf("Could not create the jbod storage: %s", err)
+	}
+	return s
+}
+
+func saveObjects(t *testing.T, s *JBOD, count int) []*types.ObjectID {
+	ids := make([]*types.ObjectID, count)
+	for i := range ids {
+		ids[i] = types.NewObjectID("jbod", fmt.Sprintf("/object/%d", i))
+		obj := &types.ObjectMetadata{ID: ids[i], Headers: http.Header{}}
+		if err := s.SaveMetadata(obj); err != nil {
+			t.Fatalf("Could not save metadata for %s: %s", ids[i], err)
+		}
+		idx := &types.ObjectIndex{ObjID: ids[i], Part: 0}
+		if err := s.SavePart(idx, strings.NewReader("contents")); err != nil {
+			t.Fatalf("Could not save part %s: %s", idx, err)
+		}
+	}
+	return ids
+}
+
+func diskOf(t *testing.T, s *JBOD, id *types.ObjectID) string {
+	path, _, err := s.getDisk(id)
+	if err != nil {
+		t.Fatalf("Could not get the disk of %s: %s", id, err)
+	}
+	return path
+}
+
+func TestObjectsAreSpreadAcrossDisks(t *testing.T) {
+	t.Parallel()
+	for _, algorithm := range []string{"", "ketama", "legacyketama", "rendezvous"} {
+		paths, cleanup := getTestPaths(t, 3)
+		s := getTestJBOD(t, algorithm, paths...)
+		ids := saveObjects(t, s, 30)
+
+		var perDisk = make(map[string]int)
+		for _, id := range ids {
+			path := diskOf(t, s, id)
+			perDisk[path]++
+			if _, err := os.Stat(filepath.Join(path, "jbod")); err != nil {
+				t.Errorf("[%s] Expected %s to be on disk %s: %s", algorithm, id, path, err)
+			}
+			r, err := s.GetPart(&types.ObjectIndex{ObjID: id, Part: 0})
+			if err != nil {
+				t.Errorf("[%s] Received unexpected error while getting part of %s: %s", algorithm, id, err)
+				continue
+			}
+			if contents, _ := ioutil.ReadAll(r); string(contents) != "contents" {
+				t.Errorf("[%s] Expected the contents of %s but read %s", algorithm, id, contents)
+			}
+			_ = r.Close()
+		}
+		if len(perDisk) != len(paths) {
+			t.Errorf("[%s] Expected the objects to be on all %d disks but they are on %v",
+				algorithm, len(paths), perDisk)
+		}
+
+		// the objects are placed on the same disks after a restart
+		var found int
+		if err := getTestJBOD(t, algorithm, paths...).Iterate(
+			func(*types.ObjectMetadata, ...*types.ObjectIndex) bool {
+				found++
+				return true
+			}); err != nil {
+			t.Errorf("[%s] Received unexpected error while iterating: %s", algorithm, err)
+		}
+		if found != len(ids) {
+			t.Errorf("[%s] Expected %d objects after the restart but found %d", algorithm, len(ids), found)
+		}
+		cleanup()
+	}
+}
+
+func TestFailedDisksAreTakenOutOfRotation(t *testing.T) {
+	t.Parallel()
+	paths, cleanup := getTestPaths(t, 3)
+	defer cleanup()
+	s := getTestJBOD(t, "", paths...)
+	ids := saveObjects(t, s, 30)
+
+	var failedDisk = diskOf(t, s, ids[0])
+	var onOtherDisks []*types.ObjectID
+	for _, id := range ids {
+		if diskOf(t, s, id) != failedDisk {
+			onOtherDisks = append(onOtherDisks, id)
+		}
+	}
+
+	// the disk is replaced with a file, so that it returns errors
+	if err := os.RemoveAll(failedDisk); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(failedDisk, []byte("failed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetMetadata(ids[0]); !os.IsNotExist(err) {
+		t.Errorf("Expected the object on the failed disk to be missing but got %v", err)
+	}
+	if disks := s.Disks(); len(disks) != 2 {
+		t.Errorf("Expected the failed disk to be taken out of rotation but the disks are %v", disks)
+	}
+	for _, id := range onOtherDisks {
+		if _, err := s.GetMetadata(id); err != nil {
+			t.Errorf("Expected %s on the working disks to be found but got %s", id, err)
+		}
+	}
+
+	saveObjects(t, s, 1)
+	if path := diskOf(t, s, ids[0]); path == failedDisk {
+		t.Error("Expected the objects not to be placed on the failed disk")
+	}
+	if _, err := s.GetMetadata(ids[0]); err != nil {
+		t.Errorf("Expected the object to be saved on another disk but got %s", err)
+	}
+}
+
+func TestSaveMetadataOnFailingDisk(t *testing.T) {
+	t.Parallel()
+	paths, cleanup := getTestPaths(t, 2)
+	defer cleanup()
+	s := getTestJBOD(t, "", paths...)
+	id := types.NewObjectID("jbod", "/failing")
+	failedDisk := diskOf(t, s, id)
+	if err := os.RemoveAll(failedDisk); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(failedDisk, []byte("failed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SaveMetadata(&types.ObjectMetadata{ID: id, Headers: http.Header{}}); err != nil {
+		t.Errorf("Expected the metadata to be saved on the working disk but got %s", err)
+	}
+	if disks := s.Disks(); len(disks) != 1 || disks[0] == failedDisk {
+		t.Errorf("Expected only the working disk to be in rotation but the disks are %v", disks)
+	}
+}
+
+func TestMisplacedObjectsAreDiscarded(t *testing.T) {
+	t.Parallel()
+	paths, cleanup := getTestPaths(t, 2)
+	defer cleanup()
+	ids := saveObjects(t, getTestJBOD(t, "", paths[0]), 20)
+
+	s := getTestJBOD(t, "", paths...)
+	var found = make(map[string]bool)
+	if err := s.Iterate(func(obj *types.ObjectMetadata, _ ...*types.ObjectIndex) bool {
+		found[obj.ID.Path()] = true
+		return true
+	}); err != nil {
+		t.Errorf("Received unexpected error while iterating: %s", err)
+	}
+	for _, id := range ids {
+		onItsDisk := diskOf(t, s, id) == paths[0]
+		if found[id.Path()] != onItsDisk {
+			t.Errorf("Expected %s to be found while iterating: %t", id, onItsDisk)
+		}
+		if _, err := os.Stat(filepath.Join(paths[0], id.CacheKey(), id.StrHash()[0:2], id.StrHash()[2:4], id.StrHash())); os.IsNotExist(err) == onItsDisk {
+			t.Errorf("Expected %s to be kept on the first disk: %t", id, onItsDisk)
+		}
+	}
+}
+
+func TestConstructor(t *testing.T) {
+	t.Parallel()
+	paths, cleanup := getTestPaths(t, 2)
+	defer cleanup()
+	var tests = map[string]*config.CacheZone{
+		"no paths":          {PartSize: 10},
+		"random balancing":  {PartSize: 10, Paths: paths, PathsBalancing: "random"},
+		"unknown balancing": {PartSize: 10, Paths: paths, PathsBalancing: "bogus"},
+		"no working disks":  {PartSize: 10, Paths: []string{paths[0] + "/missing"}},
+		"no part size":      {Paths: paths},
+	}
+	for name, cfg := range tests {
+		if _, err := New(cfg, mock.NewLogger()); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+
+	s, err := New(&config.CacheZone{PartSize: 10, Paths: append([]string{paths[0] + "/missing"}, paths...)},
+		mock.NewLogger())
+	if err != nil {
+		t.Fatalf("Received unexpected error: %s", err)
+	}
+	if disks := s.Disks(); len(disks) != 2 {
+		t.Errorf("Expected only the working disks to be in rotation but the disks are %v", disks)
+	}
+}
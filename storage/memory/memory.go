@@ -0,0 +1,347 @@
+// Package memory contains a storage implementation which keeps the cached
+// objects in RAM. It is meant for small cache zones with hot objects, like
+// manifests and thumbnails, for which the latency of the disk dominates.
+package memory
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/ironsmile/nedomi/config"
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils"
+)
+
+// metadataOverhead is roughly how many bytes are used by the metadata of an
+// object besides its headers and strings.
+const metadataOverhead = 128
+
+// ErrFull is returned when the saved part does not fit in the memory budget
+// of the storage.
+var ErrFull = errors.New("memory storage is full")
+
+// errNoMetadata is returned when a part of an object without metadata is
+// saved, since nothing would remove it from the storage.
+var errNoMetadata = errors.New("object metadata is not present")
+
+// Memory implements the Storage interface by keeping the objects in memory.
+// The total size of the stored parts is bounded by a byte budget and saves
+// which would exceed it fail. The cache algorithm of the zone is responsible
+// for removing objects from the storage, so the budget should fit all the
+// parts which it keeps. The metadata is kept in addition to the budget.
+type Memory struct {
+	types.SyncLogger
+	sync.RWMutex
+	partSize uint64
+	maxSize  uint64
+	size     uint64
+	// the size of the metadata, which is not limited by the budget
+	metadataBytes uint64
+	objects       map[types.ObjectIDHash]*object
+	// whether parts are saved for objects without metadata
+	partsOnly bool
+}
+
+type object struct {
+	metadata *types.ObjectMetadata
+	parts    map[uint32]*part
+}
+
+type part struct {
+	data     []byte
+	checksum uint32
+}
+
+// partReader reads a part from memory. It is an io.Seeker, so that the part
+// does not have to be copied when its checksum is verified.
+type partReader struct {
+	*bytes.Reader
+}
+
+func (partReader) Close() error {
+	return nil
+}
+
+// PartSize the maximum part size for the memory storage.
+func (s *Memory) PartSize() uint64 {
+	return s.partSize
+}
+
+// GetMetadata returns a copy of the metadata of the object, if present.
+func (s *Memory) GetMetadata(id *types.ObjectID) (*types.ObjectMetadata, error) {
+	s.RLock()
+	defer s.RUnlock()
+	obj, ok := s.objects[id.Hash()]
+	if !ok || obj.metadata == nil {
+		return nil, os.ErrNotExist
+	}
+	return utils.CopyMetadata(obj.metadata), nil
+}
+
+// GetPart returns an io.ReadCloser that will read the specified part of the
+// object from memory.
+func (s *Memory) GetPart(idx *types.ObjectIndex) (io.ReadCloser, error) {
+	s.RLock()
+	defer s.RUnlock()
+	p, err := s.getPart(idx)
+	if err != nil {
+		return nil, err
+	}
+	// the saved parts are never modified, so they can be read concurrently
+	return partReader{bytes.NewReader(p.data)}, nil
+}
+
+// GetAvailableParts returns types.ObjectIndexMap including all the available
+// parts of for the object specified by the provided objectMetadata
+func (s *Memory) GetAvailableParts(id *types.ObjectID) ([]*types.ObjectIndex, error) {
+	s.RLock()
+	defer s.RUnlock()
+	obj, ok := s.objects[id.Hash()]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return availableParts(id, obj), nil
+}
+
+// SaveMetadata stores a copy of the supplied metadata.
+func (s *Memory) SaveMetadata(m *types.ObjectMetadata) error {
+	s.GetLogger().Debugf("[MemoryStorage] Saving metadata for %s...", m.ID)
+	saved := utils.CopyMetadata(m)
+
+	s.Lock()
+	defer s.Unlock()
+	obj, ok := s.objects[m.ID.Hash()]
+	if !ok {
+		obj = &object{parts: make(map[uint32]*part)}
+		s.objects[m.ID.Hash()] = obj
+	} else if obj.metadata != nil {
+		s.metadataBytes -= metadataSize(obj.metadata)
+	}
+	s.metadataBytes += metadataSize(saved)
+	obj.metadata = saved
+	return nil
+}
+
+// SavePart stores the contents of the supplied object part.
+func (s *Memory) SavePart(idx *types.ObjectIndex, data io.Reader) error {
+	s.GetLogger().Debugf("[MemoryStorage] Saving file data for %s...", idx)
+	// reading one byte more than the part size detects parts which are too big
+	buf := bytes.NewBuffer(make([]byte, 0, s.partSize))
+	checksum := types.NewPartHash()
+	savedSize, err := io.Copy(io.MultiWriter(buf, checksum), io.LimitReader(data, int64(s.partSize)+1))
+	if err != nil {
+		return err
+	} else if uint64(savedSize) > s.partSize {
+		return fmt.Errorf("Object part has invalid size %d", savedSize)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	obj, ok := s.objects[idx.ObjID.Hash()]
+	if !s.partsOnly && (!ok || obj.metadata == nil) {
+		return errNoMetadata
+	}
+	var oldSize uint64
+	if ok {
+		if old, ok := obj.parts[idx.Part]; ok {
+			oldSize = uint64(len(old.data))
+		}
+	}
+	if err := s.reserve(uint64(savedSize), oldSize); err != nil {
+		return err
+	}
+	if !ok {
+		obj = &object{parts: make(map[uint32]*part)}
+		s.objects[idx.ObjID.Hash()] = obj
+	}
+	contents := buf.Bytes()
+	if cap(contents) > len(contents) {
+		// the unused capacity of the smaller parts is not wasted
+		contents = append([]byte(nil), contents...)
+	}
+	obj.parts[idx.Part] = &part{data: contents, checksum: checksum.Sum32()}
+	return nil
+}
+
+// GetPartChecksum returns the checksum of the specified part which was
+// calculated when it was saved.
+func (s *Memory) GetPartChecksum(idx *types.ObjectIndex) (uint32, error) {
+	s.RLock()
+	defer s.RUnlock()
+	p, err := s.getPart(idx)
+	if err != nil {
+		return 0, err
+	}
+	return p.checksum, nil
+}
+
+// Discard removes the object and its metadata from memory.
+func (s *Memory) Discard(id *types.ObjectID) error {
+	s.GetLogger().Debugf("[MemoryStorage] Discarding %s...", id)
+	s.Lock()
+	defer s.Unlock()
+	obj, ok := s.objects[id.Hash()]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if obj.metadata != nil {
+		s.metadataBytes -= metadataSize(obj.metadata)
+	}
+	for _, p := range obj.parts {
+		s.size -= uint64(len(p.data))
+	}
+	delete(s.objects, id.Hash())
+	return nil
+}
+
+// DiscardPart removes the specified part of an Object from memory.
+func (s *Memory) DiscardPart(idx *types.ObjectIndex) error {
+	s.GetLogger().Debugf("[MemoryStorage] Discarding %s...", idx)
+	s.Lock()
+	defer s.Unlock()
+	p, err := s.getPart(idx)
+	if err != nil {
+		return err
+	}
+	s.size -= uint64(len(p.data))
+	obj := s.objects[idx.ObjID.Hash()]
+	delete(obj.parts, idx.Part)
+	if len(obj.parts) == 0 && obj.metadata == nil {
+		delete(s.objects, idx.ObjID.Hash())
+	}
+	return nil
+}
+
+// Iterate passes all the objects in memory and their parts to the supplied
+// callback function. If the callback function returns false, the iteration
+// stops. The objects which are saved or discarded during the iteration may or
+// may not be passed to the callback.
+func (s *Memory) Iterate(callback func(*types.ObjectMetadata, ...*types.ObjectIndex) bool) error {
+	type entry struct {
+		metadata *types.ObjectMetadata
+		parts    []*types.ObjectIndex
+	}
+	s.RLock()
+	entries := make([]entry, 0, len(s.objects))
+	for _, obj := range s.objects {
+		if obj.metadata == nil {
+			continue
+		}
+		entries = append(entries, entry{
+			metadata: utils.CopyMetadata(obj.metadata),
+			parts:    availableParts(obj.metadata.ID, obj),
+		})
+	}
+	s.RUnlock()
+
+	for _, e := range entries {
+		if !callback(e.metadata, e.parts...) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Size returns how many bytes are used by the stored metadata and parts.
+func (s *Memory) Size() uint64 {
+	s.RLock()
+	defer s.RUnlock()
+	return s.size + s.metadataBytes
+}
+
+// getPart should be called with the lock held.
+func (s *Memory) getPart(idx *types.ObjectIndex) (*part, error) {
+	obj, ok := s.objects[idx.ObjID.Hash()]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	p, ok := obj.parts[idx.Part]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return p, nil
+}
+
+// reserve replaces oldSize bytes of the budget with newSize bytes if they fit
+// in it. It should be called with the lock held.
+func (s *Memory) reserve(newSize, oldSize uint64) error {
+	if s.size-oldSize+newSize > s.maxSize {
+		return ErrFull
+	}
+	s.size = s.size - oldSize + newSize
+	return nil
+}
+
+func availableParts(id *types.ObjectID, obj *object) []*types.ObjectIndex {
+	parts := make([]*types.ObjectIndex, 0, len(obj.parts))
+	for num := range obj.parts {
+		parts = append(parts, &types.ObjectIndex{ObjID: id, Part: num})
+	}
+	return parts
+}
+
+// metadataSize estimates how much memory is used by the metadata.
+func metadataSize(m *types.ObjectMetadata) uint64 {
+	size := uint64(metadataOverhead + len(m.ID.CacheKey()) + len(m.ID.Path()) + len(m.RequestURL))
+	for _, headers := range []http.Header{m.Headers, m.RequestHeaders} {
+		for key, values := range headers {
+			size += uint64(len(key))
+			for _, value := range values {
+				size += uint64(len(value))
+			}
+		}
+	}
+	for _, strs := range [][]string{m.Vary, m.Variants, m.Tags} {
+		for _, str := range strs {
+			size += uint64(len(str))
+		}
+	}
+	return size
+}
+
+// New returns a new memory storage that ready for use. Its budget is the
+// memory_size of the cache zone or, if that is not set, the maximum size of
+// the parts of the zone's storage_objects.
+func New(cfg *config.CacheZone, log types.Logger) (*Memory, error) {
+	return newMemory(cfg, log, false)
+}
+
+// NewTier returns a new memory storage for the memory tier of another
+// storage. It keeps only the parts of the objects, whose metadata is kept by
+// the other storage, and its budget is the memory_size of the cache zone.
+// Removing the parts when the budget is used is left to the other storage.
+func NewTier(cfg *config.CacheZone, log types.Logger) (*Memory, error) {
+	return newMemory(cfg, log, true)
+}
+
+func newMemory(cfg *config.CacheZone, log types.Logger, partsOnly bool) (*Memory, error) {
+	if cfg == nil || log == nil {
+		return nil, fmt.Errorf("nil constructor parameters")
+	}
+
+	if cfg.PartSize == 0 {
+		return nil, fmt.Errorf("invalid partSize value")
+	}
+
+	maxSize := cfg.MemorySize.Bytes()
+	if maxSize == 0 {
+		maxSize = cfg.StorageObjects * cfg.PartSize.Bytes()
+	}
+	if maxSize == 0 {
+		return nil, fmt.Errorf("memory storage needs memory_size or storage_objects")
+	}
+
+	s := &Memory{
+		partSize:  cfg.PartSize.Bytes(),
+		maxSize:   maxSize,
+		objects:   make(map[types.ObjectIDHash]*object),
+		partsOnly: partsOnly,
+	}
+	s.SetLogger(log)
+	return s, nil
+}
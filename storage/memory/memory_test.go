@@ -0,0 +1,291 @@
+package memory
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/config"
+	"github.com/ironsmile/nedomi/mock"
+	"github.com/ironsmile/nedomi/types"
+)
+
+var obj1 = &types.ObjectMetadata{
+	ID:                types.NewObjectID("testkey", "/lorem/ipsum"),
+	ResponseTimestamp: time.Now().Unix(),
+	Headers:           http.Header{"test": []string{"mest"}},
+	Tags:              []string{"lorem"},
+}
+var obj2 = &types.ObjectMetadata{
+	ID:                types.NewObjectID("concern", "/doge?so=scare&very_parameters"),
+	ResponseTimestamp: time.Now().Unix(),
+	Headers:           http.Header{"how-to": []string{"header"}},
+}
+
+func getTestMemoryStorage(t *testing.T, partSize, memorySize types.BytesSize) *Memory {
+	s, err := New(&config.CacheZone{
+		PartSize:       partSize,
+		StorageObjects: 20,
+		MemorySize:     memorySize,
+	}, mock.NewLogger())
+	if err != nil {
+		t.Fatalf("Could not create the memory storage: %s", err)
+	}
+	return s
+}
+
+func readPart(t *testing.T, s *Memory, idx *types.ObjectIndex) string {
+	r, err := s.GetPart(idx)
+	if err != nil {
+		t.Fatalf("Received unexpected error while getting part %s: %s", idx, err)
+	}
+	defer r.Close()
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Could not read part %s: %s", idx, err)
+	}
+	return string(contents)
+}
+
+func TestBasicOperations(t *testing.T) {
+	t.Parallel()
+	s := getTestMemoryStorage(t, 10, 1024)
+	idx := &types.ObjectIndex{ObjID: obj1.ID, Part: 3}
+
+	if _, err := s.GetMetadata(obj1.ID); !os.IsNotExist(err) {
+		t.Errorf("Expected os.ErrNotExist for the missing metadata but got %v", err)
+	}
+	if _, err := s.GetPart(idx); !os.IsNotExist(err) {
+		t.Errorf("Expected os.ErrNotExist for the missing part but got %v", err)
+	}
+
+	if err := s.SaveMetadata(obj1); err != nil {
+		t.Fatalf("Could not save metadata: %s", err)
+	}
+	if read, err := s.GetMetadata(obj1.ID); err != nil {
+		t.Errorf("Received unexpected error while getting metadata: %s", err)
+	} else if !reflect.DeepEqual(read, obj1) {
+		t.Errorf("Original and read objects differ: '%#v', '%#v'", obj1, read)
+	}
+
+	if err := s.SavePart(idx, strings.NewReader("0123456789")); err != nil {
+		t.Fatalf("Could not save part: %s", err)
+	}
+	if contents := readPart(t, s, idx); contents != "0123456789" {
+		t.Errorf("Expected the contents to be 0123456789 but read %s", contents)
+	}
+	if parts, err := s.GetAvailableParts(obj1.ID); err != nil || len(parts) != 1 || parts[0].Part != 3 {
+		t.Errorf("Expected only part 3 to be available but got %v (%v)", parts, err)
+	}
+
+	checksum := types.NewPartHash()
+	_, _ = checksum.Write([]byte("0123456789"))
+	if read, err := s.GetPartChecksum(idx); err != nil || read != checksum.Sum32() {
+		t.Errorf("Expected the checksum to be %08x but got %08x (%v)", checksum.Sum32(), read, err)
+	}
+
+	if err := s.SavePart(&types.ObjectIndex{ObjID: obj1.ID, Part: 4}, strings.NewReader("0123456789a")); err == nil {
+		t.Error("Expected an error for a part bigger than the part size")
+	}
+
+	if err := s.DiscardPart(idx); err != nil {
+		t.Errorf("Received unexpected error while discarding part: %s", err)
+	}
+	if _, err := s.GetPartChecksum(idx); !os.IsNotExist(err) {
+		t.Errorf("Expected os.ErrNotExist for the discarded part but got %v", err)
+	}
+	if err := s.Discard(obj1.ID); err != nil {
+		t.Errorf("Received unexpected error while discarding the object: %s", err)
+	}
+	if _, err := s.GetMetadata(obj1.ID); !os.IsNotExist(err) {
+		t.Errorf("Expected os.ErrNotExist for the discarded metadata but got %v", err)
+	}
+	if size := s.Size(); size != 0 {
+		t.Errorf("Expected no used memory after discarding everything but got %d", size)
+	}
+}
+
+func TestMetadataIsCopied(t *testing.T) {
+	t.Parallel()
+	s := getTestMemoryStorage(t, 10, 1024)
+	saved := *obj2
+	saved.Headers = http.Header{"how-to": []string{"header"}}
+	if err := s.SaveMetadata(&saved); err != nil {
+		t.Fatalf("Could not save metadata: %s", err)
+	}
+	saved.Headers.Set("how-to", "changed")
+
+	read, err := s.GetMetadata(obj2.ID)
+	if err != nil {
+		t.Fatalf("Received unexpected error while getting metadata: %s", err)
+	}
+	read.Headers.Set("so", "mutable")
+	read.ExpiresAt = 42
+
+	if again, _ := s.GetMetadata(obj2.ID); !reflect.DeepEqual(again, obj2) {
+		t.Errorf("Expected the stored metadata to be unaffected by changes of the copies but got '%#v'", again)
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	t.Parallel()
+	s := getTestMemoryStorage(t, 100, 300)
+	if err := s.SaveMetadata(obj1); err != nil {
+		t.Fatalf("Could not save metadata: %s", err)
+	}
+	metadataSize := s.Size()
+	if metadataSize == 0 {
+		t.Error("Expected the metadata to be included in the size")
+	}
+
+	var contents = strings.Repeat("a", 100)
+	var saved uint32
+	for ; saved < 5; saved++ {
+		idx := &types.ObjectIndex{ObjID: obj1.ID, Part: saved}
+		if err := s.SavePart(idx, strings.NewReader(contents)); err != nil {
+			if err != ErrFull {
+				t.Errorf("Expected ErrFull when the budget is exceeded but got %s", err)
+			}
+			break
+		}
+	}
+	if saved != 3 {
+		t.Errorf("Expected the metadata not to use the budget and 3 parts to fit but %d were saved", saved)
+	}
+	if size := s.Size(); size != metadataSize+300 {
+		t.Errorf("Expected the size to be %d but it was %d", metadataSize+300, size)
+	}
+
+	// the metadata is saved even when the budget is used
+	if err := s.SaveMetadata(obj2); err != nil {
+		t.Errorf("Expected the metadata to be saved but got %s", err)
+	}
+
+	// replacing a part does not use more memory
+	if err := s.SavePart(&types.ObjectIndex{ObjID: obj1.ID, Part: 0}, strings.NewReader(contents)); err != nil {
+		t.Errorf("Expected the part to be replaced but got %s", err)
+	}
+	if err := s.DiscardPart(&types.ObjectIndex{ObjID: obj1.ID, Part: 0}); err != nil {
+		t.Fatalf("Received unexpected error while discarding part: %s", err)
+	}
+	if err := s.SavePart(&types.ObjectIndex{ObjID: obj1.ID, Part: 7}, strings.NewReader(contents)); err != nil {
+		t.Errorf("Expected the discarded part to free memory but got %s", err)
+	}
+}
+
+func TestPartsWithoutMetadata(t *testing.T) {
+	t.Parallel()
+	s := getTestMemoryStorage(t, 10, 1024)
+	idx := &types.ObjectIndex{ObjID: obj1.ID, Part: 0}
+	if err := s.SavePart(idx, strings.NewReader("orphan")); err == nil {
+		t.Error("Expected an error when saving a part of an object without metadata")
+	}
+	if size := s.Size(); size != 0 {
+		t.Errorf("Expected the rejected part not to use memory but the size is %d", size)
+	}
+
+	tier, err := NewTier(&config.CacheZone{PartSize: 10, StorageObjects: 20}, mock.NewLogger())
+	if err != nil {
+		t.Fatalf("Could not create the memory tier: %s", err)
+	}
+	if err := tier.SavePart(idx, strings.NewReader("tiered")); err != nil {
+		t.Fatalf("Expected the memory tier to save parts without metadata but got %s", err)
+	}
+	if err := tier.DiscardPart(idx); err != nil {
+		t.Fatalf("Received unexpected error while discarding part: %s", err)
+	}
+	if _, err := tier.GetPart(idx); !os.IsNotExist(err) {
+		t.Errorf("Expected the discarded part to be missing but got %v", err)
+	}
+	if len(tier.objects) != 0 {
+		t.Errorf("Expected the object without parts and metadata to be removed but there are %d objects", len(tier.objects))
+	}
+}
+
+func TestIteration(t *testing.T) {
+	t.Parallel()
+	s := getTestMemoryStorage(t, 10, 1024)
+	for _, obj := range []*types.ObjectMetadata{obj1, obj2} {
+		if err := s.SaveMetadata(obj); err != nil {
+			t.Fatalf("Could not save metadata: %s", err)
+		}
+	}
+	if err := s.SavePart(&types.ObjectIndex{ObjID: obj2.ID, Part: 1}, strings.NewReader("part")); err != nil {
+		t.Fatalf("Could not save part: %s", err)
+	}
+
+	var found = make(map[string]int)
+	if err := s.Iterate(func(obj *types.ObjectMetadata, parts ...*types.ObjectIndex) bool {
+		found[obj.ID.Path()] = len(parts)
+		return true
+	}); err != nil {
+		t.Errorf("Received unexpected error while iterating: %s", err)
+	}
+	if !reflect.DeepEqual(found, map[string]int{obj1.ID.Path(): 0, obj2.ID.Path(): 1}) {
+		t.Errorf("Unexpected objects found while iterating: %v", found)
+	}
+
+	var iterated int
+	_ = s.Iterate(func(*types.ObjectMetadata, ...*types.ObjectIndex) bool {
+		iterated++
+		return false
+	})
+	if iterated != 1 {
+		t.Errorf("Expected the iteration to stop after the first object but there were %d", iterated)
+	}
+}
+
+func TestConcurrentReads(t *testing.T) {
+	t.Parallel()
+	s := getTestMemoryStorage(t, 10, 1024)
+	if err := s.SaveMetadata(obj1); err != nil {
+		t.Fatalf("Could not save metadata: %s", err)
+	}
+	idx := &types.ObjectIndex{ObjID: obj1.ID, Part: 0}
+	if err := s.SavePart(idx, strings.NewReader("concurrent")); err != nil {
+		t.Fatalf("Could not save part: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := s.GetPart(idx)
+			if err != nil {
+				t.Errorf("Received unexpected error while getting part: %s", err)
+				return
+			}
+			if _, ok := r.(io.Seeker); !ok {
+				t.Error("Expected the part reader to be an io.Seeker")
+			}
+			if contents, _ := ioutil.ReadAll(r); string(contents) != "concurrent" {
+				t.Errorf("Expected the contents to be 'concurrent' but read %s", contents)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConstructor(t *testing.T) {
+	t.Parallel()
+	if _, err := New(nil, mock.NewLogger()); err == nil {
+		t.Error("Expected an error for a nil config")
+	}
+	if _, err := New(&config.CacheZone{PartSize: 10}, mock.NewLogger()); err == nil {
+		t.Error("Expected an error for a zone without a memory budget")
+	}
+	s, err := New(&config.CacheZone{PartSize: 10, StorageObjects: 3}, mock.NewLogger())
+	if err != nil {
+		t.Fatalf("Received unexpected error: %s", err)
+	}
+	if s.maxSize != 30 {
+		t.Errorf("Expected the default budget to be 30 bytes but it is %d", s.maxSize)
+	}
+}
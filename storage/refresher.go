@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/ironsmile/nedomi/types"
+)
+
+// Refresher runs the background refreshes of the objects in a cache zone. At
+// most one job runs for each object and jobs which would exceed the
+// concurrency limit are dropped instead of being queued, since the objects
+// simply expire as usual when they are not refreshed.
+type Refresher struct {
+	sync.Mutex
+	running map[types.ObjectIDHash]struct{}
+	limit   int
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// NewRefresher returns a Refresher which runs at most concurrency jobs at
+// the same time.
+func NewRefresher(concurrency int) *Refresher {
+	return &Refresher{
+		running: make(map[types.ObjectIDHash]struct{}),
+		limit:   concurrency,
+	}
+}
+
+// Refresh starts the job in a new goroutine, unless there is already one
+// running for the object, the limit of concurrent jobs is reached or the
+// Refresher is stopped. The job is expected to recover from its own panics.
+func (r *Refresher) Refresh(key types.ObjectIDHash, job func()) bool {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.running[key]; ok || len(r.running) >= r.limit || r.stopped {
+		return false
+	}
+	r.running[key] = struct{}{}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer r.done(key)
+		job()
+	}()
+	return true
+}
+
+// Stop makes the Refresher drop all new jobs and waits for the running ones
+// to finish.
+func (r *Refresher) Stop() {
+	r.Lock()
+	r.stopped = true
+	r.Unlock()
+	r.wg.Wait()
+}
+
+// Running returns the number of jobs which are currently running.
+func (r *Refresher) Running() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.running)
+}
+
+func (r *Refresher) done(key types.ObjectIDHash) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.running, key)
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefresherLimits(t *testing.T) {
+	t.Parallel()
+	var r = NewRefresher(2)
+	var release = make(chan struct{})
+	var done = make(chan struct{}, 3)
+	var job = func() {
+		<-release
+		done <- struct{}{}
+	}
+
+	if !r.Refresh(keyFromString("foo"), job) {
+		t.Fatal("expected the first job to be started")
+	}
+	if r.Refresh(keyFromString("foo"), job) {
+		t.Error("expected a second job for the same object not to be started")
+	}
+	if !r.Refresh(keyFromString("bar"), job) {
+		t.Fatal("expected the job for another object to be started")
+	}
+	if r.Refresh(keyFromString("baz"), job) {
+		t.Error("expected the job over the concurrency limit not to be started")
+	}
+	if running := r.Running(); running != 2 {
+		t.Errorf("expected 2 running jobs but got %d", running)
+	}
+
+	close(release)
+	<-done
+	<-done
+	for r.Running() != 0 {
+		// the jobs are removed right after they return
+		time.Sleep(time.Millisecond)
+	}
+	if !r.Refresh(keyFromString("baz"), job) {
+		t.Error("expected the job to be started after the others finished")
+	}
+	<-done
+}
+
+func TestRefresherStop(t *testing.T) {
+	t.Parallel()
+	var r = NewRefresher(2)
+	var release = make(chan struct{})
+	var finished = make(chan struct{})
+	if !r.Refresh(keyFromString("foo"), func() { <-release }) {
+		t.Fatal("expected the job to be started")
+	}
+
+	go func() {
+		r.Stop()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		t.Fatal("expected Stop to wait for the running job")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-finished
+
+	if r.Refresh(keyFromString("bar"), func() {}) {
+		t.Error("expected no jobs to be started after the refresher is stopped")
+	}
+}
@@ -156,8 +156,12 @@ func (em *Scheduler) expiresHandler() {
 			if nextExpire == nil {
 				continue
 			}
-			em.deleteRequest <- nextExpire.Key
-			delete(expiresDict, nextExpire.Key)
+			// Events which were rescheduled leave their old expire times in
+			// the heap. Only the latest one for a key should trigger it.
+			if expiresDict[nextExpire.Key].Equal(nextExpire.Expires) {
+				em.deleteRequest <- nextExpire.Key
+				delete(expiresDict, nextExpire.Key)
+			}
 
 			heap.Remove(expires, 0)
 		}
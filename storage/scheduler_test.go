@@ -177,3 +177,19 @@ func TestChangeLogger(t *testing.T) {
 		t.Error("the log checking function has not expired")
 	}
 }
+
+func TestReschedulingLater(t *testing.T) {
+	t.Parallel()
+	logger := mock.NewLogger()
+	mp := NewScheduler(logger)
+	defer mp.Destroy()
+	var expected = "later"
+
+	ch := make(chan string)
+	mp.AddEvent(fooKey, writeFunc(ch, "sooner"), 50*time.Millisecond)
+	mp.AddEvent(fooKey, writeFunc(ch, expected), 200*time.Millisecond)
+
+	if got := waitAround(t, ch, 200*time.Millisecond); got != expected {
+		t.Errorf("expected '%s' got '%s'", expected, got)
+	}
+}
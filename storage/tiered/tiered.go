@@ -0,0 +1,303 @@
+// Package tiered contains a storage implementation which keeps all the cached
+// objects on the disk and copies of their most popular parts in memory.
+package tiered
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/ironsmile/nedomi/config"
+	"github.com/ironsmile/nedomi/storage/disk"
+	"github.com/ironsmile/nedomi/storage/memory"
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils"
+)
+
+// Tiered implements the Storage interface with a disk storage and a bounded
+// memory tier in front of it. Everything is written to the disk. The parts
+// which are read from the disk while their popularity, according to the cache
+// algorithm of the zone, is at least the configured threshold are copied in
+// memory and served from there. When the memory tier is full, its least
+// recently used parts are evicted from it, regardless of the disk storage.
+type Tiered struct {
+	types.SyncLogger
+	disk          *disk.Disk
+	memory        *memory.Memory
+	minPopularity float64
+
+	sync.Mutex
+	popularity func(*types.ObjectIndex) float64
+	// the parts in the memory tier, from the most to the least recently used
+	recent   *list.List
+	inMemory map[types.ObjectIndexHash]*list.Element
+	// the parts which are being read from the disk in order to be promoted
+	reads map[types.ObjectIndexHash]*diskRead
+}
+
+// diskRead is shared by the concurrent reads of a part from the disk. It is
+// marked as stale when the part is changed or removed while it is read, so
+// that the old contents are not copied in memory.
+type diskRead struct {
+	idx     types.ObjectIndex
+	readers int
+	stale   bool
+}
+
+// partReader reads a part which was read in memory. It is an io.Seeker, so
+// that the part does not have to be copied when its checksum is verified.
+type partReader struct {
+	*bytes.Reader
+}
+
+func (partReader) Close() error {
+	return nil
+}
+
+// PartSize the maximum part size for the storage.
+func (s *Tiered) PartSize() uint64 {
+	return s.disk.PartSize()
+}
+
+// GetMetadata returns the metadata on disk for this object, if present.
+func (s *Tiered) GetMetadata(id *types.ObjectID) (*types.ObjectMetadata, error) {
+	return s.disk.GetMetadata(id)
+}
+
+// MetadataCacheStats returns the statistics of the metadata cache of the
+// disk storage.
+func (s *Tiered) MetadataCacheStats() (hits, requests uint64) {
+	return s.disk.MetadataCacheStats()
+}
+
+// GetPart returns an io.ReadCloser that will read the specified part of the
+// object from memory if it is in the memory tier or from the disk otherwise.
+// Popular parts are copied in memory when they are read from the disk.
+func (s *Tiered) GetPart(idx *types.ObjectIndex) (io.ReadCloser, error) {
+	if r, err := s.getFromMemory(idx); err == nil {
+		return r, nil
+	}
+
+	if !s.isPopular(idx) {
+		return s.disk.GetPart(idx)
+	}
+
+	read := s.startRead(idx)
+	defer s.finishRead(read)
+	r, err := s.disk.GetPart(idx)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, utils.NewCompositeError(err, r.Close())
+	} else if err := r.Close(); err != nil {
+		return nil, err
+	}
+	s.promote(read, contents)
+	return partReader{bytes.NewReader(contents)}, nil
+}
+
+// GetAvailableParts returns types.ObjectIndexMap including all the available
+// parts of for the object specified by the provided objectMetadata
+func (s *Tiered) GetAvailableParts(id *types.ObjectID) ([]*types.ObjectIndex, error) {
+	return s.disk.GetAvailableParts(id)
+}
+
+// SaveMetadata writes the supplied metadata to the disk.
+func (s *Tiered) SaveMetadata(m *types.ObjectMetadata) error {
+	return s.disk.SaveMetadata(m)
+}
+
+// SavePart writes the contents of the supplied object part to the disk. An
+// older copy of the part is removed from the memory tier.
+func (s *Tiered) SavePart(idx *types.ObjectIndex, data io.Reader) error {
+	defer s.evict(idx)
+	return s.disk.SavePart(idx, data)
+}
+
+// GetPartChecksum returns the checksum of the specified part which was
+// recorded when it was saved to the disk.
+func (s *Tiered) GetPartChecksum(idx *types.ObjectIndex) (uint32, error) {
+	return s.disk.GetPartChecksum(idx)
+}
+
+// Discard removes the object and its metadata from the disk and its parts
+// from the memory tier.
+func (s *Tiered) Discard(id *types.ObjectID) error {
+	defer s.evictObject(id)
+	return s.disk.Discard(id)
+}
+
+// DiscardPart removes the specified part of an Object from both tiers.
+func (s *Tiered) DiscardPart(idx *types.ObjectIndex) error {
+	defer s.evict(idx)
+	return s.disk.DiscardPart(idx)
+}
+
+// Iterate iterates over all the objects on the disk.
+func (s *Tiered) Iterate(callback func(*types.ObjectMetadata, ...*types.ObjectIndex) bool) error {
+	return s.disk.Iterate(callback)
+}
+
+// SetLogger changes the logger of the storage and both of its tiers.
+func (s *Tiered) SetLogger(logger types.Logger) {
+	s.SyncLogger.SetLogger(logger)
+	s.disk.SetLogger(logger)
+	s.memory.SetLogger(logger)
+}
+
+// SetPopularity implements types.PopularityAware. The parts are copied in
+// memory only after it is set.
+func (s *Tiered) SetPopularity(popularity func(*types.ObjectIndex) float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.popularity = popularity
+}
+
+func (s *Tiered) getFromMemory(idx *types.ObjectIndex) (io.ReadCloser, error) {
+	s.Lock()
+	defer s.Unlock()
+	el, ok := s.inMemory[idx.Hash()]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	s.recent.MoveToFront(el)
+	return s.memory.GetPart(idx)
+}
+
+func (s *Tiered) isPopular(idx *types.ObjectIndex) bool {
+	s.Lock()
+	popularity := s.popularity
+	s.Unlock()
+	return popularity != nil && popularity(idx) >= s.minPopularity
+}
+
+// startRead registers a read of the part from the disk.
+func (s *Tiered) startRead(idx *types.ObjectIndex) *diskRead {
+	s.Lock()
+	defer s.Unlock()
+	read, ok := s.reads[idx.Hash()]
+	if !ok {
+		read = &diskRead{idx: *idx}
+		s.reads[idx.Hash()] = read
+	}
+	read.readers++
+	return read
+}
+
+func (s *Tiered) finishRead(read *diskRead) {
+	s.Lock()
+	defer s.Unlock()
+	if read.readers--; read.readers == 0 && s.reads[read.idx.Hash()] == read {
+		delete(s.reads, read.idx.Hash())
+	}
+}
+
+// promote copies the part which was read from the disk in the memory tier,
+// evicting the least recently used parts from it until the part fits. Nothing
+// is copied if the part was changed or removed while it was read.
+func (s *Tiered) promote(read *diskRead, contents []byte) {
+	s.Lock()
+	defer s.Unlock()
+	idx := &read.idx
+	if read.stale {
+		s.GetLogger().Debugf("[TieredStorage] Not copying %s in memory as it was changed while read", idx)
+		return
+	}
+	if _, ok := s.inMemory[idx.Hash()]; ok {
+		return
+	}
+
+	for {
+		err := s.memory.SavePart(idx, bytes.NewReader(contents))
+		if err == nil {
+			s.GetLogger().Debugf("[TieredStorage] Copied %s in memory", idx)
+			s.inMemory[idx.Hash()] = s.recent.PushFront(*idx)
+			return
+		}
+		if err != memory.ErrFull || s.recent.Len() == 0 {
+			s.GetLogger().Debugf("[TieredStorage] Could not copy %s in memory: %s", idx, err)
+			return
+		}
+		s.evictLocked(s.recent.Back().Value.(types.ObjectIndex))
+	}
+}
+
+// evict removes the part from the memory tier if it is there and prevents
+// the ongoing reads of the part from the disk from promoting it.
+func (s *Tiered) evict(idx *types.ObjectIndex) {
+	s.Lock()
+	defer s.Unlock()
+	if read, ok := s.reads[idx.Hash()]; ok {
+		read.stale = true
+		delete(s.reads, idx.Hash())
+	}
+	if _, ok := s.inMemory[idx.Hash()]; ok {
+		s.evictLocked(*idx)
+	}
+}
+
+// evictObject removes all the parts of the object from the memory tier and
+// prevents the ongoing reads of its parts from the disk from promoting them.
+func (s *Tiered) evictObject(id *types.ObjectID) {
+	s.Lock()
+	defer s.Unlock()
+	for hash, read := range s.reads {
+		if read.idx.ObjID.Hash() == id.Hash() {
+			read.stale = true
+			delete(s.reads, hash)
+		}
+	}
+	if parts, err := s.memory.GetAvailableParts(id); err == nil {
+		for _, idx := range parts {
+			if _, ok := s.inMemory[idx.Hash()]; ok {
+				s.evictLocked(*idx)
+			}
+		}
+	}
+}
+
+func (s *Tiered) evictLocked(idx types.ObjectIndex) {
+	s.recent.Remove(s.inMemory[idx.Hash()])
+	delete(s.inMemory, idx.Hash())
+	if err := s.memory.DiscardPart(&idx); err != nil {
+		s.GetLogger().Errorf("[TieredStorage] Error while evicting %s from memory: %s", &idx, err)
+	}
+}
+
+// New returns a new tiered storage that ready for use. Its memory tier takes
+// up to memory_size bytes.
+func New(cfg *config.CacheZone, log types.Logger) (*Tiered, error) {
+	if cfg == nil || log == nil {
+		return nil, fmt.Errorf("nil constructor parameters")
+	}
+
+	if cfg.MemorySize == 0 {
+		return nil, fmt.Errorf("tiered storage needs memory_size")
+	}
+
+	d, err := disk.New(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+	m, err := memory.NewTier(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Tiered{
+		disk:          d,
+		memory:        m,
+		minPopularity: cfg.MemoryMinPopularity,
+		recent:        list.New(),
+		inMemory:      make(map[types.ObjectIndexHash]*list.Element),
+		reads:         make(map[types.ObjectIndexHash]*diskRead),
+	}
+	s.SyncLogger.SetLogger(log)
+	return s, nil
+}
@@ -0,0 +1,223 @@
+package tiered
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ironsmile/nedomi/config"
+	"github.com/ironsmile/nedomi/mock"
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils/testutils"
+)
+
+var objID = types.NewObjectID("testkey", "/tiered")
+
+// popularity returns configurable popularities for the parts of the test
+// object, like the cache algorithm of the zone would.
+type popularity struct {
+	sync.Mutex
+	parts map[uint32]float64
+}
+
+func (p *popularity) set(part uint32, value float64) {
+	p.Lock()
+	defer p.Unlock()
+	p.parts[part] = value
+}
+
+func (p *popularity) get(idx *types.ObjectIndex) float64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.parts[idx.Part]
+}
+
+func getTestTieredStorage(t *testing.T, memorySize types.BytesSize) (*Tiered, *popularity, func()) {
+	path, cleanup := testutils.GetTestFolder(t)
+	s, err := New(&config.CacheZone{
+		ID:                  "default",
+		Path:                path,
+		PartSize:            10,
+		StorageObjects:      100,
+		MemorySize:          memorySize,
+		MemoryMinPopularity: 0.5,
+	}, mock.NewLogger())
+	if err != nil {
+		cleanup()
+		t.Fatalf("Could not create the tiered storage: %s", err)
+	}
+	p := &popularity{parts: make(map[uint32]float64)}
+	s.SetPopularity(p.get)
+	return s, p, cleanup
+}
+
+func savePart(t *testing.T, s *Tiered, part uint32, contents string) *types.ObjectIndex {
+	idx := &types.ObjectIndex{ObjID: objID, Part: part}
+	if err := s.SavePart(idx, strings.NewReader(contents)); err != nil {
+		t.Fatalf("Could not save part %s: %s", idx, err)
+	}
+	return idx
+}
+
+func readPart(t *testing.T, s *Tiered, idx *types.ObjectIndex) string {
+	r, err := s.GetPart(idx)
+	if err != nil {
+		t.Fatalf("Received unexpected error while getting part %s: %s", idx, err)
+	}
+	defer r.Close()
+	if _, ok := r.(io.Seeker); !ok {
+		t.Errorf("Expected the reader of part %s to be an io.Seeker", idx)
+	}
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Could not read part %s: %s", idx, err)
+	}
+	return string(contents)
+}
+
+func inMemory(s *Tiered, idx *types.ObjectIndex) bool {
+	_, err := s.memory.GetPart(idx)
+	return err == nil
+}
+
+func TestPopularPartsAreCopiedInMemory(t *testing.T) {
+	t.Parallel()
+	s, p, cleanup := getTestTieredStorage(t, 100)
+	defer cleanup()
+
+	idx := savePart(t, s, 0, "0123456789")
+	if contents := readPart(t, s, idx); contents != "0123456789" {
+		t.Errorf("Expected the contents to be 0123456789 but read %s", contents)
+	}
+	if inMemory(s, idx) {
+		t.Error("Expected the unpopular part not to be copied in memory")
+	}
+
+	p.set(0, 0.5)
+	if contents := readPart(t, s, idx); contents != "0123456789" {
+		t.Errorf("Expected the contents to be 0123456789 but read %s", contents)
+	}
+	if !inMemory(s, idx) {
+		t.Fatal("Expected the popular part to be copied in memory")
+	}
+	if contents := readPart(t, s, idx); contents != "0123456789" {
+		t.Errorf("Expected the part to be read from memory but read %s", contents)
+	}
+
+	// writes go to the disk and replace the copy in memory
+	savePart(t, s, 0, "abcdefghij")
+	if inMemory(s, idx) {
+		t.Error("Expected the replaced part to be removed from memory")
+	}
+	if contents := readPart(t, s, idx); contents != "abcdefghij" {
+		t.Errorf("Expected the new contents of the part but read %s", contents)
+	}
+
+	if err := s.DiscardPart(idx); err != nil {
+		t.Errorf("Received unexpected error while discarding part: %s", err)
+	}
+	if inMemory(s, idx) {
+		t.Error("Expected the discarded part to be removed from memory")
+	}
+	if _, err := s.GetPart(idx); err == nil {
+		t.Error("Expected an error for the discarded part")
+	}
+}
+
+func TestMemoryTierEviction(t *testing.T) {
+	t.Parallel()
+	s, p, cleanup := getTestTieredStorage(t, 30)
+	defer cleanup()
+
+	var parts []*types.ObjectIndex
+	for i := uint32(0); i < 4; i++ {
+		p.set(i, 1)
+		parts = append(parts, savePart(t, s, i, strings.Repeat(string('a'+rune(i)), 10)))
+	}
+	readPart(t, s, parts[0])
+	readPart(t, s, parts[1])
+	readPart(t, s, parts[2])
+	// part 0 becomes the most recently used one
+	readPart(t, s, parts[0])
+	readPart(t, s, parts[3])
+
+	for i, expected := range []bool{true, false, true, true} {
+		if got := inMemory(s, parts[i]); got != expected {
+			t.Errorf("Expected part %d to be in memory: %t but got %t", i, expected, got)
+		}
+	}
+
+	// the evicted parts are still on the disk
+	if contents := readPart(t, s, parts[1]); contents != strings.Repeat("b", 10) {
+		t.Errorf("Expected the evicted part to be read from the disk but read %s", contents)
+	}
+	if available, err := s.GetAvailableParts(objID); err != nil || len(available) != 4 {
+		t.Errorf("Expected all 4 parts on the disk but got %d (%v)", len(available), err)
+	}
+
+	if err := s.Discard(objID); err != nil {
+		t.Errorf("Received unexpected error while discarding the object: %s", err)
+	}
+	for i, idx := range parts {
+		if inMemory(s, idx) {
+			t.Errorf("Expected part %d of the discarded object to be removed from memory", i)
+		}
+	}
+	if s.recent.Len() != 0 || s.memory.Size() != 0 {
+		t.Errorf("Expected the memory tier to be empty but it has %d parts", s.recent.Len())
+	}
+}
+
+func TestPartsChangedWhileReadAreNotPromoted(t *testing.T) {
+	t.Parallel()
+	s, p, cleanup := getTestTieredStorage(t, 100)
+	defer cleanup()
+	p.set(0, 1)
+	p.set(1, 1)
+
+	// the part is replaced after it was read from the disk but before it
+	// was copied in memory
+	idx := savePart(t, s, 0, "0123456789")
+	read := s.startRead(idx)
+	savePart(t, s, 0, "abcdefghij")
+	s.promote(read, []byte("0123456789"))
+	s.finishRead(read)
+	if inMemory(s, idx) {
+		t.Error("Expected the old contents of the replaced part not to be copied in memory")
+	}
+	if contents := readPart(t, s, idx); contents != "abcdefghij" {
+		t.Errorf("Expected the new contents of the part but read %s", contents)
+	}
+
+	other := savePart(t, s, 1, "0123456789")
+	read = s.startRead(other)
+	if err := s.Discard(objID); err != nil {
+		t.Fatalf("Received unexpected error while discarding the object: %s", err)
+	}
+	s.promote(read, []byte("0123456789"))
+	s.finishRead(read)
+	if inMemory(s, other) {
+		t.Error("Expected the part of the discarded object not to be copied in memory")
+	}
+	if len(s.reads) != 0 {
+		t.Errorf("Expected no reads to be tracked but there are %d", len(s.reads))
+	}
+}
+
+func TestConstructor(t *testing.T) {
+	t.Parallel()
+	path, cleanup := testutils.GetTestFolder(t)
+	defer cleanup()
+	if _, err := New(nil, mock.NewLogger()); err == nil {
+		t.Error("Expected an error for a nil config")
+	}
+	if _, err := New(&config.CacheZone{Path: path, PartSize: 10}, mock.NewLogger()); err == nil {
+		t.Error("Expected an error for a zone without memory_size")
+	}
+	if _, err := New(&config.CacheZone{Path: path + "/missing", PartSize: 10, MemorySize: 100},
+		mock.NewLogger()); err == nil {
+		t.Error("Expected an error for a missing disk path")
+	}
+}
@@ -9,6 +9,12 @@ import (
 	"github.com/ironsmile/nedomi/types"
 
 	"github.com/ironsmile/nedomi/storage/disk"
+
+	"github.com/ironsmile/nedomi/storage/jbod"
+
+	"github.com/ironsmile/nedomi/storage/memory"
+
+	"github.com/ironsmile/nedomi/storage/tiered"
 )
 
 type newStorageFunc func(cfg *config.CacheZone, log types.Logger) (types.Storage, error)
@@ -18,4 +24,16 @@ var storageTypes = map[string]newStorageFunc{
 	"disk": func(cfg *config.CacheZone, log types.Logger) (types.Storage, error) {
 		return disk.New(cfg, log)
 	},
+
+	"jbod": func(cfg *config.CacheZone, log types.Logger) (types.Storage, error) {
+		return jbod.New(cfg, log)
+	},
+
+	"memory": func(cfg *config.CacheZone, log types.Logger) (types.Storage, error) {
+		return memory.New(cfg, log)
+	},
+
+	"tiered": func(cfg *config.CacheZone, log types.Logger) (types.Storage, error) {
+		return tiered.New(cfg, log)
+	},
 }
@@ -1,11 +1,55 @@
 package storage
 
-import "github.com/ironsmile/nedomi/types"
+import (
+	"time"
+
+	"github.com/ironsmile/nedomi/types"
+	"github.com/ironsmile/nedomi/utils"
+	"github.com/ironsmile/nedomi/utils/cacheutils"
+)
 
 // GetExpirationHandler returns a potentially long-lived callback that removes
-// the specified object from the storage.
+// the specified object from the storage. Expired objects which can still be
+// used are kept until StaleUntil before they are removed.
 func GetExpirationHandler(cz *types.CacheZone, id *types.ObjectID) func(types.Logger) {
+	return GetRefreshingExpirationHandler(cz, id, nil)
+}
+
+// GetRefreshingExpirationHandler returns an expiration handler like
+// GetExpirationHandler which is called RefreshAhead before the object expires
+// as well, if the cache zone refreshes objects ahead and refresh is not nil.
+// If the object is popular enough at that time, refresh is run by the zone's
+// Refresher so that the object is still cached after its expiration moment.
+func GetRefreshingExpirationHandler(cz *types.CacheZone, id *types.ObjectID, refresh func()) func(types.Logger) {
 	return func(logger types.Logger) {
+		if obj, err := cz.Storage.GetMetadata(id); err == nil {
+			if utils.IsMetadataFresh(obj) {
+				expiresIn := time.Unix(obj.ExpiresAt, 0).Sub(time.Now())
+				if refresh == nil || expiresIn > cz.RefreshAhead {
+					// It was revalidated in the meantime
+					cz.Scheduler.AddEvent(id.Hash(), GetRefreshingExpirationHandler(cz, id, refresh),
+						ExpirationEventIn(cz, expiresIn, refresh != nil))
+					return
+				}
+				// the object expires as usual if it is not refreshed in time
+				cz.Scheduler.AddEvent(id.Hash(), GetRefreshingExpirationHandler(cz, id, nil), expiresIn)
+				if ObjectPopularity(cz, id) >= cz.RefreshPopularity {
+					if cz.Refresher.Refresh(id.Hash(), refresh) {
+						cz.Counters.ObjectRefreshed()
+					} else {
+						logger.Debugf("Object %s from zone %s was not refreshed ahead, %d refreshes are running",
+							id, cz.ID, cz.Refresher.Running())
+					}
+				}
+				return
+			}
+			if ShouldKeepStale(cz, obj) {
+				cz.Scheduler.AddEvent(id.Hash(), GetExpirationHandler(cz, id),
+					StaleUntil(cz, obj).Sub(time.Now()))
+				return
+			}
+		}
+
 		//!TODO: simplify and ignore the cache algorithm when expiring objects.
 		// It is only supposed to take into account client interest in the
 		// object parts, not whether they are expired due to upstream timeouts
@@ -15,11 +59,59 @@ func GetExpirationHandler(cz *types.CacheZone, id *types.ObjectID) func(types.Lo
 		}
 
 		cz.Algorithm.Remove(parts...)
+		cz.Tags.Remove(id)
 
-		//!TODO: make head request to upstream and possibly postpone the
-		// removal, if nothing has changed in the file
 		if err := cz.Storage.Discard(id); err != nil {
 			logger.Errorf("Error while discarding expired object %s from zone %s: %s", id, cz.ID, err)
 		}
 	}
 }
+
+// ShouldKeepStale returns whether the expired object should still be kept in
+// the storage of the cache zone so that it can be revalidated with the upstream
+// or served while it is being revalidated.
+func ShouldKeepStale(cz *types.CacheZone, obj *types.ObjectMetadata) bool {
+	return StaleUntil(cz, obj).After(time.Now())
+}
+
+// StaleUntil returns the time until which the object is kept in the storage of
+// the cache zone after it expires. Objects which can be revalidated with the
+// upstream and the primary objects of the varying responses are kept for the
+// zone's KeepStaleFor duration. All objects are kept for their
+// stale-while-revalidate and stale-if-error periods.
+func StaleUntil(cz *types.CacheZone, obj *types.ObjectMetadata) time.Time {
+	var keepFor = time.Duration(obj.StaleWhileRevalidate) * time.Second
+	if staleIfError := time.Duration(obj.StaleIfError) * time.Second; staleIfError > keepFor {
+		keepFor = staleIfError
+	}
+	if (cacheutils.HasValidators(obj.Headers) || len(obj.Vary) > 0) && cz.KeepStaleFor > keepFor {
+		keepFor = cz.KeepStaleFor
+	}
+	return time.Unix(obj.ExpiresAt, 0).Add(keepFor)
+}
+
+// ExpirationEventIn returns when the expiration handler of an object which
+// expires in expiresIn should be called. Objects which can be refreshed are
+// handled RefreshAhead before they expire, if the zone refreshes them.
+func ExpirationEventIn(cz *types.CacheZone, expiresIn time.Duration, refreshable bool) time.Duration {
+	if refreshable && cz.RefreshAhead > 0 && expiresIn > cz.RefreshAhead {
+		return expiresIn - cz.RefreshAhead
+	}
+	return expiresIn
+}
+
+// ObjectPopularity returns the popularity of the most popular cached part of
+// the object according to the cache algorithm of the zone.
+func ObjectPopularity(cz *types.CacheZone, id *types.ObjectID) float64 {
+	parts, err := cz.Storage.GetAvailableParts(id)
+	if err != nil {
+		return 0
+	}
+	var popularity float64
+	for _, idx := range parts {
+		if p := cz.Algorithm.Popularity(idx); p > popularity {
+			popularity = p
+		}
+	}
+	return popularity
+}
@@ -1,8 +1,86 @@
 package storage
 
-import "testing"
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/nedomi/types"
+)
 
 func TestStorageHelpers(t *testing.T) {
 	t.Parallel()
 	t.Skip("TODO: write tests...")
 }
+
+func TestStaleUntil(t *testing.T) {
+	t.Parallel()
+	var cz = &types.CacheZone{KeepStaleFor: time.Hour}
+	var expiresAt = time.Now().Add(-time.Minute).Unix()
+	var tests = []struct {
+		obj        *types.ObjectMetadata
+		keptFor    time.Duration
+		shouldKeep bool
+	}{
+		{
+			obj:        &types.ObjectMetadata{Headers: http.Header{}},
+			keptFor:    0,
+			shouldKeep: false,
+		}, {
+			obj:        &types.ObjectMetadata{Headers: http.Header{"Etag": {`"v1"`}}},
+			keptFor:    time.Hour,
+			shouldKeep: true,
+		}, {
+			obj:        &types.ObjectMetadata{Headers: http.Header{}, Vary: []string{"Origin"}},
+			keptFor:    time.Hour,
+			shouldKeep: true,
+		}, {
+			obj:        &types.ObjectMetadata{Headers: http.Header{}, StaleWhileRevalidate: 30},
+			keptFor:    30 * time.Second,
+			shouldKeep: false,
+		}, {
+			obj:        &types.ObjectMetadata{Headers: http.Header{}, StaleWhileRevalidate: 7200},
+			keptFor:    2 * time.Hour,
+			shouldKeep: true,
+		}, {
+			obj:        &types.ObjectMetadata{Headers: http.Header{}, StaleWhileRevalidate: 30, StaleIfError: 600},
+			keptFor:    10 * time.Minute,
+			shouldKeep: true,
+		},
+	}
+	for index, test := range tests {
+		test.obj.ExpiresAt = expiresAt
+		if got := StaleUntil(cz, test.obj); !got.Equal(time.Unix(expiresAt, 0).Add(test.keptFor)) {
+			t.Errorf("expected object %d to be kept for %s but it is kept until %s",
+				index, test.keptFor, got)
+		}
+		if got := ShouldKeepStale(cz, test.obj); got != test.shouldKeep {
+			t.Errorf("expected ShouldKeepStale to be %t for object %d", test.shouldKeep, index)
+		}
+	}
+}
+
+func TestExpirationEventIn(t *testing.T) {
+	t.Parallel()
+	var cz = &types.CacheZone{RefreshAhead: time.Minute}
+	var tests = []struct {
+		expiresIn   time.Duration
+		refreshable bool
+		expected    time.Duration
+	}{
+		{expiresIn: time.Hour, refreshable: true, expected: 59 * time.Minute},
+		{expiresIn: time.Hour, refreshable: false, expected: time.Hour},
+		{expiresIn: 30 * time.Second, refreshable: true, expected: 30 * time.Second},
+	}
+	for _, test := range tests {
+		if got := ExpirationEventIn(cz, test.expiresIn, test.refreshable); got != test.expected {
+			t.Errorf("expected the event for an object which expires in %s (refreshable %t) in %s but got %s",
+				test.expiresIn, test.refreshable, test.expected, got)
+		}
+	}
+
+	cz.RefreshAhead = 0
+	if got := ExpirationEventIn(cz, time.Hour, true); got != time.Hour {
+		t.Errorf("expected the event at the expiration when refreshing is disabled but got %s", got)
+	}
+}
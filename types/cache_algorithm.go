@@ -11,6 +11,10 @@ type CacheAlgorithm interface {
 	// ShouldKeep is called to signal that this ObjectIndex has been stored
 	ShouldKeep(*ObjectIndex) bool
 
+	// ShouldAdmit is called for every request for a cacheable object which is
+	// not in the cache. It returns whether the object should be stored.
+	ShouldAdmit(*ObjectID) bool
+
 	// AddObject adds this ObjectIndex to the cache. Returns an error when
 	// the object is in the cache already.
 	AddObject(*ObjectIndex) error
@@ -19,6 +23,11 @@ type CacheAlgorithm interface {
 	// to satisfy a client request
 	PromoteObject(*ObjectIndex)
 
+	// Popularity returns how popular this part of a file is with the clients,
+	// from 0 for parts which are not in the cache up to 1 for the most
+	// popular ones
+	Popularity(*ObjectIndex) float64
+
 	// ConsumedSize returns the full size of all files currently in the cache
 	ConsumedSize() BytesSize
 
@@ -0,0 +1,133 @@
+package types
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// CacheKeyTemplate describes which parts of the client requests, apart from
+// the path, make up the keys of the cached objects.
+type CacheKeyTemplate struct {
+	// Host includes the requested host in the key.
+	Host bool
+	// LowercasePath makes the keys case-insensitive to the path.
+	LowercasePath bool
+	// Query includes the query parameters in the key. If QueryWhitelist is
+	// set, only the parameters from it are included. The ones from the
+	// QueryBlacklist are never included. Names ending with `*` match all
+	// the parameters starting with them.
+	Query          bool
+	QueryWhitelist []string
+	QueryBlacklist []string
+	// SortQuery sorts the query parameters so that their order does not
+	// change the key.
+	SortQuery bool
+	// Headers and Cookies are the request headers and cookies whose values
+	// are included in the key.
+	Headers []string
+	Cookies []string
+}
+
+// keyEscaper escapes the separators of the key parts in the request path, so
+// that the paths cannot be mistaken for the headers and the cookies after them.
+var keyEscaper = strings.NewReplacer("%", "%25", "|", "%7C")
+
+// Key returns the key of the object for the request, which is used as the
+// path of its ObjectID. The header and cookie values are query escaped, so
+// that different requests cannot have the same key.
+func (t *CacheKeyTemplate) Key(req *http.Request) string {
+	var key bytes.Buffer
+	if t.Host {
+		host := req.Host
+		if host == "" {
+			host = req.URL.Host
+		}
+		key.WriteString(strings.ToLower(host))
+	}
+
+	if t.LowercasePath {
+		key.WriteString(keyEscaper.Replace(strings.ToLower(req.URL.Path)))
+	} else {
+		key.WriteString(keyEscaper.Replace(req.URL.Path))
+	}
+
+	if t.Query {
+		if query := t.query(req.URL); query != "" {
+			key.WriteByte('?')
+			key.WriteString(strings.Replace(query, "|", "%7C", -1))
+		}
+	}
+
+	for _, name := range t.Headers {
+		key.WriteString("|")
+		key.WriteString(strings.ToLower(name))
+		key.WriteByte('=')
+		for i, value := range req.Header[http.CanonicalHeaderKey(name)] {
+			if i > 0 {
+				key.WriteByte(',')
+			}
+			key.WriteString(url.QueryEscape(value))
+		}
+	}
+
+	for _, name := range t.Cookies {
+		key.WriteString("|cookie:")
+		key.WriteString(name)
+		key.WriteByte('=')
+		if cookie, err := req.Cookie(name); err == nil {
+			key.WriteString(url.QueryEscape(cookie.Value))
+		}
+	}
+
+	return key.String()
+}
+
+// query returns the raw query of the URL without the parameters which should
+// not be in the key.
+func (t *CacheKeyTemplate) query(u *url.URL) string {
+	if u.RawQuery == "" {
+		return ""
+	}
+
+	var params []string
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		name := param
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			name = param[:i]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if len(t.QueryWhitelist) > 0 && !matchesAny(t.QueryWhitelist, name) ||
+			matchesAny(t.QueryBlacklist, name) {
+			continue
+		}
+		params = append(params, param)
+	}
+
+	if t.SortQuery {
+		sort.Strings(params)
+	}
+	return strings.Join(params, "&")
+}
+
+// matchesAny returns whether the name matches any of the patterns. Patterns
+// ending with `*` match all the names starting with them.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(name, pattern[:len(pattern)-1]) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package types
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestCacheKeyTemplate(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		tmpl     CacheKeyTemplate
+		url      string
+		headers  http.Header
+		expected string
+	}{
+		{
+			tmpl:     CacheKeyTemplate{},
+			url:      "http://Example.com/Path/To?b=2&a=1",
+			expected: "/Path/To",
+		}, {
+			tmpl:     CacheKeyTemplate{Host: true, LowercasePath: true},
+			url:      "http://Example.com/Path/To?b=2&a=1",
+			expected: "example.com/path/to",
+		}, {
+			tmpl:     CacheKeyTemplate{Query: true},
+			url:      "http://example.com/path?b=2&a=1",
+			expected: "/path?b=2&a=1",
+		}, {
+			tmpl:     CacheKeyTemplate{Query: true, SortQuery: true},
+			url:      "http://example.com/path?b=2&a=1&ab=3",
+			expected: "/path?a=1&ab=3&b=2",
+		}, {
+			tmpl:     CacheKeyTemplate{Query: true, QueryBlacklist: []string{"utm_*", "fbclid"}},
+			url:      "http://example.com/path?utm_source=x&b=2&fbclid=3&utm_medium=y&a=1",
+			expected: "/path?b=2&a=1",
+		}, {
+			tmpl:     CacheKeyTemplate{Query: true, QueryWhitelist: []string{"id", "v*"}},
+			url:      "http://example.com/path?utm_source=x&id=2&version=3&vid&other=4",
+			expected: "/path?id=2&version=3&vid",
+		}, {
+			tmpl:     CacheKeyTemplate{Query: true, QueryWhitelist: []string{"id"}},
+			url:      "http://example.com/path?utm_source=x",
+			expected: "/path",
+		}, {
+			tmpl:     CacheKeyTemplate{Query: true, QueryBlacklist: []string{"a b"}},
+			url:      "http://example.com/path?a+b=1&a%20b=2&ab=3",
+			expected: "/path?ab=3",
+		}, {
+			tmpl:     CacheKeyTemplate{Headers: []string{"x-device", "Accept-Language"}},
+			url:      "http://example.com/path",
+			headers:  http.Header{"X-Device": {"mobile"}},
+			expected: "/path|x-device=mobile|accept-language=",
+		}, {
+			tmpl:     CacheKeyTemplate{Cookies: []string{"lang", "missing"}},
+			url:      "http://example.com/path",
+			headers:  http.Header{"Cookie": {"session=1234; lang=en"}},
+			expected: "/path|cookie:lang=en|cookie:missing=",
+		}, {
+			tmpl:     CacheKeyTemplate{Query: true, Headers: []string{"x-device"}},
+			url:      "http://example.com/100%25|x?a=|",
+			headers:  http.Header{"X-Device": {"mobile phone", "a,b|c"}},
+			expected: "/100%25%7Cx?a=%7C|x-device=mobile+phone,a%2Cb%7Cc",
+		},
+	}
+	for index, test := range tests {
+		req, err := http.NewRequest("GET", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.headers != nil {
+			req.Header = test.headers
+		}
+		if got := test.tmpl.Key(req); got != test.expected {
+			t.Errorf("test %d: expected key '%s' but got '%s'", index, test.expected, got)
+		}
+	}
+}
+
+func TestCacheKeyTemplateCollisions(t *testing.T) {
+	t.Parallel()
+	var tmpl = CacheKeyTemplate{Headers: []string{"x-device"}, Cookies: []string{"lang"}}
+	var tests = []struct {
+		first, second *http.Request
+	}{
+		{
+			first:  &http.Request{URL: &url.URL{Path: "/path"}, Header: http.Header{"X-Device": {"a,b"}}},
+			second: &http.Request{URL: &url.URL{Path: "/path"}, Header: http.Header{"X-Device": {"a", "b"}}},
+		}, {
+			first:  &http.Request{URL: &url.URL{Path: "/path"}, Header: http.Header{"X-Device": {"a|cookie:lang=en"}}},
+			second: &http.Request{URL: &url.URL{Path: "/path"}, Header: http.Header{"X-Device": {"a"}, "Cookie": {"lang=en"}}},
+		}, {
+			first:  &http.Request{URL: &url.URL{Path: "/path|x-device=a"}, Header: http.Header{}},
+			second: &http.Request{URL: &url.URL{Path: "/path"}, Header: http.Header{"X-Device": {"a|x-device="}}},
+		}, {
+			first:  &http.Request{URL: &url.URL{Path: "/path%7C"}, Header: http.Header{}},
+			second: &http.Request{URL: &url.URL{Path: "/path|"}, Header: http.Header{}},
+		},
+	}
+	for index, test := range tests {
+		if first, second := tmpl.Key(test.first), tmpl.Key(test.second); first == second {
+			t.Errorf("test %d: expected different keys for different requests but both are '%s'", index, first)
+		}
+	}
+}
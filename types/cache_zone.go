@@ -1,11 +1,83 @@
 package types
 
+import (
+	"sync/atomic"
+	"time"
+)
+
 // CacheZone is the combination of a Storage for storing object parts and an
 // `CacheAlgorithm` which determines what should be stored.
 type CacheZone struct {
+	// Counters is first so that its 64-bit counters are aligned for the
+	// atomic operations on 32-bit platforms.
+	Counters  CacheZoneCounters
 	ID        string
 	PartSize  BytesSize
 	Algorithm CacheAlgorithm
 	Scheduler Scheduler
 	Storage   Storage
+	// KeepStaleFor is the duration for which expired objects that can be
+	// revalidated with the upstream are kept in the storage.
+	KeepStaleFor time.Duration
+	// Tags indexes the cached objects by the tags from their upstream
+	// responses, so that they can be purged together.
+	Tags *TagIndex
+	// RefreshAhead is how long before their expiration the popular objects
+	// are refreshed in the background. Zero disables the refreshing.
+	RefreshAhead time.Duration
+	// RefreshPopularity is the minimum popularity, as reported by the cache
+	// algorithm, of the objects which are refreshed ahead.
+	RefreshPopularity float64
+	// Refresher runs the background refreshes of the objects in the zone.
+	Refresher Refresher
+	// RefreshJobs makes the jobs which refresh the objects that are loaded
+	// from the storage, by the cache handlers of their locations.
+	RefreshJobs *RefreshJobs
+	// ReadAheads runs the background downloads of the parts which are read
+	// ahead of the clients, with their own concurrency limit.
+	ReadAheads Refresher
+}
+
+// CacheZoneCounters counts notable events in a cache zone. It is safe for
+// concurrent use.
+type CacheZoneCounters struct {
+	changedObjects   uint64
+	corruptedParts   uint64
+	refreshedObjects uint64
+}
+
+// ObjectChanged counts an object which was discarded because it changed in
+// the upstream while it was being assembled from parts.
+func (c *CacheZoneCounters) ObjectChanged() uint64 {
+	return atomic.AddUint64(&c.changedObjects, 1)
+}
+
+// ChangedObjects returns the number of objects which were discarded because
+// they changed in the upstream while they were being assembled from parts.
+func (c *CacheZoneCounters) ChangedObjects() uint64 {
+	return atomic.LoadUint64(&c.changedObjects)
+}
+
+// PartCorrupted counts an object part which was discarded because its
+// contents in the storage did not match their checksum.
+func (c *CacheZoneCounters) PartCorrupted() uint64 {
+	return atomic.AddUint64(&c.corruptedParts, 1)
+}
+
+// CorruptedParts returns the number of object parts which were discarded
+// because their contents in the storage did not match their checksum.
+func (c *CacheZoneCounters) CorruptedParts() uint64 {
+	return atomic.LoadUint64(&c.corruptedParts)
+}
+
+// ObjectRefreshed counts an object which was refreshed in the background
+// before it expired.
+func (c *CacheZoneCounters) ObjectRefreshed() uint64 {
+	return atomic.AddUint64(&c.refreshedObjects, 1)
+}
+
+// RefreshedObjects returns the number of objects which were refreshed in the
+// background before they expired.
+func (c *CacheZoneCounters) RefreshedObjects() uint64 {
+	return atomic.LoadUint64(&c.refreshedObjects)
 }
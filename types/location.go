@@ -12,7 +12,11 @@ type Location struct {
 	Handler               http.Handler
 	CacheKey              string
 	CacheDefaultDuration  time.Duration
+	StaleWhileRevalidate  time.Duration
+	StaleIfError          time.Duration
+	NegativeCacheTTLs     map[int]time.Duration
 	CacheKeyIncludesQuery bool
+	CacheKeyTemplate      *CacheKeyTemplate
 	Cache                 *CacheZone //!TODO: move to the cache handler settings (plus all Cache* settings)
 	Upstream              Upstream
 	Logger                Logger
@@ -24,8 +28,23 @@ func (l *Location) String() string {
 
 // NewObjectIDForURL returns new ObjectID from the provided URL
 func (l *Location) NewObjectIDForURL(u *url.URL) *ObjectID {
+	return l.NewObjectIDForRequest(&http.Request{URL: u, Host: u.Host, Header: make(http.Header)})
+}
+
+// NewObjectIDForRequest returns new ObjectID for the object requested by the
+// provided request.
+func (l *Location) NewObjectIDForRequest(req *http.Request) *ObjectID {
+	return l.NewObjectIDForVariant(req, "")
+}
+
+// NewObjectIDForVariant returns new ObjectID for the variant of the object for
+// the provided request. An empty variant means the object itself.
+func (l *Location) NewObjectIDForVariant(req *http.Request, variant string) *ObjectID {
+	if l.CacheKeyTemplate != nil {
+		return NewVariantObjectID(l.CacheKey, l.CacheKeyTemplate.Key(req), variant)
+	}
 	if l.CacheKeyIncludesQuery {
-		return NewObjectID(l.CacheKey, u.String())
+		return NewVariantObjectID(l.CacheKey, req.URL.String(), variant)
 	}
-	return NewObjectID(l.CacheKey, u.Path)
+	return NewVariantObjectID(l.CacheKey, req.URL.Path, variant)
 }
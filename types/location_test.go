@@ -1,6 +1,7 @@
 package types
 
 import (
+	"net/http"
 	"net/url"
 	"testing"
 )
@@ -42,3 +43,29 @@ func TestNewObjectIDForURL(t *testing.T) {
 	}
 
 }
+
+func TestNewObjectIDForRequestWithTemplate(t *testing.T) {
+	var l = &Location{
+		CacheKey: "1",
+		CacheKeyTemplate: &CacheKeyTemplate{
+			Query:          true,
+			SortQuery:      true,
+			QueryBlacklist: []string{"utm_*"},
+		},
+	}
+	var urls = []string{
+		"/path?b=2&a=1",
+		"/path?a=1&utm_source=newsletter&b=2",
+		"/path?utm_campaign=spring&b=2&a=1",
+	}
+	var expected = NewObjectID("1", "/path?a=1&b=2")
+	for _, uString := range urls {
+		req, err := http.NewRequest("GET", uString, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := l.NewObjectIDForRequest(req); *got != *expected {
+			t.Errorf("expected '%s' got '%s' for url '%s'", expected, got, uString)
+		}
+	}
+}
@@ -18,11 +18,14 @@ type ObjectIDHash [ObjectIDHashSize]byte
 type ObjectID struct {
 	cacheKey string
 	path     string
+	variant  string
 	hash     ObjectIDHash
-	//!TODO: add vary headers information
 }
 
 func (oid *ObjectID) String() string {
+	if oid.variant != "" {
+		return fmt.Sprintf("{%x:%s:%s:%q}", oid.Hash(), oid.cacheKey, oid.path, oid.variant)
+	}
 	return fmt.Sprintf("{%x:%s:%s}", oid.Hash(), oid.cacheKey, oid.path)
 }
 
@@ -36,6 +39,12 @@ func (oid *ObjectID) Path() string {
 	return oid.path
 }
 
+// Variant returns the object's variant key. It is empty for objects which are
+// not variants of another object.
+func (oid *ObjectID) Variant() string {
+	return oid.variant
+}
+
 // Hash returns the pre-calculated sha1 hash of the object id.
 func (oid *ObjectID) Hash() ObjectIDHash {
 	return oid.hash
@@ -48,6 +57,9 @@ func (oid *ObjectID) StrHash() string {
 
 // MarshalJSON is used to help the JSON library marshal the unexported vars.
 func (oid *ObjectID) MarshalJSON() ([]byte, error) {
+	if oid.variant != "" {
+		return json.Marshal([]string{oid.cacheKey, oid.path, oid.variant})
+	}
 	return json.Marshal([]string{oid.cacheKey, oid.path})
 }
 
@@ -58,9 +70,16 @@ func (oid *ObjectID) UnmarshalJSON(buf []byte) error {
 		return err
 	}
 
-	if len(data) != 2 || data[0] == "" || data[1] == "" {
+	if len(data) < 2 || len(data) > 3 || data[0] == "" || data[1] == "" {
 		return fmt.Errorf("Invalid ObjectID %s", buf)
 	}
+	if len(data) == 3 {
+		if data[2] == "" {
+			return fmt.Errorf("Invalid ObjectID %s", buf)
+		}
+		*oid = *NewVariantObjectID(data[0], data[1], data[2])
+		return nil
+	}
 	*oid = *NewObjectID(data[0], data[1])
 	return nil
 }
@@ -73,3 +92,17 @@ func NewObjectID(cacheKey, path string) *ObjectID {
 		hash:     sha1.Sum([]byte(cacheKey + "/" + path)),
 	}
 }
+
+// NewVariantObjectID creates and returns a new ObjectID for one of the
+// variants of the object with the supplied cache key and path.
+func NewVariantObjectID(cacheKey, path, variant string) *ObjectID {
+	if variant == "" {
+		return NewObjectID(cacheKey, path)
+	}
+	return &ObjectID{
+		cacheKey: cacheKey,
+		path:     path,
+		variant:  variant,
+		hash:     sha1.Sum([]byte(cacheKey + "/" + path + "\x00" + variant)),
+	}
+}
@@ -44,7 +44,8 @@ func TestObjectIDJsonHandling(t *testing.T) {
 func TestObjectIDJsonErrors(t *testing.T) {
 	t.Parallel()
 	wrongStrings := []string{"", "[]", "{}", "[\"test\"]", "[\"\",\"\"]",
-		"[\"test\",\"\"]", "[\"\",\"test\"]", "\"test\""}
+		"[\"test\",\"\"]", "[\"\",\"test\"]", "\"test\"",
+		"[\"test\",\"test\",\"\"]", "[\"a\",\"b\",\"c\",\"d\"]"}
 
 	tmp := &ObjectID{}
 	for _, v := range wrongStrings {
@@ -76,3 +77,31 @@ func TestObjectIDStringersWithSensibleData(t *testing.T) {
 	}
 
 }
+
+func TestVariantObjectID(t *testing.T) {
+	t.Parallel()
+	obj := NewObjectID("1.2", "/somewhere")
+	variant := NewVariantObjectID("1.2", "/somewhere", "Origin:example.com")
+	if obj.Hash() == variant.Hash() {
+		t.Error("The variant has the same hash as the original object")
+	}
+	if *NewVariantObjectID("1.2", "/somewhere", "") != *obj {
+		t.Error("An empty variant should be the same as the original object")
+	}
+
+	resM, err := json.Marshal(variant)
+	if err != nil {
+		t.Fatalf("Could not marshal ObjectID: %s", err)
+	}
+	resU := &ObjectID{}
+	if err := json.Unmarshal(resM, resU); err != nil {
+		t.Fatalf("Could not unmarshal ObjectID: %s", err)
+	}
+	if !reflect.DeepEqual(variant, resU) {
+		t.Fatalf("The original object %#v is different from the unmarshalled %#v", variant, resU)
+	}
+
+	if result := variant.String(); !strings.Contains(result, "Origin:example.com") {
+		t.Errorf("The result '%s' does not contain the variant", result)
+	}
+}
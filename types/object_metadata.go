@@ -28,4 +28,30 @@ type ObjectMetadata struct {
 	// The time at which this object can be considered stale. After this time
 	// the object must be revalidated or discarded. This value is a unix timestamp.
 	ExpiresAt int64
+
+	// The number of seconds after ExpiresAt during which the stale object can
+	// still be served while it is revalidated in the background.
+	StaleWhileRevalidate int64
+
+	// The number of seconds after ExpiresAt during which the stale object can
+	// still be served if the upstream fails to revalidate it.
+	StaleIfError int64
+
+	// The request headers listed in the upstream's Vary header. If there are
+	// any, this object has no contents of its own - the responses are stored
+	// as separate variant objects, keyed by the values of these headers.
+	Vary []string
+
+	// The variant keys of all the cached variants of this object.
+	Variants []string
+
+	// The tags (surrogate keys) from the Surrogate-Key and Cache-Tag headers
+	// of the upstream response. They are used for purging groups of objects.
+	Tags []string
+
+	// The URL of the upstream request for this object and the request headers
+	// which select its contents. They are used for refreshing the object in
+	// the background, even after it is loaded from the storage on startup.
+	RequestURL     string
+	RequestHeaders http.Header
 }
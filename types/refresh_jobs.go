@@ -0,0 +1,49 @@
+package types
+
+import "sync"
+
+// RefreshJobs makes the jobs which refresh the objects of a cache zone from
+// their stored metadata. The cache handlers of the locations register how
+// they refresh the objects with their cache keys, so that the zone can
+// refresh the objects which are loaded from the storage on startup, before
+// any requests for them. It is safe for concurrent use.
+type RefreshJobs struct {
+	sync.RWMutex
+	makers map[string]func(*ObjectMetadata) func()
+}
+
+// NewRefreshJobs returns a new RefreshJobs without registered handlers.
+func NewRefreshJobs() *RefreshJobs {
+	return &RefreshJobs{makers: make(map[string]func(*ObjectMetadata) func())}
+}
+
+// Register sets the function which makes the refresh jobs for the objects
+// with the cache key. It may return nil for the objects it cannot refresh.
+func (rj *RefreshJobs) Register(cacheKey string, maker func(*ObjectMetadata) func()) {
+	rj.Lock()
+	defer rj.Unlock()
+	rj.makers[cacheKey] = maker
+}
+
+// Job returns the job which refreshes the object. The job is made by the
+// function which is registered for the object's cache key when the job is
+// run, as the cache handlers may not be registered when the objects are
+// loaded. It returns nil for the objects without a stored request and for
+// the primary objects of the varying ones, which have no contents of their
+// own. The metadata should not be changed afterwards.
+func (rj *RefreshJobs) Job(obj *ObjectMetadata) func() {
+	if obj.RequestURL == "" || len(obj.Vary) > 0 {
+		return nil
+	}
+	return func() {
+		rj.RLock()
+		maker, ok := rj.makers[obj.ID.CacheKey()]
+		rj.RUnlock()
+		if !ok {
+			return
+		}
+		if job := maker(obj); job != nil {
+			job()
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package types
+
+import "testing"
+
+func TestRefreshJobs(t *testing.T) {
+	t.Parallel()
+	rj := NewRefreshJobs()
+	obj := &ObjectMetadata{ID: NewObjectID("key", "/path"), RequestURL: "/path"}
+	for _, unrefreshable := range []*ObjectMetadata{
+		{ID: obj.ID},
+		{ID: obj.ID, RequestURL: "/path", Vary: []string{"Accept-Language"}},
+	} {
+		if rj.Job(unrefreshable) != nil {
+			t.Errorf("Expected no refresh job for %#v", unrefreshable)
+		}
+	}
+
+	job := rj.Job(obj)
+	if job == nil {
+		t.Fatal("Expected a refresh job for the object with a stored request")
+	}
+	// the job does nothing until a handler is registered for the cache key
+	job()
+
+	var refreshed []*ObjectMetadata
+	rj.Register("other", func(*ObjectMetadata) func() {
+		t.Error("Expected the handler of another cache key not to be used")
+		return nil
+	})
+	rj.Register("key", func(obj *ObjectMetadata) func() {
+		return func() { refreshed = append(refreshed, obj) }
+	})
+	job()
+	if len(refreshed) != 1 || refreshed[0] != obj {
+		t.Errorf("Expected the object to be refreshed once by the registered handler but got %v", refreshed)
+	}
+}
@@ -0,0 +1,17 @@
+package types
+
+// Refresher runs the jobs which refresh cached objects in the background,
+// with a limit on how many of them run at the same time.
+type Refresher interface {
+	// Refresh starts the job for the object with the supplied key unless
+	// there is already one running for it or the limit of concurrent jobs is
+	// reached. It returns whether the job was started.
+	Refresh(key ObjectIDHash, job func()) bool
+
+	// Running returns the number of jobs which are currently running.
+	Running() int
+
+	// Stop makes the Refresher drop all new jobs and waits for the running
+	// ones to finish.
+	Stop()
+}
@@ -1,6 +1,10 @@
 package types
 
-import "io"
+import (
+	"hash"
+	"hash/crc32"
+	"io"
+)
 
 // Storage represents a single unit of storage.
 type Storage interface {
@@ -26,6 +30,10 @@ type Storage interface {
 	// Saves the contents of the supplied object part to the storage.
 	SavePart(index *ObjectIndex, data io.Reader) error
 
+	// Returns the checksum of the specified part, recorded when it was saved.
+	// If there is no checksum for the part, it will return os.ErrNotExist.
+	GetPartChecksum(index *ObjectIndex) (uint32, error)
+
 	// Discard an object and its metadata from the storage.
 	Discard(id *ObjectID) error
 
@@ -42,4 +50,28 @@ type Storage interface {
 	SetLogger(Logger)
 }
 
+// PopularityAware is implemented by the storages which treat the object parts
+// differently depending on how popular they are with the clients.
+type PopularityAware interface {
+	// SetPopularity sets the function which returns the popularity of a part,
+	// from 0 to 1, usually the Popularity method of the cache algorithm.
+	SetPopularity(func(*ObjectIndex) float64)
+}
+
+// MetadataCaching is implemented by the storages which keep the metadata of
+// the recently used objects in memory.
+type MetadataCaching interface {
+	// MetadataCacheStats returns how many of the metadata requests were served
+	// from memory and how many requests there were.
+	MetadataCacheStats() (hits, requests uint64)
+}
+
+var partChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// NewPartHash returns a new hash for calculating the checksums of object parts
+// (CRC32C).
+func NewPartHash() hash.Hash32 {
+	return crc32.New(partChecksumTable)
+}
+
 //!TODO: use custom error type instead of os.ErrNotExist?
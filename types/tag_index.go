@@ -0,0 +1,77 @@
+package types
+
+import "sync"
+
+// TagIndex maps the tags (surrogate keys) of the cached objects to their IDs,
+// so that all the objects with a tag can be found. It is safe for concurrent
+// use.
+type TagIndex struct {
+	sync.RWMutex
+	objects map[string]map[ObjectIDHash]*ObjectID
+	tags    map[ObjectIDHash][]string
+}
+
+// NewTagIndex returns a new empty TagIndex.
+func NewTagIndex() *TagIndex {
+	return &TagIndex{
+		objects: make(map[string]map[ObjectIDHash]*ObjectID),
+		tags:    make(map[ObjectIDHash][]string),
+	}
+}
+
+// Set replaces the tags of the object. Objects without tags are removed from
+// the index.
+func (ti *TagIndex) Set(id *ObjectID, tags ...string) {
+	ti.Lock()
+	defer ti.Unlock()
+	hash := id.Hash()
+	ti.remove(hash)
+	if len(tags) == 0 {
+		return
+	}
+
+	ti.tags[hash] = tags
+	for _, tag := range tags {
+		ids, ok := ti.objects[tag]
+		if !ok {
+			ids = make(map[ObjectIDHash]*ObjectID)
+			ti.objects[tag] = ids
+		}
+		ids[hash] = id
+	}
+}
+
+// Remove removes the object from the index.
+func (ti *TagIndex) Remove(id *ObjectID) {
+	ti.Lock()
+	defer ti.Unlock()
+	ti.remove(id.Hash())
+}
+
+func (ti *TagIndex) remove(hash ObjectIDHash) {
+	for _, tag := range ti.tags[hash] {
+		delete(ti.objects[tag], hash)
+		if len(ti.objects[tag]) == 0 {
+			delete(ti.objects, tag)
+		}
+	}
+	delete(ti.tags, hash)
+}
+
+// Objects returns the IDs of all the objects with the tag.
+func (ti *TagIndex) Objects(tag string) []*ObjectID {
+	ti.RLock()
+	defer ti.RUnlock()
+	ids := make([]*ObjectID, 0, len(ti.objects[tag]))
+	for _, id := range ti.objects[tag] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Len returns the number of tags in the index.
+func (ti *TagIndex) Len() int {
+	ti.RLock()
+	defer ti.RUnlock()
+	return len(ti.objects)
+}
@@ -0,0 +1,55 @@
+package types
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func tagObjectPaths(ti *TagIndex, tag string) []string {
+	var paths []string
+	for _, id := range ti.Objects(tag) {
+		paths = append(paths, id.Path())
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestTagIndex(t *testing.T) {
+	t.Parallel()
+	ti := NewTagIndex()
+	first := NewObjectID("key", "/first")
+	second := NewObjectID("key", "/second")
+	ti.Set(first, "a", "b")
+	ti.Set(second, "b", "c")
+
+	var expected = map[string][]string{
+		"a": {"/first"},
+		"b": {"/first", "/second"},
+		"c": {"/second"},
+		"d": nil,
+	}
+	for tag, paths := range expected {
+		if got := tagObjectPaths(ti, tag); !reflect.DeepEqual(got, paths) {
+			t.Errorf("expected the objects with tag %s to be %v but got %v", tag, paths, got)
+		}
+	}
+
+	// the new tags replace the old ones
+	ti.Set(first, "c")
+	if got := tagObjectPaths(ti, "b"); len(got) != 1 || got[0] != "/second" {
+		t.Errorf("expected only /second to have tag b but got %v", got)
+	}
+	if got := tagObjectPaths(ti, "c"); len(got) != 2 {
+		t.Errorf("expected both objects to have tag c but got %v", got)
+	}
+	if ti.Len() != 2 {
+		t.Errorf("expected the unused tag a to be removed but there are %d tags", ti.Len())
+	}
+
+	ti.Remove(second)
+	ti.Set(first)
+	if ti.Len() != 0 {
+		t.Errorf("expected the index to be empty but there are %d tags", ti.Len())
+	}
+}
@@ -11,39 +11,107 @@ import (
 
 // CacheSatisfiesRequest returs whether the client allows the requested content
 // to be retrieved from the cache and whether the cache we have is fresh enough
-// to be used for handling the request.
+// to be used for handling the request. The max-age, min-fresh and max-stale
+// directives of the request are evaluated against the age of the object and
+// its expiration time. Stale objects satisfy only the requests which accept
+// them with max-stale.
 func CacheSatisfiesRequest(obj *types.ObjectMetadata, req *http.Request) bool {
-	//!TODO: improve; implement something like github.com/pquerna/cachecontrol but better
-	//!TODO: write unit tests
+	return cacheSatisfiesRequest(obj, req, false)
+}
+
+// CacheSatisfiesRequestWhileRevalidating is like CacheSatisfiesRequest but the
+// stale object does not have to be accepted with max-stale, because it is in
+// its stale-while-revalidate period.
+func CacheSatisfiesRequestWhileRevalidating(obj *types.ObjectMetadata, req *http.Request) bool {
+	return cacheSatisfiesRequest(obj, req, true)
+}
+
+func cacheSatisfiesRequest(obj *types.ObjectMetadata, req *http.Request, acceptStale bool) bool {
+	//!TODO: handle `Pragma: no-cache` from HTTP/1.0 clients
+	dir := parseRequestDirectives(req)
+	if dir.noCache || dir.noStore {
+		return false
+	}
 
-	//!TODO: handle cases with respect to https://tools.ietf.org/html/rfc7232
+	now := time.Now().Unix()
+	age := now - obj.ResponseTimestamp
+	freshFor := obj.ExpiresAt - now
+	if dir.maxAge >= 0 && age > dir.maxAge {
+		return false
+	}
+	if dir.minFresh >= 0 && freshFor < dir.minFresh {
+		return false
+	}
+	if freshFor > 0 || acceptStale || dir.maxStaleAny {
+		return true
+	}
+	return dir.maxStale >= 0 && -freshFor <= dir.maxStale
+}
 
-	reqDir, _ := cacheobject.ParseRequestCacheControl(req.Header.Get("Cache-Control"))
-	return !(reqDir.NoCache || reqDir.NoStore)
+// OnlyIfCached returns whether the client wants to be served only from the
+// cache. Such requests should not be passed to the upstream:
+// https://tools.ietf.org/html/rfc7234#section-5.2.1.7
+func OnlyIfCached(req *http.Request) bool {
+	return parseRequestDirectives(req).onlyIfCached
 }
 
 // IsResponseCacheable returs whether the upstream server allows the requested
 // content to be saved in the cache. True result and 0 duration means that the
 // response has no expiry date.
 func IsResponseCacheable(code int, headers http.Header) bool {
+	return IsEncodedResponseCacheable(code, headers)
+}
+
+// IsEncodedResponseCacheable is like IsResponseCacheable but responses with
+// any of the supplied content encodings are cacheable as well.
+func IsEncodedResponseCacheable(code int, headers http.Header, encodings ...string) bool {
 	//!TODO: write a better custom implementation or fork the cacheobject - the API sucks
-	//!TODO: correctly handle cache-control, pragma, etag and vary headers
+	//!TODO: correctly handle cache-control, pragma and etag headers
 	//!TODO: write unit tests
 
 	if code != http.StatusOK && code != http.StatusPartialContent {
 		return false
 	}
 
-	// For now, we do not cache encoded responses
-	if headers.Get("Content-Encoding") != "" {
+	return headersAllowCaching(headers, encodings...)
+}
+
+// IsNegativeResponseCacheable returns whether the upstream server allows the
+// redirect or error response to be saved in the cache. Such responses are
+// cached only when it is configured for their status code.
+func IsNegativeResponseCacheable(code int, headers http.Header, encodings ...string) bool {
+	if code < http.StatusMultipleChoices || code == http.StatusNotModified {
 		return false
 	}
 
+	return headersAllowCaching(headers, encodings...)
+}
+
+// headersAllowCaching returns whether the upstream response headers allow the
+// response to be saved in the cache.
+func headersAllowCaching(headers http.Header, encodings ...string) bool {
+	if encoding := headers.Get("Content-Encoding"); encoding != "" {
+		var allowed bool
+		for _, e := range encodings {
+			allowed = allowed || strings.EqualFold(encoding, e)
+		}
+		if !allowed {
+			return false
+		}
+	}
+
 	// We do not cache multipart range responses
 	if strings.Contains(headers.Get("Content-Type"), "multipart/byteranges") {
 		return false
 	}
 
+	// Responses which vary on anything but the request headers cannot be reused
+	for _, header := range ParseVary(headers) {
+		if header == "*" {
+			return false
+		}
+	}
+
 	respDir, err := cacheobject.ParseResponseCacheControl(headers.Get("Cache-Control"))
 	if err != nil || respDir.NoCachePresent || respDir.NoStore || respDir.PrivatePresent {
 		return false
@@ -52,6 +120,37 @@ func IsResponseCacheable(code int, headers http.Header) bool {
 	return true
 }
 
+// HasValidators returns whether the supplied cached object headers contain
+// validators which can be used for making conditional requests to the upstream.
+func HasValidators(headers http.Header) bool {
+	return headers.Get("ETag") != "" || headers.Get("Last-Modified") != ""
+}
+
+// ResponseTags returns the tags (surrogate keys) of the upstream response
+// from its space separated Surrogate-Key and comma separated Cache-Tag headers.
+func ResponseTags(headers http.Header) []string {
+	var tags []string
+	var seen = make(map[string]struct{})
+	var add = func(tag string) {
+		if _, ok := seen[tag]; tag != "" && !ok {
+			seen[tag] = struct{}{}
+			tags = append(tags, tag)
+		}
+	}
+
+	for _, value := range headers["Surrogate-Key"] {
+		for _, tag := range strings.Fields(value) {
+			add(tag)
+		}
+	}
+	for _, value := range headers["Cache-Tag"] {
+		for _, tag := range strings.Split(value, ",") {
+			add(strings.TrimSpace(tag))
+		}
+	}
+	return tags
+}
+
 // ResponseExpiresIn parses the expiration time from upstream headers, if any, and returns
 // it as a duration from now. If no expire time is found, it returns its second argument:
 // the default expiration time.
@@ -79,3 +178,27 @@ func ResponseExpiresIn(headers http.Header, ifNotAny time.Duration) time.Duratio
 
 	return ifNotAny
 }
+
+// ResponseStaleWhileRevalidate returns the stale-while-revalidate duration from
+// the upstream Cache-Control header. If there is no such directive, it returns
+// its second argument: the default duration.
+func ResponseStaleWhileRevalidate(headers http.Header, ifNotAny time.Duration) time.Duration {
+	respDir, err := cacheobject.ParseResponseCacheControl(headers.Get("Cache-Control"))
+	if err != nil || respDir.StaleWhileRevalidate < 0 {
+		return ifNotAny
+	}
+
+	return time.Duration(respDir.StaleWhileRevalidate) * time.Second
+}
+
+// ResponseStaleIfError returns the stale-if-error duration from the upstream
+// Cache-Control header. If there is no such directive, it returns its second
+// argument: the default duration.
+func ResponseStaleIfError(headers http.Header, ifNotAny time.Duration) time.Duration {
+	respDir, err := cacheobject.ParseResponseCacheControl(headers.Get("Cache-Control"))
+	if err != nil || respDir.StaleIfError < 0 {
+		return ifNotAny
+	}
+
+	return time.Duration(respDir.StaleIfError) * time.Second
+}
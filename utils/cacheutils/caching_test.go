@@ -6,8 +6,11 @@ import (
 	"io"
 	"net/http"
 	"net/textproto"
+	"reflect"
 	"testing"
 	"time"
+
+	"github.com/ironsmile/nedomi/types"
 )
 
 var responseCacheabilityMatrix = []struct {
@@ -34,6 +37,17 @@ var responseCacheabilityMatrix = []struct {
 		headers:   `Cache-Control: private`,
 		cacheable: false,
 	},
+	{
+		code:      http.StatusOK,
+		headers:   `Vary: Accept-Language, Origin`,
+		cacheable: true,
+		expiresIN: time.Hour,
+	},
+	{
+		code:      http.StatusOK,
+		headers:   `Vary: Origin, *`,
+		cacheable: false,
+	},
 	{
 		code:      http.StatusOK,
 		headers:   `Cache-Control: max-age`,
@@ -110,6 +124,48 @@ func TestIsResponseCacheable(t *testing.T) {
 	}
 }
 
+func TestIsEncodedResponseCacheable(t *testing.T) {
+	t.Parallel()
+	var tests = map[string]bool{
+		"":        true,
+		"gzip":    true,
+		"GZIP":    true,
+		"deflate": true,
+		"br":      false,
+	}
+	for encoding, expected := range tests {
+		headers := http.Header{"Content-Encoding": []string{encoding}}
+		if got := IsEncodedResponseCacheable(http.StatusOK, headers, "gzip", "deflate"); got != expected {
+			t.Errorf("expected cacheable to be %t for encoding '%s' but it was %t", expected, encoding, got)
+		}
+	}
+	if IsResponseCacheable(http.StatusOK, http.Header{"Content-Encoding": []string{"gzip"}}) {
+		t.Error("encoded responses should not be cacheable without allowing the encoding")
+	}
+}
+
+func TestIsNegativeResponseCacheable(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		code      int
+		headers   http.Header
+		cacheable bool
+	}{
+		{code: http.StatusNotFound, headers: http.Header{}, cacheable: true},
+		{code: http.StatusFound, headers: http.Header{"Location": {"/"}}, cacheable: true},
+		{code: http.StatusBadGateway, headers: http.Header{}, cacheable: true},
+		{code: http.StatusOK, headers: http.Header{}, cacheable: false},
+		{code: http.StatusNotModified, headers: http.Header{}, cacheable: false},
+		{code: http.StatusNotFound, headers: http.Header{"Cache-Control": {"no-store"}}, cacheable: false},
+		{code: http.StatusNotFound, headers: http.Header{"Content-Encoding": {"gzip"}}, cacheable: false},
+	}
+	for index, test := range tests {
+		if got := IsNegativeResponseCacheable(test.code, test.headers); got != test.cacheable {
+			t.Errorf("expected cacheable to be %t for test %d but it was %t", test.cacheable, index, got)
+		}
+	}
+}
+
 func TestResponseExpiresInDurationParsing(t *testing.T) {
 	t.Parallel()
 	for index, test := range responseCacheabilityMatrix {
@@ -127,3 +183,127 @@ func TestResponseExpiresInDurationParsing(t *testing.T) {
 		}
 	}
 }
+
+func TestResponseStaleWhileRevalidate(t *testing.T) {
+	t.Parallel()
+	var tests = map[string]time.Duration{
+		"":                                      time.Minute,
+		"max-age=30":                            time.Minute,
+		"max-age=30, stale-while-revalidate=90": 90 * time.Second,
+		"stale-while-revalidate=0":              0,
+		"stale-while-revalidate=baba":           time.Minute,
+	}
+	for cacheControl, expected := range tests {
+		headers := http.Header{"Cache-Control": []string{cacheControl}}
+		if got := ResponseStaleWhileRevalidate(headers, time.Minute); got != expected {
+			t.Errorf("expected %s for '%s' but got %s", expected, cacheControl, got)
+		}
+	}
+}
+
+func TestResponseStaleIfError(t *testing.T) {
+	t.Parallel()
+	var tests = map[string]time.Duration{
+		"":                                      time.Hour,
+		"max-age=30, stale-while-revalidate=90": time.Hour,
+		"max-age=30, stale-if-error=600":        10 * time.Minute,
+	}
+	for cacheControl, expected := range tests {
+		headers := http.Header{"Cache-Control": []string{cacheControl}}
+		if got := ResponseStaleIfError(headers, time.Hour); got != expected {
+			t.Errorf("expected %s for '%s' but got %s", expected, cacheControl, got)
+		}
+	}
+}
+
+func TestCacheSatisfiesRequest(t *testing.T) {
+	t.Parallel()
+	var now = time.Now()
+	var fresh = &types.ObjectMetadata{ // 100s old, fresh for 200s more
+		ResponseTimestamp: now.Add(-100 * time.Second).Unix(),
+		ExpiresAt:         now.Add(200 * time.Second).Unix(),
+	}
+	var stale = &types.ObjectMetadata{ // 100s old, stale for 30s
+		ResponseTimestamp: now.Add(-100 * time.Second).Unix(),
+		ExpiresAt:         now.Add(-30 * time.Second).Unix(),
+	}
+	var tests = []struct {
+		obj                     *types.ObjectMetadata
+		cacheControl            string
+		satisfies, revalidating bool
+	}{
+		{fresh, "", true, true},
+		{fresh, "no-cache", false, false},
+		{fresh, "no-store", false, false},
+		{fresh, "max-age=0", false, false},
+		{fresh, "max-age=150", true, true},
+		{fresh, "max-age=50, max-stale", false, false},
+		{fresh, `max-age="150"`, true, true},
+		{fresh, "max-age=invalid", true, true},
+		{fresh, "min-fresh=100", true, true},
+		{fresh, "MIN-FRESH=300", false, false},
+		{fresh, "only-if-cached", true, true},
+		{stale, "", false, true},
+		{stale, "max-age=150", false, true},
+		{stale, "max-stale", true, true},
+		{stale, "max-stale=60", true, true},
+		{stale, "max-stale=10", false, true},
+		{stale, "max-age=150, max-stale=60", true, true},
+		{stale, "max-age=50, max-stale=60", false, false},
+		{stale, "max-stale, min-fresh=0", false, false},
+		{stale, "max-stale, no-cache", false, false},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", "http://example.com/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.cacheControl != "" {
+			req.Header.Set("Cache-Control", test.cacheControl)
+		}
+		if got := CacheSatisfiesRequest(test.obj, req); got != test.satisfies {
+			t.Errorf("expected CacheSatisfiesRequest for '%s' and expiration in %ds to be %t",
+				test.cacheControl, test.obj.ExpiresAt-now.Unix(), test.satisfies)
+		}
+		if got := CacheSatisfiesRequestWhileRevalidating(test.obj, req); got != test.revalidating {
+			t.Errorf("expected CacheSatisfiesRequestWhileRevalidating for '%s' and expiration in %ds to be %t",
+				test.cacheControl, test.obj.ExpiresAt-now.Unix(), test.revalidating)
+		}
+	}
+}
+
+func TestOnlyIfCached(t *testing.T) {
+	t.Parallel()
+	var tests = map[string]bool{
+		"":                           false,
+		"only-if-cached":             true,
+		"max-age=10, Only-If-Cached": true,
+		"no-cache":                   false,
+	}
+	for cacheControl, expected := range tests {
+		req, err := http.NewRequest("GET", "http://example.com/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Cache-Control", cacheControl)
+		if got := OnlyIfCached(req); got != expected {
+			t.Errorf("expected OnlyIfCached for '%s' to be %t", cacheControl, expected)
+		}
+	}
+}
+
+func TestResponseTags(t *testing.T) {
+	t.Parallel()
+	var headers = http.Header{
+		"Surrogate-Key": {"video-1  videos", "video-1-480p"},
+		"Cache-Tag":     {"videos, video-1-720p,"},
+	}
+	var expected = []string{"video-1", "videos", "video-1-480p", "video-1-720p"}
+	if got := ResponseTags(headers); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the tags to be %v but got %v", expected, got)
+	}
+	if got := ResponseTags(http.Header{}); len(got) != 0 {
+		t.Errorf("expected no tags but got %v", got)
+	}
+}
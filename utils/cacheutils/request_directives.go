@@ -0,0 +1,65 @@
+package cacheutils
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// requestDirectives are the Cache-Control directives of a client request:
+// https://tools.ietf.org/html/rfc7234#section-5.2.1
+// The durations are in seconds and are negative when they are not present.
+type requestDirectives struct {
+	maxAge       int64
+	maxStale     int64
+	maxStaleAny  bool
+	minFresh     int64
+	noCache      bool
+	noStore      bool
+	onlyIfCached bool
+}
+
+// parseRequestDirectives parses the Cache-Control header of the request.
+// Unlike cacheobject.ParseRequestCacheControl, it does not fail on max-stale
+// without a value and ignores the directives with invalid values.
+func parseRequestDirectives(req *http.Request) *requestDirectives {
+	dir := &requestDirectives{maxAge: -1, maxStale: -1, minFresh: -1}
+	for _, header := range req.Header["Cache-Control"] {
+		for _, directive := range strings.Split(header, ",") {
+			name, value := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, value = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "max-age":
+				dir.maxAge = parseDeltaSeconds(value)
+			case "max-stale":
+				if value == "" {
+					dir.maxStaleAny = true
+				} else {
+					dir.maxStale = parseDeltaSeconds(value)
+				}
+			case "min-fresh":
+				dir.minFresh = parseDeltaSeconds(value)
+			case "no-cache":
+				dir.noCache = true
+			case "no-store":
+				dir.noStore = true
+			case "only-if-cached":
+				dir.onlyIfCached = true
+			}
+		}
+	}
+	return dir
+}
+
+// parseDeltaSeconds returns the non-negative number of seconds in the value
+// or -1 if it is invalid.
+func parseDeltaSeconds(value string) int64 {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return -1
+	}
+	return seconds
+}
@@ -0,0 +1,45 @@
+package cacheutils
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ParseVary returns the sorted and deduplicated list of canonical header names
+// from the Vary headers of an upstream response.
+func ParseVary(headers http.Header) []string {
+	var result []string
+	var seen = make(map[string]struct{})
+	for _, value := range headers["Vary"] {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if _, ok := seen[name]; ok || name == "" {
+				continue
+			}
+			seen[name] = struct{}{}
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// VariantKey returns the key of the variant selected by the request headers,
+// given the list of header names the response varies on. The key is a hash of
+// the normalized header values, so that values like cookies do not end up in
+// the storage and in the logs.
+func VariantKey(vary []string, reqHeaders http.Header) string {
+	var parts = make([]string, len(vary))
+	for i, name := range vary {
+		var values = make([]string, len(reqHeaders[name]))
+		for j, value := range reqHeaders[name] {
+			values[j] = strings.TrimSpace(value)
+		}
+		parts[i] = name + ":" + strings.Join(values, ",")
+	}
+	hash := sha1.Sum([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(hash[:])
+}
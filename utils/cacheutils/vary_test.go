@@ -0,0 +1,49 @@
+package cacheutils
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseVary(t *testing.T) {
+	t.Parallel()
+	var headers = http.Header{
+		"Vary": []string{"origin, Accept-Language", " Origin ,accept-encoding,"},
+	}
+	var expected = []string{"Accept-Encoding", "Accept-Language", "Origin"}
+	if got := ParseVary(headers); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v but got %v", expected, got)
+	}
+	if got := ParseVary(http.Header{}); len(got) != 0 {
+		t.Errorf("expected no headers but got %v", got)
+	}
+}
+
+func TestVariantKey(t *testing.T) {
+	t.Parallel()
+	var vary = []string{"Accept-Language", "Origin"}
+	var en = http.Header{"Accept-Language": []string{"en"}, "Origin": []string{"example.com"}}
+	var enSpaced = http.Header{"Accept-Language": []string{" en "}, "Origin": []string{"example.com"}}
+	var bg = http.Header{"Accept-Language": []string{"bg"}, "Origin": []string{"example.com"}}
+	var noOrigin = http.Header{"Accept-Language": []string{"en"}}
+
+	if VariantKey(vary, en) != VariantKey(vary, enSpaced) {
+		t.Error("expected the same variant for values which differ only by whitespace")
+	}
+	if VariantKey(vary, en) == VariantKey(vary, bg) {
+		t.Error("expected different variants for different languages")
+	}
+	if VariantKey(vary, en) == VariantKey(vary, noOrigin) {
+		t.Error("expected different variants when a header is missing")
+	}
+	if VariantKey(vary, en) == VariantKey(nil, en) {
+		t.Error("expected different variants for different vary lists")
+	}
+
+	var cookie = http.Header{"Cookie": []string{"session=secret"}}
+	if key := VariantKey([]string{"Cookie"}, cookie); strings.Contains(key, "secret") {
+		t.Errorf("expected the header values not to be in the variant key '%s'", key)
+	}
+}
@@ -3,6 +3,7 @@ package utils
 
 import (
 	"errors"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -27,6 +28,38 @@ func IsMetadataFresh(obj *types.ObjectMetadata) bool {
 	return time.Unix(obj.ExpiresAt, 0).After(time.Now())
 }
 
+// CopyMetadata returns a copy of the metadata which does not share any
+// mutable fields with it, so that either of them can be modified.
+func CopyMetadata(m *types.ObjectMetadata) *types.ObjectMetadata {
+	result := *m
+	result.Headers = make(http.Header, len(m.Headers))
+	for key, values := range m.Headers {
+		result.Headers[key] = append([]string(nil), values...)
+	}
+	result.Vary = append([]string(nil), m.Vary...)
+	result.Variants = append([]string(nil), m.Variants...)
+	result.Tags = append([]string(nil), m.Tags...)
+	if m.RequestHeaders != nil {
+		result.RequestHeaders = make(http.Header, len(m.RequestHeaders))
+		for key, values := range m.RequestHeaders {
+			result.RequestHeaders[key] = append([]string(nil), values...)
+		}
+	}
+	return &result
+}
+
+// CanServeWhileRevalidating returns whether the stale object is still within
+// its stale-while-revalidate period and can be served while it is revalidated.
+func CanServeWhileRevalidating(obj *types.ObjectMetadata) bool {
+	return time.Unix(obj.ExpiresAt+obj.StaleWhileRevalidate, 0).After(time.Now())
+}
+
+// CanServeOnError returns whether the stale object is still within its
+// stale-if-error period and can be served when the upstream is failing.
+func CanServeOnError(obj *types.ObjectMetadata) bool {
+	return time.Unix(obj.ExpiresAt+obj.StaleIfError, 0).After(time.Now())
+}
+
 // ProjectPath returns a path to the project source as an absolute directory name.
 func ProjectPath() (string, error) {
 	gopath := os.ExpandEnv("$GOPATH")
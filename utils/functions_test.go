@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -88,3 +89,32 @@ func TestIsMetadataFresh(t *testing.T) {
 
 	}
 }
+
+func TestCopyMetadata(t *testing.T) {
+	t.Parallel()
+	obj := &types.ObjectMetadata{
+		ID:       types.NewObjectID("key", "/path"),
+		Code:     200,
+		Headers:  http.Header{"Etag": {`"tag"`}},
+		Vary:     []string{"Accept-Encoding"},
+		Variants: []string{"gzip"},
+		Tags:     []string{"tag"},
+
+		RequestURL:     "//example.com/path",
+		RequestHeaders: http.Header{"Accept-Language": {"en"}},
+	}
+	copied := CopyMetadata(obj)
+	if !reflect.DeepEqual(copied, obj) {
+		t.Fatalf("Original and copied objects differ: '%#v', '%#v'", obj, copied)
+	}
+
+	copied.Headers["Etag"][0] = `"other"`
+	copied.Headers.Set("Age", "5")
+	copied.Vary[0], copied.Variants[0], copied.Tags[0] = "", "", ""
+	copied.RequestHeaders["Accept-Language"][0] = "de"
+	if obj.Headers.Get("Etag") != `"tag"` || obj.Headers.Get("Age") != "" ||
+		obj.Vary[0] == "" || obj.Variants[0] == "" || obj.Tags[0] == "" ||
+		obj.RequestHeaders.Get("Accept-Language") != "en" {
+		t.Errorf("Modifying the copy changed the original object: '%#v'", obj)
+	}
+}
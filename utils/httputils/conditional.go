@@ -0,0 +1,113 @@
+package httputils
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CheckPreconditions evaluates the conditional headers of the request against
+// the headers of the selected representation in the order defined in
+// https://tools.ietf.org/html/rfc7232#section-6. It returns http.StatusOK when
+// the request should be served as usual, http.StatusNotModified or
+// http.StatusPreconditionFailed otherwise. If-Range is not evaluated here, see
+// IfRangeMatches.
+func CheckPreconditions(req *http.Request, headers http.Header) int {
+	var etag = headers.Get("ETag")
+	var lastModified, hasLastModified = parseHeaderTime(headers.Get("Last-Modified"))
+
+	if ifMatch := req.Header.Get("If-Match"); ifMatch != "" {
+		if !etagListMatches(ifMatch, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := parseHeaderTime(req.Header.Get("If-Unmodified-Since")); ok && hasLastModified {
+		if lastModified.After(since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	var isGetOrHead = req.Method == "GET" || req.Method == "HEAD"
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagListMatches(ifNoneMatch, etag, false) {
+			if isGetOrHead {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := parseHeaderTime(req.Header.Get("If-Modified-Since")); ok && hasLastModified && isGetOrHead {
+		if !lastModified.After(since) {
+			return http.StatusNotModified
+		}
+	}
+
+	return http.StatusOK
+}
+
+// IfRangeMatches returns whether the Range header of the request should be
+// honoured according to its If-Range header:
+// https://tools.ietf.org/html/rfc7233#section-3.2. It is true when there is no
+// If-Range header or its validator matches the representation's headers.
+func IfRangeMatches(req *http.Request, headers http.Header) bool {
+	var ifRange = req.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etagsMatch(ifRange, headers.Get("ETag"), true)
+	}
+
+	since, ok := parseHeaderTime(ifRange)
+	if !ok {
+		return false
+	}
+	lastModified, ok := parseHeaderTime(headers.Get("Last-Modified"))
+	return ok && lastModified.Equal(since)
+}
+
+// NotModifiedHeaders are the representation headers which should be sent in
+// a 304 (Not Modified) response: https://tools.ietf.org/html/rfc7232#section-4.1
+var NotModifiedHeaders = []string{
+	"Cache-Control",
+	"Content-Location",
+	"Date",
+	"ETag",
+	"Expires",
+	"Last-Modified",
+	"Vary",
+}
+
+func parseHeaderTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(value)
+	return t, err == nil
+}
+
+// etagListMatches checks whether the comma separated list of entity tags from
+// If-Match or If-None-Match contains the supplied etag. "*" matches any
+// existing representation.
+func etagListMatches(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		if etagsMatch(strings.TrimSpace(candidate), etag, strong) {
+			return true
+		}
+	}
+	return false
+}
+
+// etagsMatch compares two entity tags with the strong or weak comparison
+// function from https://tools.ietf.org/html/rfc7232#section-2.3.2
+func etagsMatch(a, b string, strong bool) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	var aWeak, bWeak = strings.HasPrefix(a, "W/"), strings.HasPrefix(b, "W/")
+	if strong && (aWeak || bWeak) {
+		return false
+	}
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
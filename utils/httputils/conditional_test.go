@@ -0,0 +1,90 @@
+package httputils
+
+import (
+	"net/http"
+	"testing"
+)
+
+const (
+	testLastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	testBefore       = "Sun, 01 Jan 2006 15:04:05 GMT"
+	testAfter        = "Tue, 03 Jan 2006 15:04:05 GMT"
+)
+
+var testRepresentation = http.Header{
+	"Etag":          []string{`"v1"`},
+	"Last-Modified": []string{testLastModified},
+}
+
+type conditionalTest struct {
+	method  string
+	headers map[string]string
+	exp     int
+}
+
+var conditionalTests = []conditionalTest{
+	{headers: nil, exp: http.StatusOK},
+	{headers: map[string]string{"If-None-Match": `"v1"`}, exp: http.StatusNotModified},
+	{headers: map[string]string{"If-None-Match": `W/"v1"`}, exp: http.StatusNotModified},
+	{headers: map[string]string{"If-None-Match": `"v0", "v1"`}, exp: http.StatusNotModified},
+	{headers: map[string]string{"If-None-Match": "*"}, exp: http.StatusNotModified},
+	{headers: map[string]string{"If-None-Match": `"v2"`}, exp: http.StatusOK},
+	{headers: map[string]string{"If-None-Match": `"v1"`}, method: "POST", exp: http.StatusPreconditionFailed},
+	{headers: map[string]string{"If-Modified-Since": testLastModified}, exp: http.StatusNotModified},
+	{headers: map[string]string{"If-Modified-Since": testAfter}, exp: http.StatusNotModified},
+	{headers: map[string]string{"If-Modified-Since": testBefore}, exp: http.StatusOK},
+	{headers: map[string]string{"If-Modified-Since": "garbage"}, exp: http.StatusOK},
+	{headers: map[string]string{"If-None-Match": `"v2"`, "If-Modified-Since": testAfter}, exp: http.StatusOK},
+	{headers: map[string]string{"If-Match": `"v1"`}, exp: http.StatusOK},
+	{headers: map[string]string{"If-Match": `W/"v1"`}, exp: http.StatusPreconditionFailed},
+	{headers: map[string]string{"If-Match": `"v2"`}, exp: http.StatusPreconditionFailed},
+	{headers: map[string]string{"If-Match": "*"}, exp: http.StatusOK},
+	{headers: map[string]string{"If-Unmodified-Since": testAfter}, exp: http.StatusOK},
+	{headers: map[string]string{"If-Unmodified-Since": testBefore}, exp: http.StatusPreconditionFailed},
+	{headers: map[string]string{"If-Match": `"v1"`, "If-Unmodified-Since": testBefore}, exp: http.StatusOK},
+	{headers: map[string]string{"If-Match": `"v1"`, "If-None-Match": `"v1"`}, exp: http.StatusNotModified},
+}
+
+func newConditionalRequest(method string, headers map[string]string) *http.Request {
+	if method == "" {
+		method = "GET"
+	}
+	req, err := http.NewRequest(method, "http://example.com/", nil)
+	if err != nil {
+		panic(err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return req
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	t.Parallel()
+	for _, test := range conditionalTests {
+		req := newConditionalRequest(test.method, test.headers)
+		if got := CheckPreconditions(req, testRepresentation); got != test.exp {
+			t.Errorf("for %s %v expected %d but got %d", req.Method, test.headers, test.exp, got)
+		}
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	t.Parallel()
+	var tests = map[string]bool{
+		"":               true,
+		`"v1"`:           true,
+		`"v2"`:           false,
+		`W/"v1"`:         false,
+		testLastModified: true,
+		testAfter:        false,
+		testBefore:       false,
+		"garbage":        false,
+	}
+	for ifRange, exp := range tests {
+		req := newConditionalRequest("GET", map[string]string{"If-Range": ifRange})
+		if got := IfRangeMatches(req, testRepresentation); got != exp {
+			t.Errorf("for If-Range '%s' expected %t but got %t", ifRange, exp, got)
+		}
+	}
+}
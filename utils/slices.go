@@ -6,3 +6,17 @@ func CopyStringSlice(from []string) []string {
 	copy(res, from)
 	return res
 }
+
+// EqualStringSlices returns whether the slices contain the same strings in
+// the same order. Nil and empty slices are equal.
+func EqualStringSlices(l, r []string) bool {
+	if len(l) != len(r) {
+		return false
+	}
+	for i := range l {
+		if l[i] != r[i] {
+			return false
+		}
+	}
+	return true
+}
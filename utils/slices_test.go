@@ -16,3 +16,20 @@ func TestCopyStringSlice(t *testing.T) {
 		t.Errorf("expected '%+v' and '%+v' to not be deeply equal", l, r)
 	}
 }
+
+func TestEqualStringSlices(t *testing.T) {
+	var tests = []struct {
+		l, r  []string
+		equal bool
+	}{
+		{nil, []string{}, true},
+		{[]string{"1", "2"}, []string{"1", "2"}, true},
+		{[]string{"1", "2"}, []string{"2", "1"}, false},
+		{[]string{"1"}, []string{"1", "2"}, false},
+	}
+	for _, test := range tests {
+		if got := EqualStringSlices(test.l, test.r); got != test.equal {
+			t.Errorf("expected EqualStringSlices('%+v', '%+v') to be %t", test.l, test.r, test.equal)
+		}
+	}
+}